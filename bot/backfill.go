@@ -0,0 +1,494 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// backfillRequest recovers the merged CLs the poller missed, in a range
+	// of CL numbers or of merge dates
+	backfillRequest struct {
+		from, to     int
+		since, until time.Time
+		mode         string
+		dryRun       bool
+	}
+
+	backfilledCL struct {
+		Number  int    `json:"number"`
+		URL     string `json:"url"`
+		Message string `json:"message"`
+		// Channels are where the CL is posted, or would be
+		Channels []string `json:"channels,omitempty"`
+	}
+
+	// backfillReport says what a backfill did, or would do in a dry run
+	backfillReport struct {
+		Range   string `json:"range"`
+		Mode    string `json:"mode"`
+		DryRun  bool   `json:"dry_run"`
+		Fetched int    `json:"fetched"`
+		// Known CLs were already in the store and are left alone
+		Known  []int          `json:"known"`
+		Stored []backfilledCL `json:"stored"`
+		Failed []int          `json:"failed"`
+	}
+)
+
+// How the backfilled CLs are posted
+const (
+	// BackfillPost posts every CL the way the poller does
+	BackfillPost = "post"
+	// BackfillSummary posts one list of the CLs in each of their channels
+	BackfillSummary = "summary"
+	// BackfillSilent only records the CLs
+	BackfillSilent = "silent"
+)
+
+const (
+	// maxBackfillCLs limits how many CLs a backfill fetches
+	maxBackfillCLs = 500
+	// maxBackfillListed is how many CLs the report lists in the chat
+	maxBackfillListed = 30
+	// backfillSummaryCLs is how many CLs a summary message lists
+	backfillSummaryCLs = 50
+)
+
+var (
+	backfillModes = []string{BackfillPost, BackfillSummary, BackfillSilent}
+
+	// backfillOptions ask Gerrit for what the poller gets
+	backfillOptions = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "DETAILED_ACCOUNTS"}
+
+	clRangeRE   = regexp.MustCompile(`^#?(\d+)(?:-#?(\d+))?$`)
+	dateRangeRE = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(?:\.\.(\d{4}-\d{2}-\d{2}))?$`)
+)
+
+// newBackfillRequest reads a range such as 1000-1100 or 2018-01-20..2018-01-22,
+// the dates are in UTC and the last day is included
+func newBackfillRequest(text, mode string, dryRun bool) (backfillRequest, error) {
+	req := backfillRequest{mode: strings.ToLower(mode), dryRun: dryRun}
+	if req.mode == "" {
+		req.mode = BackfillSummary
+	}
+	known := false
+	for _, kind := range backfillModes {
+		known = known || req.mode == kind
+	}
+	if !known {
+		return req, fmt.Errorf("unknown mode %q, use one of %s", mode, strings.Join(backfillModes, ", "))
+	}
+
+	text = strings.TrimSpace(text)
+	if match := clRangeRE.FindStringSubmatch(text); match != nil {
+		req.from, _ = strconv.Atoi(match[1])
+		req.to = req.from
+		if match[2] != "" {
+			req.to, _ = strconv.Atoi(match[2])
+		}
+		if req.from <= 0 || req.to < req.from {
+			return req, fmt.Errorf("invalid range of CLs %q", text)
+		}
+		if req.to-req.from >= maxBackfillCLs {
+			return req, fmt.Errorf("a backfill can't go over more than %d CLs", maxBackfillCLs)
+		}
+		return req, nil
+	}
+
+	match := dateRangeRE.FindStringSubmatch(text)
+	if match == nil {
+		return req, fmt.Errorf("invalid range %q, use CL numbers such as 1000-1100 or dates such as 2018-01-20..2018-01-22", text)
+	}
+	var err error
+	req.since, err = time.ParseInLocation("2006-01-02", match[1], time.UTC)
+	if err != nil {
+		return req, fmt.Errorf("invalid date %q", match[1])
+	}
+	last := req.since
+	if match[2] != "" {
+		if last, err = time.ParseInLocation("2006-01-02", match[2], time.UTC); err != nil {
+			return req, fmt.Errorf("invalid date %q", match[2])
+		}
+	}
+	req.until = last.AddDate(0, 0, 1)
+	if !req.until.After(req.since) {
+		return req, fmt.Errorf("invalid range of dates %q", text)
+	}
+	return req, nil
+}
+
+func (req backfillRequest) rangeText() string {
+	if req.from > 0 {
+		if req.from == req.to {
+			return fmt.Sprintf("CL %d", req.from)
+		}
+		return fmt.Sprintf("CLs %d-%d", req.from, req.to)
+	}
+	last := req.until.AddDate(0, 0, -1)
+	if last.Equal(req.since) {
+		return fmt.Sprintf("CLs merged on %s", req.since.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("CLs merged from %s to %s", req.since.Format("2006-01-02"), last.Format("2006-01-02"))
+}
+
+// fetchMergedCLs fetches the CLs merged during the period, up to maxBackfillCLs
+func (b *Bot) fetchMergedCLs(ctx context.Context, since, until time.Time) ([]gerritCL, error) {
+	query := url.Values{
+		"q": {fmt.Sprintf(`status:merged mergedafter:"%s" mergedbefore:"%s"`,
+			since.UTC().Format(gerritQueryTimeLayout), until.UTC().Format(gerritQueryTimeLayout))},
+		"o": backfillOptions,
+		"n": {"100"},
+	}
+
+	count := 0
+	cls, more, err := b.gerritPages(ctx, query, func(cl gerritCL) bool {
+		count++
+		return count > maxBackfillCLs
+	})
+	if err != nil {
+		return nil, err
+	}
+	if more || len(cls) > maxBackfillCLs {
+		return nil, fmt.Errorf("more than %d CLs were merged, use a shorter range", maxBackfillCLs)
+	}
+	return cls, nil
+}
+
+// mergedAt returns when the CL was submitted, or now when Gerrit didn't say
+func (cl *gerritCL) mergedAt() time.Time {
+	submitted, err := parseGerritTime(cl.Submitted)
+	if err != nil {
+		return time.Now()
+	}
+	return submitted
+}
+
+// lastCrawledAt returns when the last seen CL was crawled, or the zero time
+func (b *Bot) lastCrawledAt(ctx context.Context) (time.Time, error) {
+	lastID, err := b.GetLastSeenCL(ctx)
+	if err != nil || lastID == -1 {
+		return time.Time{}, err
+	}
+	last, err := b.store.GetCL(ctx, lastID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not load the last seen CL %d: %v", lastID, err)
+	}
+	return last.CrawledAt, nil
+}
+
+// runBackfill fetches the CLs of the range which aren't in the store, and
+// records and posts them as asked
+func (b *Bot) runBackfill(ctx context.Context, req backfillRequest) (*backfillReport, error) {
+	report := &backfillReport{
+		Range:  req.rangeText(),
+		Mode:   req.mode,
+		DryRun: req.dryRun,
+		Known:  []int{},
+		Stored: []backfilledCL{},
+		Failed: []int{},
+	}
+
+	cls := []gerritCL{}
+	if req.from > 0 {
+		for number := req.from; number <= req.to; number++ {
+			if _, err := b.store.GetCL(ctx, number); err == nil {
+				report.Known = append(report.Known, number)
+				continue
+			} else if err != ErrCLNotFound {
+				return nil, err
+			}
+
+			cl, err := b.fetchCL(ctx, number, backfillOptions)
+			if err == errGerritNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not fetch CL %d: %v", number, err)
+			}
+			if cl.Status == gerritMerged {
+				cls = append(cls, cl)
+			}
+		}
+	} else {
+		fetched, err := b.fetchMergedCLs(ctx, req.since, req.until)
+		if err != nil {
+			return nil, err
+		}
+		for _, cl := range fetched {
+			if _, err := b.store.GetCL(ctx, cl.Number); err == nil {
+				report.Known = append(report.Known, cl.Number)
+				continue
+			} else if err != ErrCLNotFound {
+				return nil, err
+			}
+			cls = append(cls, cl)
+		}
+	}
+	report.Fetched = len(cls) + len(report.Known)
+
+	// posted in the order they were merged
+	sort.Slice(cls, func(i, j int) bool {
+		if cls[i].Submitted != cls[j].Submitted {
+			return cls[i].Submitted < cls[j].Submitted
+		}
+		return cls[i].Number < cls[j].Number
+	})
+
+	b.clsMu.Lock()
+	defer b.clsMu.Unlock()
+
+	lastCrawled, err := b.lastCrawledAt(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := []Subscription{}
+	if req.mode == BackfillPost && !req.dryRun {
+		if subscriptions, err = b.store.Subscriptions(ctx); err != nil {
+			b.logf("could not load the subscriptions, nobody is notified: %v\n", err)
+		}
+	}
+
+	for _, cl := range cls {
+		// the poller may have caught up with it since it was fetched
+		if shown, err := b.wasShown(ctx, cl); err != nil || shown {
+			if err != nil {
+				b.logf("could not backfill CL %d: %v\n", cl.Number, err)
+				report.Failed = append(report.Failed, cl.Number)
+			} else {
+				report.Known = append(report.Known, cl.Number)
+			}
+			continue
+		}
+
+		entry := backfilledCL{Number: cl.Number, URL: cl.link(), Message: cl.message()}
+		if req.mode != BackfillSilent {
+			entry.Channels = b.routeCL(cl)
+		}
+		if req.dryRun {
+			report.Stored = append(report.Stored, entry)
+			continue
+		}
+
+		// recorded as crawled when merged, but before the last seen CL, the
+		// poller starts from it and would skip the CLs merged in between
+		crawledAt := cl.mergedAt()
+		if !lastCrawled.IsZero() && !crawledAt.Before(lastCrawled) {
+			crawledAt = lastCrawled.Add(-time.Microsecond)
+		}
+		stored := newStoredCL(cl, crawledAt)
+		if req.mode == BackfillPost {
+			posted, err := b.postMergedCL(ctx, cl, stored, subscriptions)
+			if err != nil || !posted {
+				b.logf("could not backfill CL %d: %v\n", cl.Number, err)
+				report.Failed = append(report.Failed, cl.Number)
+				continue
+			}
+		} else if err := b.store.SaveCL(ctx, stored); err != nil {
+			b.logf("could not backfill CL %d: %v\n", cl.Number, err)
+			report.Failed = append(report.Failed, cl.Number)
+			continue
+		}
+		report.Stored = append(report.Stored, entry)
+	}
+
+	sort.Ints(report.Known)
+	if req.mode == BackfillSummary && !req.dryRun {
+		b.postBackfillSummary(ctx, report.Stored)
+	}
+	return report, nil
+}
+
+// postBackfillSummary posts in each channel the list of the CLs routed to it
+func (b *Bot) postBackfillSummary(ctx context.Context, cls []backfilledCL) {
+	names := []string{}
+	byChannel := map[string][]backfilledCL{}
+	for _, cl := range cls {
+		for _, name := range cl.Channels {
+			if _, ok := byChannel[name]; !ok {
+				names = append(names, name)
+			}
+			byChannel[name] = append(byChannel[name], cl)
+		}
+	}
+	sort.Strings(names)
+
+	params := slack.PostMessageParameters{AsUser: true}
+	for _, name := range names {
+		channel := strings.TrimPrefix(b.channel(name).slackID, "#")
+		list := byChannel[name]
+
+		for start := 0; start < len(list); start += backfillSummaryCLs {
+			end := start + backfillSummaryCLs
+			if end > len(list) {
+				end = len(list)
+			}
+
+			buff := &bytes.Buffer{}
+			if start == 0 {
+				buff.WriteString(fmt.Sprintf("I missed %s merged earlier, here they are", plural(len(list), "CL")))
+			}
+			for _, cl := range list[start:end] {
+				buff.WriteString(fmt.Sprintf("\n• <%s|CL %d> %s", cl.URL, cl.Number, escapeMrkdwn(cl.Message)))
+			}
+
+			if _, _, err := b.chat.PostMessage(ctx, channel, strings.TrimSpace(buff.String()), params); err != nil {
+				b.logf("could not post the backfill summary in %s: %v\n", name, err)
+			}
+		}
+	}
+}
+
+func (r *backfillReport) text() string {
+	buff := &bytes.Buffer{}
+	verb := "stored"
+	if r.DryRun {
+		verb = "would be stored"
+		buff.WriteString("Dry run of the backfill")
+	} else {
+		buff.WriteString("Backfill")
+	}
+	buff.WriteString(fmt.Sprintf(" of the %s in %s mode: %s fetched, %d already known, %d %s",
+		r.Range, r.Mode, plural(r.Fetched, "merged CL"), len(r.Known), len(r.Stored), verb))
+	if len(r.Failed) > 0 {
+		buff.WriteString(fmt.Sprintf(", %d failed", len(r.Failed)))
+	}
+
+	for idx, cl := range r.Stored {
+		if idx == maxBackfillListed {
+			buff.WriteString(fmt.Sprintf("\nand %d more", len(r.Stored)-maxBackfillListed))
+			break
+		}
+		buff.WriteString(fmt.Sprintf("\n• <%s|CL %d> %s", cl.URL, cl.Number, escapeMrkdwn(cl.Message)))
+		if len(cl.Channels) > 0 {
+			buff.WriteString(" → #" + strings.Join(cl.Channels, ", #"))
+		}
+	}
+
+	if len(r.Failed) > 0 {
+		failed := []string{}
+		for _, number := range r.Failed {
+			failed = append(failed, strconv.Itoa(number))
+		}
+		buff.WriteString("\nFailed: " + strings.Join(failed, ", "))
+	}
+	return buff.String()
+}
+
+func backfillCLs(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	usage := fmt.Sprintf(`Usage: "backfill <from>-<to> | <YYYY-MM-DD>..<YYYY-MM-DD> [%s] [dry-run]"`, strings.Join(backfillModes, " | "))
+
+	fields := strings.Fields(b.arguments(event, "backfill"))
+	if len(fields) == 0 {
+		b.directReply(ctx, event, usage)
+		return
+	}
+	mode, dryRun := "", false
+	for _, field := range fields[1:] {
+		switch field = strings.ToLower(field); {
+		case field == "dry-run" || field == "dryrun":
+			dryRun = true
+		case mode == "":
+			mode = field
+		default:
+			b.directReply(ctx, event, usage)
+			return
+		}
+	}
+
+	req, err := newBackfillRequest(fields[0], mode, dryRun)
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\n%s", err, usage))
+		return
+	}
+
+	report, err := b.runBackfill(ctx, req)
+	if err != nil {
+		b.logf("could not backfill the %s: %v\n", req.rangeText(), err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not backfill the %s: %v", req.rangeText(), err))
+		return
+	}
+
+	if !req.dryRun {
+		b.audit(ctx, AuditEntry{
+			User:    event.User,
+			Channel: event.Channel,
+			Action:  "backfill",
+			Allowed: true,
+			Details: fmt.Sprintf("%s in %s mode, %d stored", report.Range, report.Mode, len(report.Stored)),
+		})
+	}
+	b.directReply(ctx, event, report.text())
+}
+
+// BackfillHandler backfills the merged CLs over HTTP, for the requests with
+// the token as bearer. The form has the range, the mode and dry_run. The
+// backfills, dry runs included, can take longer than the server waits so they
+// are started under ctx, which stops them on shutdown, and their report is
+// sent to the admins.
+func (b *Bot) BackfillHandler(ctx context.Context, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestSpan := b.tracer.SpanFromRequest(r)
+		defer requestSpan.Finish()
+
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+		req, err := newBackfillRequest(r.FormValue("range"), r.FormValue("mode"), dryRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b.Go(func() {
+			span := b.tracer.NewSpan("b.Backfill")
+			defer span.Finish()
+			ctx := NewSpanContext(ctx, span)
+
+			report, err := b.runBackfill(ctx, req)
+			if err != nil && ctx.Err() != nil {
+				b.logf("the backfill of the %s was stopped by the shutdown: %v\n", req.rangeText(), err)
+				return
+			}
+			if err != nil {
+				b.logf("could not backfill the %s: %v\n", req.rangeText(), err)
+				b.alertAdmins(ctx, fmt.Sprintf("Could not backfill the %s: %v", req.rangeText(), err))
+				return
+			}
+			if !req.dryRun {
+				b.audit(ctx, AuditEntry{
+					User:    "http",
+					Action:  "backfill",
+					Allowed: true,
+					Details: fmt.Sprintf("%s in %s mode, %d stored", report.Range, report.Mode, len(report.Stored)),
+				})
+			}
+			b.alertAdmins(ctx, report.text())
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "started", "range": req.rangeText(), "mode": req.mode, "dry_run": req.dryRun})
+	}
+}
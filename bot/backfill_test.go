@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchMergedCLsPaginates(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 250)
+
+	// 3 CLs merged before the second page push 3 CLs of the first page to it
+	starts := []string{}
+	link := newGerritServer(t, gerrit, func(r *http.Request) {
+		start := r.URL.Query().Get("S")
+		if start == "100" {
+			mergeCLs(gerrit, 3)
+		}
+		starts = append(starts, start)
+	})
+	b, _, _ := newTestBot(t, NewMemoryStore(), link)
+
+	now := time.Now()
+	cls, err := b.fetchMergedCLs(context.Background(), now.AddDate(0, 0, -1), now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(starts, ","), ",100,200"; got != want {
+		t.Errorf("fetched the pages starting at %q, want %q", got, want)
+	}
+
+	seen := map[int]bool{}
+	for _, cl := range cls {
+		if seen[cl.Number] {
+			t.Errorf("CL %d was fetched twice", cl.Number)
+		}
+		seen[cl.Number] = true
+	}
+	// the CLs merged since the first page are left out
+	if len(cls) != 250 {
+		t.Errorf("got %d CLs, want 250", len(cls))
+	}
+}
+
+func TestFetchMergedCLsLimit(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, maxBackfillCLs+1)
+	b, _, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	now := time.Now()
+	if _, err := b.fetchMergedCLs(context.Background(), now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)); err == nil || !strings.Contains(err.Error(), "use a shorter range") {
+		t.Errorf("got the error %v, want the range to be too long", err)
+	}
+	if requests := gerrit.Requests(); requests != maxBackfillCLs/100+1 {
+		t.Errorf("got %d requests, want %d", requests, maxBackfillCLs/100+1)
+	}
+}
+
+// postBackfill calls the backfill endpoint of the bot with the form, and
+// returns the status once the backfill has run
+func postBackfill(t *testing.T, b *Bot, ctx context.Context, form url.Values) int {
+	r := httptest.NewRequest("POST", "/admin/backfill", strings.NewReader(form.Encode()))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	b.BackfillHandler(ctx, "secret").ServeHTTP(w, r)
+
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code
+}
+
+// adminMessages returns the direct messages sent to the admin
+func adminMessages(chat *FakeMessenger) []string {
+	texts := []string{}
+	for _, msg := range chat.Messages() {
+		if msg.Direct && msg.Channel == "UADMIN" {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+func TestBackfillHandlerDryRun(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 3)
+	b, chat, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	today := time.Now().UTC().Format("2006-01-02")
+	status := postBackfill(t, b, context.Background(), url.Values{"range": {today}, "dry_run": {"true"}})
+	if status != http.StatusAccepted {
+		t.Errorf("got the status %d, want %d", status, http.StatusAccepted)
+	}
+
+	messages := adminMessages(chat)
+	if len(messages) != 1 || !strings.HasPrefix(messages[0], "Dry run of the backfill") || !strings.Contains(messages[0], "3 would be stored") {
+		t.Errorf("got the admin messages %q, want the report of the dry run", messages)
+	}
+	if _, err := b.store.GetCL(context.Background(), 1000); err != ErrCLNotFound {
+		t.Errorf("the dry run stored CL 1000: %v", err)
+	}
+}
+
+func TestBackfillHandlerStopsOnShutdown(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 3)
+	b, chat, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status := postBackfill(t, b, ctx, url.Values{"range": {"1000-1002"}, "mode": {BackfillSilent}})
+	if status != http.StatusAccepted {
+		t.Errorf("got the status %d, want %d", status, http.StatusAccepted)
+	}
+
+	if _, err := b.store.GetCL(context.Background(), 1000); err != ErrCLNotFound {
+		t.Errorf("the backfill went on after the shutdown: %v", err)
+	}
+	if messages := adminMessages(chat); len(messages) != 0 {
+		t.Errorf("got the admin messages %q after the shutdown", messages)
+	}
+}
+
+func TestBackfillKeepsTheLastSeenCL(t *testing.T) {
+	// the first poll starts after CL 1000
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 2)
+	b, _, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	ctx := context.Background()
+	if err := b.pollGerrit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the range only covers the last of the CLs merged after the poll
+	mergeCLs(gerrit, 4)
+	req, err := newBackfillRequest("1004-1005", BackfillSilent, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.runBackfill(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if lastID, err := b.GetLastSeenCL(ctx); err != nil || lastID != 1001 {
+		t.Errorf("the last seen CL is %d after the backfill, want 1001: %v", lastID, err)
+	}
+
+	if err := b.pollGerrit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for number := 1001; number <= 1005; number++ {
+		if _, err := b.store.GetCL(ctx, number); err != nil {
+			t.Errorf("CL %d was not stored: %v", number, err)
+		}
+	}
+}
@@ -11,10 +11,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
-	"cloud.google.com/go/datastore"
-	"cloud.google.com/go/trace"
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/nlopes/slack"
 )
@@ -32,6 +30,11 @@ type (
 		Do(r *http.Request) (*http.Response, error)
 	}
 
+	// Tweeter posts tweets, *anaconda.TwitterApi is one
+	Tweeter interface {
+		PostTweet(status string, v url.Values) (anaconda.Tweet, error)
+	}
+
 	// Logger function
 	Logger func(message string, args ...interface{})
 
@@ -43,136 +46,89 @@ type (
 		name        string
 		token       string
 		version     string
-		users       map[string]string
 		client      Client
 		devMode     bool
 		emojiRE     *regexp.Regexp
 		slackLinkRE *regexp.Regexp
-		channels    map[string]slackChan
-		slackBotAPI *slack.Client
-		twitterAPI  *anaconda.TwitterApi
+		chat        Messenger
+		twitterAPI  Tweeter
 		logf        Logger
-		dsClient    *datastore.Client
-		traceClient *trace.Client
+		store       Store
+		tracer      Tracer
+		commands    *CommandRegistry
+		jobs        *Supervisor
+		leader      *LeaderElection
 
-		goTimeLastNotified time.Time
-	}
+		mu             sync.RWMutex
+		config         *Config
+		channels       map[string]slackChan
+		admins         map[string]string
+		roles          map[string]roleHolders
+		welcomeMessage string
 
-	slackHandler func(context.Context, *Bot, *slack.MessageEvent)
-)
+		userGroupsMu sync.Mutex
+		userGroups   map[string]cachedUserGroup
 
-var welcomeMessage = ""
+		handlers sync.WaitGroup
+
+		taskHandlersMu sync.RWMutex
+		taskHandlers   map[string]TaskHandler
+
+		reactionsMu      sync.RWMutex
+		reactionHandlers map[string][]ReactionHandler
+		seenEvents       seenEvents
+
+		clMentions clMentions
+		// clsMu serializes the poller and the backfills, so a CL isn't posted twice
+		clsMu sync.Mutex
+	}
+)
 
 // Init must be called before anything else in order to initialize the bot
-func (b *Bot) Init(ctx context.Context, rtm *slack.RTM, span *trace.Span) error {
+func (b *Bot) Init(ctx context.Context, span Span) error {
 	initSpan := span.NewChild("b.Init")
 	defer initSpan.Finish()
 
 	b.logf("Determining bot / user IDs")
 
-	b.id = "U1XK0CWSZ"
-	b.users = map[string]string{
-		"dlsniper": "U03L9MPTE",
-	}
-
-	b.msgprefix = strings.ToLower("<@" + b.id + ">")
-
-	b.logf("Determining channels ID\n")
-	childSpan := initSpan.NewChild("slackApi.GetChannels")
-	publicChannels, err := b.slackBotAPI.GetChannelsContext(ctx, true)
+	childSpan := initSpan.NewChild("slackApi.AuthTest")
+	self, err := b.chat.Identity(ctx)
 	childSpan.Finish()
 	if err != nil {
 		return err
 	}
+	b.id = self.ID
 
-	for _, channel := range publicChannels {
-		channelName := strings.ToLower(channel.Name)
-		if chn, ok := b.channels[channelName]; ok {
-			chn.slackID = "#" + channel.ID
-			b.channels[channelName] = chn
-		}
-	}
-
-	publicChannels = nil
+	b.msgprefix = strings.ToLower("<@" + b.id + ">")
 
-	b.logf("Determining groups ID\n")
-	childSpan = initSpan.NewChild("slackApi.GetGroups")
-	botGroups, err := b.slackBotAPI.GetGroupsContext(ctx, true)
-	childSpan.Finish()
-	for _, group := range botGroups {
-		groupName := strings.ToLower(group.Name)
-		if chn, ok := b.channels[groupName]; ok && b.channels[groupName].slackID == "" {
-			chn.slackID = group.ID
-			b.channels[groupName] = chn
-		}
+	if err := b.ApplyConfig(ctx, b.config, initSpan); err != nil {
+		return err
 	}
 
-	botGroups = nil
-
 	b.logf("Initialized %s with ID: %s\n", b.name, b.id)
-	params := slack.PostMessageParameters{AsUser: true}
 	childSpan = initSpan.NewChild("b.AnnouncingStartupFinish")
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, b.users["dlsniper"], fmt.Sprintf(`Deployed version: %s`, b.version), params)
+	b.notifyAdmins(ctx, fmt.Sprintf(`Deployed version: %s`, b.version))
 	childSpan.Finish()
 
-	if err != nil {
-		b.logf(`failed to deploy version: %s`, b.version)
-	}
-
-	welcomeMessage = `,
-
-
-Welcome to the Gophers Slack channel.
-This Slack is meant to connect gophers from all over the world in a central place.
-There is also a forum: https://forum.golangbridge.org, you might want to check it out as well.
-We have a few rules that you can see here: http://coc.golangbridge.org.
-
-Here's a list of a few channels you could join:
-`
-
-	for idx, val := range b.channels {
-		if !val.welcome {
-			continue
-		}
-		welcomeMessage += `<` + val.slackID + `|` + idx + `> -> ` + val.description + "\n"
-	}
-
-	welcomeMessage += `
-
-If you want more suggestions, type "recommended channels".
-There are quite a few other channels, depending on your interests or location (we have city / country wide channels).
-Just click on the channel list and search for anything that crosses your mind.
-
-To share code, you should use: https://play.golang.org/ as it makes it easy for others to help you.
-
-If you are new to Go and want a copy of the Go In Action book, https://www.manning.com/books/go-in-action, please send an email to @wkennedy at bill@ardanlabs.com
-
-If you are interested in a free copy of the Go Web Programming book by Sau Sheong Chang, @sausheong, please send him an email at sausheong@gmail.com
-
-In case you want to customize your profile picture, you can use https://gopherize.me/ to create a custom gopher.
-
-Final thing, #general might be too chatty at times but don't be shy to ask your Go related question.
-
-
-Now, enjoy the community and have fun.`
-
-	return err
+	return nil
 }
 
 // TeamJoined is called when the someone joins the team
 func (b *Bot) TeamJoined(event *slack.TeamJoinEvent) {
-	span := b.traceClient.NewSpan("b.TeamJoined")
+	span := b.tracer.NewSpan("b.TeamJoined")
 	defer span.Finish()
 
 	if b.devMode {
 		return
 	}
 
-	message := `Hello ` + event.User.Name + welcomeMessage
+	b.mu.RLock()
+	message := `Hello ` + event.User.Name + b.welcomeMessage
+	b.mu.RUnlock()
 
 	params := slack.PostMessageParameters{AsUser: true, LinkNames: 1}
-	ctx := trace.NewContext(context.Background(), span)
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User.ID, message, params)
+	ctx := NewSpanContext(context.Background(), span)
+	_, _, err := b.chat.DirectMessage(ctx, event.User.ID, message, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -203,152 +159,85 @@ func (b *Bot) trimBot(msg string) string {
 	return msg
 }
 
-// limit access to certain functionality
-func (b *Bot) specialRestrictions(restriction string, event *slack.MessageEvent) bool {
-	if restriction == "golang_cls" {
-		return event.Channel == b.channels["golang_cls"].slackID
-	}
-
-	return false
-}
+// Priorities of the built-in commands, they mirror the order in which the
+// messages used to be checked before the command registry existed
+const (
+	PriorityReaction = 100
+	PriorityHigh     = 40
+	PriorityNormal   = 30
+	PriorityLow      = 20
+	PriorityLowest   = 10
+	PriorityFallback = 0
+)
 
-var (
-	// Generic responses to all messages
-	containsToReactions = map[string][]string{
-		"︵": {"┬─┬ノ( º _ ºノ)"},
-		"彡": {"┬─┬ノ( º _ ºノ)"},
-		"my adorable little gophers": {"gopher"},
-		"bbq":       {"bbqgopher"},
-		"buffalo":   {"gobuffalo"},
-		"gobuffalo": {"gobuffalo"},
-		"ghost":     {"ghost"},
-		"ermergerd": {"dragon"},
-		"ermahgerd": {"dragon"},
-		"dragon":    {"dragon"},
-		"spacex":    {"rocket"},
-		"beer me":   {"beer", "beers"},
-	}
-
-	reactWithMessage = map[string]struct{}{
-		"︵": {},
-		"彡": {},
-	}
-
-	// Bot-directed message reactions / responses from here down
-	botEventTextToFunc = map[string]slackHandler{
-		"newbie resources":     newbieResourcesPublic,
-		"newbie resources pvt": newbieResourcesPrivate,
-		"recommended channels": recommendedChannels,
-		"flip coin":            flipCoin,
-		"flip a coin":          flipCoin,
-		"version":              botVersion,
-	}
-
-	botEventTextToResponse = map[string][]string{
-		"recommended blogs": {
-			`Here are some popular blog posts and Twitter accounts you should follow:`,
-			`- Peter Bourgon <https://twitter.com/peterbourgon|@peterbourgon> - <https://peter.bourgon.org/blog>`,
-			`- Carlisia Campos <https://twitter.com/carlisia|@carlisia>`,
-			`- Dave Cheney <https://twitter.com/davecheney|@davecheney> - <http://dave.cheney.net>`,
-			`- Jaana Burcu Dogan <https://twitter.com/rakyll|@rakyll> - <http://golang.rakyll.org>`,
-			`- Jessie Frazelle <https://twitter.com/jessfraz|@jessfraz> - <https://blog.jessfraz.com>`,
-			`- William "Bill" Kennedy <https://twitter.com|@goinggodotnet> - <https://www.goinggo.net>`,
-			`- Brian Ketelsen <https://twitter.com/bketelsen|@bketelsen> - <https://www.brianketelsen.com/blog>`,
-		},
-		"oss help wanted": {
-			`Here's a list of projects which could need some help from contributors like you: <https://github.com/corylanou/oss-helpwanted>`,
-		},
-		"working with forks": {
-			`Here's how to work with package forks in Go: <http://blog.sgmansfield.com/2016/06/working-with-forks-in-go/>`,
-		},
-		"block forever": {
-			`Here's how to block forever in Go: <http://blog.sgmansfield.com/2016/06/how-to-block-forever-in-go/>`,
-		},
-		"http timeouts": {
-			`Here's a blog post which will help with http timeouts in Go: <https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/>`,
-		},
-		"slices": {
-			`The following posts will explain how slices, maps and strings work in Go:`,
-			`- <https://blog.golang.org/slices>`,
-			`- <https://blog.golang.org/go-slices-usage-and-internals>`,
-			`- <https://blog.golang.org/strings>`,
+func builtinCommands() []*Command {
+	return []*Command{
+		{
+			Name:        "ghd/",
+			Category:    "Tools",
+			Match:       MatchPrefix,
+			Ambient:     true,
+			InChannel:   true,
+			Description: "link to the godoc.org page of a GitHub package",
+			Usage:       "ghd/<user>/<repository>",
+			Handler:     godocHandler("github.com/", 4),
 		},
-		"database tutorial": {
-			`Here's how to work with database/sql in Go: <http://go-database-sql.org/>`,
+		{
+			Name:        "d/",
+			Category:    "Tools",
+			Match:       MatchPrefix,
+			Ambient:     true,
+			InChannel:   true,
+			Description: "link to the godoc.org page of a package",
+			Usage:       "d/<import path>",
+			Handler:     godocHandler("", 2),
 		},
-		"package layout": {
-			`These articles will explain how to organize your Go packages:`,
-			`- <https://rakyll.org/style-packages/>`,
-			`- <https://medium.com/@benbjohnson/standard-package-layout-7cdbc8391fc1#.ds38va3pp>`,
-			`- <https://peter.bourgon.org/go-best-practices-2016/#repository-structure>`,
-			``,
-			`This article will help you understand the design philosophy for packages: <https://www.goinggo.net/2017/02/design-philosophy-on-packaging.html>`,
-		},
-		"idiomatic go": {
-			`Tips on how to write idiomatic Go code <https://dmitri.shuralyov.com/idiomatic-go>`,
-		},
-		"avoid gotchas": {
-			`Read this article if you want to understand and avoid common gotchas in Go <https://divan.github.io/posts/avoid_gotchas>`,
-		},
-		"source code": {
-			`My source code is here <https://github.com/gopheracademy/gopher>`,
-		},
-		"stack": {
-			`I'm currently living in the Clouds, powered by Google Container Engine (GKE) <https://cloud.google.com/container-engine>.`,
-			`I find my way to home using CircleCI <https://circleci.com> and Kubernetes (k8s) <http://kubernetes.io>.`,
-			`You can find my heart at: <https://github.com/gopheracademy/gopher>.`,
-		},
-		"help": {
-			`Here's a list of supported commands`,
-			`- "newbie resources" -> get a list of newbie resources`,
-			`- "newbie resources pvt" -> get a list of newbie resources as a private message`,
-			`- "recommended channels" -> get a list of recommended channels`,
-			`- "oss help" -> help the open-source community`,
-			`- "work with forks" -> how to work with forks of packages`,
-			`- "idiomatic go" -> learn how to write more idiomatic Go code`,
-			`- "block forever" -> how to block forever`,
-			`- "http timeouts" -> tutorial about dealing with timeouts and http`,
-			`- "database tutorial" -> tutorial about using sql databases`,
-			`- "package layout" -> learn how to structure your Go package`,
-			`- "avoid gotchas" -> avoid common gotchas in Go`,
-			`- "library for <name>" -> search a go package that matches <name>`,
-			`- "flip a coin" -> flip a coin`,
-			`- "source code" -> location of my source code`,
-			`- "where do you live?" OR "stack" -> get information about where the tech stack behind @gopher`,
-		},
-	}
 
-	botEventTextToResponseAliases = map[string]string{
-		"recommended":          "recommended blogs",
-		"oss help":             "oss help wanted",
-		"work with forks":      "working with forks",
-		"how to block forever": "block forever",
-		"slice internals":      "slices",
-		"databases":            "database tutorial",
-		"gotchas":              "avoid gotchas",
-		"source":               "source code",
-		"where do you live?":   "stack",
-		"package structure":    "package layout",
-		"project structure":    "package layout",
-		"project layout":       "package layout",
-	}
-
-	botPrefixToFunc = map[string]slackHandler{
-		"xkcd:":       xkcd,
-		"library for": searchLibrary,
-		"share cl":    shareCL,
-	}
-
-	botContainsToReactions = map[string][]string{
-		"thank":  {"gopher"},
-		"cheers": {"gopher"},
-		"hello":  {"gopher"},
-	}
+		// Bot-directed message reactions / responses from here down
+		{Name: "newbie resources", Category: "Learning", Priority: PriorityHigh, Description: "get a list of newbie resources", Handler: newbieResourcesPublic},
+		{Name: "newbie resources pvt", Category: "Learning", Priority: PriorityHigh, Description: "get a list of newbie resources as a private message", Handler: newbieResourcesPrivate},
+		{Name: "recommended channels", Category: "Community", Priority: PriorityHigh, Description: "get a list of recommended channels", Handler: recommendedChannels},
+		{Name: "flip coin", Category: "Fun", Aliases: []string{"flip a coin"}, Priority: PriorityHigh, InChannel: true, Description: "flip a coin", Handler: flipCoin},
+		{Name: "reload config", Category: "Admin", Role: RoleAdmin, Priority: PriorityHigh, Description: "load the configuration file again", Handler: reloadConfig},
+		{Name: "grant", Category: "Admin", Role: RoleAdmin, Match: MatchPrefix, Priority: PriorityHigh, Description: "give a role to a user, a channel or a user group", Usage: "grant <role> to <@user | #channel | @user-group>", Handler: grantRole},
+		{Name: "revoke", Category: "Admin", Role: RoleAdmin, Match: MatchPrefix, Priority: PriorityHigh, Description: "take back a granted role", Usage: "revoke <role> from <@user | #channel | @user-group>", Handler: revokeRole},
+		{Name: "roles", Category: "Admin", Role: RoleAdmin, Priority: PriorityHigh, Description: "list who holds which role", Handler: listRoles},
+		{Name: "backfill", Category: "Admin", Role: RoleAdmin, Match: MatchPrefix, Priority: PriorityHigh, Description: "record, and post, the merged CLs the poller missed", Usage: "backfill <from>-<to> | <YYYY-MM-DD>..<YYYY-MM-DD> [post | summary | silent] [dry-run]", Handler: backfillCLs},
+		{Name: "audit log", Category: "Admin", Role: RoleAdmin, Match: MatchPrefix, Priority: PriorityHigh, Description: "show the most recent privileged actions and denied attempts", Usage: "audit log [<number of entries>]", Handler: auditLog},
+		{Name: "jobs", Category: "Admin", Role: RoleAdmin, Priority: PriorityHigh, Description: "show when the background jobs last ran, their last error and their next run", Handler: listJobs},
+		{Name: "version", Category: "About", Priority: PriorityHigh, Description: "get the version of the bot", Handler: botVersion},
+
+		{
+			Name:        "help",
+			Match:       MatchRegexp,
+			Pattern:     `^help(\s|$)`,
+			Priority:    PriorityNormal,
+			Category:    "About",
+			Description: "list the supported commands or explain one of them",
+			Usage:       "help [<page> | <command>]",
+			Handler:     help,
+		},
 
-	botHasPrefixToReactions = map[string][]string{
-		"wave": {"wave", "gopher"},
+		// More responses that need some logic behind them
+		{Name: "xkcd:", Category: "Fun", Match: MatchPrefix, InChannel: true, Description: "link to an xkcd comic by number or alias", Usage: "xkcd:<number or alias>", Handler: xkcd},
+		{Name: "library for", Category: "Tools", Match: MatchPrefix, InChannel: true, Description: "search a go package that matches <name>", Usage: "library for <name>", Handler: searchLibrary},
+		{Name: "schedule", Category: "Scheduling", Role: RoleModerator, Match: MatchPrefix, Priority: PriorityNormal, Description: "post a message in a channel, or send it to a user, once or periodically", Usage: `schedule "<cron expression | YYYY-MM-DD HH:MM | in <duration>>" [in <time zone>] [jitter <duration>] <#channel | @user> <text>`, Handler: scheduleTask},
+		{Name: "schedules", Category: "Scheduling", Priority: PriorityHigh, Description: "list the scheduled announcements and reminders", Handler: listScheduledTasks},
+		{Name: "unschedule", Category: "Scheduling", Match: MatchPrefix, Priority: PriorityNormal, Description: "cancel a scheduled announcement or reminder", Usage: "unschedule <id>", Handler: unscheduleTask},
+		{Name: "remind me", Category: "Scheduling", Match: MatchPrefix, Priority: PriorityNormal, Description: "get a direct message later, or periodically", Usage: `remind me "<cron expression | YYYY-MM-DD HH:MM | in <duration>>" [in <time zone>] <text>`, Handler: remindMe},
+		{Name: "subscribe cl", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, Description: "get a direct message when a CL matching the pattern is merged", Usage: "subscribe cl <subject prefix | project:<name> | branch:<name> | path:<directory> | author:<e-mail>>", Handler: subscribeCL},
+		{Name: "unsubscribe", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, Description: "stop getting the CLs matching a pattern", Usage: "unsubscribe cl <pattern | all>", Handler: unsubscribeCL},
+		{Name: "my subscriptions", Category: "Go CLs", Description: "list the patterns of the CLs you get", Handler: mySubscriptions},
+		{Name: "cl", Category: "Go CLs", Match: MatchRegexp, Pattern: `^cl\s+(#?\d+|<https?://\S+>)$`, InChannel: true, Description: "show the status, the review and the changes of a CL", Usage: "cl <number | link>", Handler: lookupCL},
+		{Name: "cl digest", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, InChannel: true, Description: "list the CLs merged lately by project and package, or get that list periodically", Usage: `cl digest [<YYYY-MM-DD | duration such as 3d>] | cl digest every "<cron expression>" [in <time zone>]`, Handler: clDigestCommand},
+		{Name: "relnotes", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, InChannel: true, Description: "list the CLs marked RELNOTE during a release cycle, or export them as Markdown", Usage: "relnotes [<version>] [markdown]", Handler: relNotesCommand},
+		{Name: "watch cl", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, Description: "follow the review of an open CL in a thread", Usage: "watch cl <number>", Handler: watchCL},
+		{Name: "unwatch cl", Category: "Go CLs", Match: MatchPrefix, Priority: PriorityNormal, Description: "stop following the review of a CL here", Usage: "unwatch cl <number>", Handler: unwatchCL},
+		{Name: "watched cls", Category: "Go CLs", Priority: PriorityHigh, Description: "list the CLs you watch", Handler: watchedCLs},
+		{Name: "share cl", Category: "Go CLs", Role: RoleCLCurator, Match: MatchPrefix, Priority: PriorityNormal, Description: "tweet one or more CLs", Usage: "share cl <number> [<number>...]", Handler: shareCL},
+		{Name: "tweet cl", Category: "Go CLs", Role: RoleCLCurator, Match: MatchPrefix, Priority: PriorityNormal, Description: "tweet a CL with your own text", Usage: "tweet cl <number> <text>", Handler: tweetCLWithText},
 	}
-)
+}
 
 // HandleMessage will process the incoming message and repspond appropriately
 func (b *Bot) HandleMessage(event *slack.MessageEvent) {
@@ -366,36 +255,16 @@ func (b *Bot) HandleMessage(event *slack.MessageEvent) {
 		return
 	}
 
-	span := b.traceClient.NewSpan("b.HandleMessage")
+	span := b.tracer.NewSpan("b.HandleMessage")
 	span.SetLabel("eventText", eventText)
 	defer span.Finish()
 
-	ctx := trace.NewContext(context.Background(), span)
-
-	// Reactions to all messages (including those not directed at the bot)
-	// that contain a certain string
-	for needle, reactions := range containsToReactions {
-		if strings.Contains(eventText, needle) {
-			if _, ok := reactWithMessage[needle]; ok {
-				for _, reaction := range reactions {
-					respond(ctx, b, event, reaction)
-				}
-			} else {
-				for _, reaction := range reactions {
-					b.reactToEvent(ctx, event, reaction)
-				}
-			}
-			return
-		}
-	}
-
-	if strings.HasPrefix(eventText, "ghd/") {
-		b.godoc(ctx, event, "github.com/", 4)
-		return
-	}
+	ctx := NewSpanContext(context.Background(), span)
 
-	if strings.HasPrefix(eventText, "d/") {
-		b.godoc(ctx, event, "", 2)
+	// Commands which apply to all messages (including those not directed at the bot)
+	if cmd := b.commands.Match(eventText, true); cmd != nil {
+		span.SetLabel("command", cmd.Name)
+		b.runCommand(ctx, cmd, event)
 		return
 	}
 
@@ -415,6 +284,7 @@ func (b *Bot) HandleMessage(event *slack.MessageEvent) {
 
 	// All messages past this point are directed to @gopher itself
 	if !b.isBotMessage(event, eventText) {
+		b.summarizeCLMentions(ctx, event)
 		return
 	}
 
@@ -423,57 +293,9 @@ func (b *Bot) HandleMessage(event *slack.MessageEvent) {
 		b.logf("message: %q\n", eventText)
 	}
 
-	// Responses that need some logic behind them
-	if responseFunc, ok := botEventTextToFunc[eventText]; ok {
-		responseFunc(ctx, b, event)
-		return
-	}
-
-	// Responses that are just a canned string response
-	if responseLines, ok := botEventTextToResponse[eventText]; ok {
-		response := strings.Join(responseLines, "\n")
-		respond(ctx, b, event, response)
-		return
-	}
-
-	// aliases for the above canned responses
-	if key, ok := botEventTextToResponseAliases[eventText]; ok {
-		if responseLines, ok := botEventTextToResponse[key]; ok {
-			response := strings.Join(responseLines, "\n")
-			respond(ctx, b, event, response)
-			return
-		}
-
-		b.logf("Bad response alias: %v", eventText)
-		return
-	}
-
-	// Reacting based on if the message contains a needle
-	for needle, reactions := range botContainsToReactions {
-		if strings.Contains(eventText, needle) {
-			for _, reaction := range reactions {
-				b.reactToEvent(ctx, event, reaction)
-			}
-			return
-		}
-	}
-
-	// Reacting based on a prefix of the message
-	for prefix, reactions := range botHasPrefixToReactions {
-		if strings.HasPrefix(eventText, prefix) {
-			for _, reaction := range reactions {
-				b.reactToEvent(ctx, event, reaction)
-			}
-			return
-		}
-	}
-
-	// More responses that need some logic behind them
-	for prefix, responseFunc := range botPrefixToFunc {
-		if strings.HasPrefix(eventText, prefix) {
-			responseFunc(ctx, b, event)
-			return
-		}
+	if cmd := b.commands.Match(eventText, false); cmd != nil {
+		span.SetLabel("command", cmd.Name)
+		b.runCommand(ctx, cmd, event)
 	}
 }
 
@@ -519,11 +341,13 @@ Finally, <https://github.com/golang/go/wiki#learning-more-about-go> will give a
 
 	params := slack.PostMessageParameters{AsUser: true}
 	params.Attachments = []slack.Attachment{newbieResources}
-	whereTo := event.Channel
+	text := "Here are some resources you should check out if you are learning / new to Go:"
+	var err error
 	if private {
-		whereTo = event.User
+		_, _, err = b.chat.DirectMessage(ctx, event.User, text, params)
+	} else {
+		_, _, err = b.chat.PostMessage(ctx, event.Channel, text, params)
 	}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, whereTo, "Here are some resources you should check out if you are learning / new to Go:", params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -533,16 +357,18 @@ Finally, <https://github.com/golang/go/wiki#learning-more-about-go> will give a
 func recommendedChannels(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	message := slack.Attachment{}
 
-	for idx, val := range b.channels {
+	configs, channels := b.channelConfigs()
+	for _, channel := range configs {
+		val := channels[strings.ToLower(channel.Name)]
 		if val.special {
 			continue
 		}
-		message.Text += `- <` + val.slackID + `|` + idx + `> -> ` + val.description + "\n"
+		message.Text += `- <` + val.slackID + `|` + channel.Name + `> -> ` + val.description + "\n"
 	}
 
 	params := slack.PostMessageParameters{AsUser: true}
 	params.Attachments = []slack.Attachment{message}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, "Here is a list of recommended channels:", params)
+	_, _, err := b.chat.DirectMessage(ctx, event.User, "Here is a list of recommended channels:", params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -554,7 +380,7 @@ func (b *Bot) suggestPlayground(ctx context.Context, event *slack.MessageEvent)
 		return
 	}
 
-	info, _, _, err := b.slackBotAPI.GetFileInfoContext(ctx, event.File.ID, 0, 0)
+	info, err := b.chat.FileInfo(ctx, event.File.ID)
 	if err != nil {
 		b.logf("error while getting file info: %v", err)
 		return
@@ -613,13 +439,13 @@ func (b *Bot) suggestPlayground(ctx context.Context, event *slack.MessageEvent)
 	}
 
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, event.Channel, `The above code in playground: <https://play.golang.org/p/`+string(linkID)+`>`, params)
+	_, _, err = b.chat.PostMessage(ctx, event.Channel, `The above code in playground: <https://play.golang.org/p/`+string(linkID)+`>`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
 	}
 
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, event.User, `Hello. I've noticed you uploaded a Go file. To facilitate collaboration and make this easier for others to share back the snippet, please consider using: <https://play.golang.org>. If you wish to not link against the playground, please use "nolink" in the message. Thank you.`, params)
+	_, _, err = b.chat.DirectMessage(ctx, event.User, `Hello. I've noticed you uploaded a Go file. To facilitate collaboration and make this easier for others to share back the snippet, please consider using: <https://play.golang.org>. If you wish to not link against the playground, please use "nolink" in the message. Thank you.`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -684,13 +510,13 @@ func (b *Bot) suggestPlayground2(ctx context.Context, event *slack.MessageEvent)
 	}
 
 	params := slack.PostMessageParameters{AsUser: true, ThreadTimestamp: event.ThreadTimestamp}
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, event.Channel, `The above code in playground: <https://play.golang.org/p/`+string(linkID)+`>`, params)
+	_, _, err = b.chat.PostMessage(ctx, event.Channel, `The above code in playground: <https://play.golang.org/p/`+string(linkID)+`>`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
 	}
 
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, event.User, `Hello. I've noticed you've written a large block of text (more than 9 lines). To make the conversation easier to follow the conversation and facilitate collaboration, please consider using: <https://play.golang.org> if you shared code. If you wish to not link against the playground, please start the message with "nolink". Thank you.`, params)
+	_, _, err = b.chat.DirectMessage(ctx, event.User, `Hello. I've noticed you've written a large block of text (more than 9 lines). To make the conversation easier to follow the conversation and facilitate collaboration, please consider using: <https://play.golang.org> if you shared code. If you wish to not link against the playground, please start the message with "nolink". Thank you.`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -703,7 +529,7 @@ func respond(ctx context.Context, b *Bot, event *slack.MessageEvent, response st
 		return
 	}
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.Channel, response, params)
+	_, _, err := b.chat.PostMessage(ctx, event.Channel, response, params)
 	if err != nil {
 		b.logf("%s\n", err)
 	}
@@ -732,19 +558,13 @@ func searchLibrary(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	}
 	searchTerm = url.QueryEscape(searchTerm)
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.Channel, `You can try to look here: <https://godoc.org/?q=`+searchTerm+`> or here <http://go-search.org/search?q=`+searchTerm+`>`, params)
+	_, _, err := b.chat.PostMessage(ctx, event.Channel, `You can try to look here: <https://godoc.org/?q=`+searchTerm+`> or here <http://go-search.org/search?q=`+searchTerm+`>`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
 	}
 }
 
-var xkcdAliases = map[string]int{
-	"standards":    927,
-	"compiling":    303,
-	"optimization": 1691,
-}
-
 func xkcd(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	// repeats some earlier work but oh well
 	eventText := strings.Trim(strings.ToLower(event.Text), " \n\r")
@@ -752,7 +572,7 @@ func xkcd(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	eventText = strings.TrimPrefix(eventText, "xkcd:")
 
 	// first check known aliases for certain comics
-	comicID := xkcdAliases[eventText]
+	comicID := b.xkcdAlias(eventText)
 
 	// otherwise parse the number out of the evet text
 	if comicID == 0 {
@@ -773,7 +593,7 @@ func xkcd(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 		UnfurlLinks: true,
 		UnfurlMedia: true,
 	}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.Channel, imageLink, params)
+	_, _, err := b.chat.PostMessage(ctx, event.Channel, imageLink, params)
 	if err != nil {
 		b.logf("error while sending xkcd message: %s\n", err)
 		return
@@ -787,7 +607,7 @@ func (b *Bot) godoc(ctx context.Context, event *slack.MessageEvent, prefix strin
 	}
 
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.Channel, `<https://godoc.org/`+prefix+link+`>`, params)
+	_, _, err := b.chat.PostMessage(ctx, event.Channel, `<https://godoc.org/`+prefix+link+`>`, params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -803,7 +623,7 @@ func (b *Bot) reactToEvent(ctx context.Context, event *slack.MessageEvent, react
 		Channel:   event.Channel,
 		Timestamp: event.Timestamp,
 	}
-	err := b.slackBotAPI.AddReactionContext(ctx, reaction, item)
+	err := b.chat.AddReaction(ctx, reaction, item)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -812,7 +632,7 @@ func (b *Bot) reactToEvent(ctx context.Context, event *slack.MessageEvent, react
 
 func botVersion(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf("My version is: %s", b.version), params)
+	_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf("My version is: %s", b.version), params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -830,7 +650,7 @@ func flipCoin(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 		result = "tail"
 	}
 	params := slack.PostMessageParameters{AsUser: true}
-	_, _, err = b.slackBotAPI.PostMessageContext(ctx, event.Channel, fmt.Sprintf("%s", result), params)
+	_, _, err = b.chat.PostMessage(ctx, event.Channel, fmt.Sprintf("%s", result), params)
 	if err != nil {
 		b.logf("%s\n", err)
 		return
@@ -838,41 +658,39 @@ func flipCoin(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 }
 
 // NewBot will create a new Slack bot
-func NewBot(slackBotAPI *slack.Client, dsClient *datastore.Client, traceClient *trace.Client, twitterAPI *anaconda.TwitterApi, httpClient Client, gerritLink, name, token, version string, devMode bool, log Logger) *Bot {
-	return &Bot{
-		gerritLink:  gerritLink,
-		name:        name,
-		token:       token,
-		client:      httpClient,
-		version:     version,
-		devMode:     devMode,
-		logf:        log,
-		slackBotAPI: slackBotAPI,
-		dsClient:    dsClient,
-		traceClient: traceClient,
-		twitterAPI:  twitterAPI,
+func NewBot(chat Messenger, store Store, tracer Tracer, twitterAPI Tweeter, httpClient Client, config *Config, gerritLink, name, token, version string, devMode bool, log Logger) *Bot {
+	commands := NewCommandRegistry()
+	for _, cmd := range builtinCommands() {
+		if err := commands.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+
+	b := &Bot{
+		gerritLink: gerritLink,
+		name:       name,
+		token:      token,
+		client:     httpClient,
+		version:    version,
+		devMode:    devMode,
+		logf:       log,
+		chat:       &slashMessenger{Messenger: chat, client: httpClient},
+		store:      store,
+		tracer:     tracer,
+		twitterAPI: twitterAPI,
+		commands:   commands,
 
 		emojiRE:     regexp.MustCompile(`:[[:alnum:]]+:`),
 		slackLinkRE: regexp.MustCompile(`<((?:@u)|(?:#c))[0-9a-z]+>`),
 
-		channels: map[string]slackChan{
-			"golang-newbies": {description: "for newbie resources", welcome: true},
-			"reviews":        {description: "for code reviews", welcome: true},
-			"gotimefm":       {description: "for the awesome live podcast", welcome: true},
-			"remotemeetup":   {description: "for remote meetup", welcome: true},
-			"golang-jobs":    {description: "for jobs related to Go", welcome: true},
-
-			"showandtell": {description: "tell the world about the thing you are working on"},
-			"performance": {description: "anything and everything performance related"},
-			"devops":      {description: "for devops related discussions"},
-			"security":    {description: "for security related discussions"},
-			"aws":         {description: "if you are interested in AWS"},
-			"goreviews":   {description: "talk to the Go team about a certain CL"},
-			"golang-cls":  {description: "get real time udates from the merged CL for Go itself. For a currated list of important / interesting messages follow: https://twitter.com/golang_cls"},
-			"bbq":         {description: "Go controlling your bbq grill? Yes, we have that"},
-
-			"general":    {description: "general channel", special: true},
-			"golang_cls": {description: "https://twitter.com/golang_cls", special: true},
-		},
+		config:       config,
+		channels:     map[string]slackChan{},
+		taskHandlers: builtinTaskKinds(),
 	}
+	b.leader = NewLeaderElection(store, leaderLease, newReplicaID(), leaderTTL, log)
+	b.jobs = NewSupervisor(tracer, log, b.alertAdmins)
+	b.jobs.UseLeaderElection(b.leader)
+	b.registerBuiltinJobs()
+
+	return b
 }
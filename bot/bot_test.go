@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLog records what the bot logs
+type testLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLog) logf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// count returns how many lines contain the text
+func (l *testLog) count(text string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := 0
+	for _, line := range l.lines {
+		if strings.Contains(line, text) {
+			count++
+		}
+	}
+	return count
+}
+
+// newTestBot creates an initialized bot with the required channels, an
+// admin called admin, and the given store and Gerrit link
+func newTestBot(t *testing.T, store Store, gerritLink string) (*Bot, *FakeMessenger, *testLog) {
+	t.Helper()
+
+	cfg := &Config{
+		Channels: []ChannelConfig{{Name: "golang-cls"}, {Name: "golang_cls"}, {Name: "gotimefm"}},
+		Admins:   AdminsConfig{Users: []string{"admin"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	chat := NewFakeMessenger()
+	for _, channel := range cfg.Channels {
+		chat.ChannelList = append(chat.ChannelList, ChatChannel{ID: channel.Name, Name: channel.Name})
+	}
+	chat.UserList = []ChatUser{{ID: "UADMIN", Name: "admin"}}
+
+	log := &testLog{}
+	b := NewBot(chat, store, NewNoopTracer(), &FakeTweeter{}, http.DefaultClient, cfg, gerritLink, "gopher", "", "test", false, log.logf)
+	if err := b.Init(context.Background(), NewNoopTracer().NewSpan("test")); err != nil {
+		t.Fatal(err)
+	}
+	chat.Reset()
+	return b, chat, log
+}
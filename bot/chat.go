@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// ChatChannel is a channel the bot can post to
+	ChatChannel struct {
+		ID      string
+		Name    string
+		Private bool
+	}
+
+	// ChatUser is a member of the workspace
+	ChatUser struct {
+		ID   string
+		Name string
+	}
+
+	// ChatFile is a text file shared in a channel
+	ChatFile struct {
+		Name  string
+		Title string
+		// Type is the Slack file type, such as markdown
+		Type    string
+		Content string
+	}
+
+	// Messenger is everything the bot needs from the chat platform
+	Messenger interface {
+		// PostMessage sends a message to a channel and returns the channel and timestamp of it
+		PostMessage(ctx context.Context, channel, text string, params slack.PostMessageParameters) (string, string, error)
+		// UpdateMessage replaces the text and attachments of a message sent by the bot
+		UpdateMessage(ctx context.Context, channel, timestamp, text string, params slack.PostMessageParameters) error
+		// DirectMessage sends a private message to a user
+		DirectMessage(ctx context.Context, user, text string, params slack.PostMessageParameters) (string, string, error)
+		// AddReaction reacts to a message
+		AddReaction(ctx context.Context, reaction string, item slack.ItemRef) error
+		// UploadFile shares a text file, such as a Markdown document, in a channel
+		UploadFile(ctx context.Context, channel string, file ChatFile) error
+		// FileInfo returns the details of an uploaded file
+		FileInfo(ctx context.Context, fileID string) (*slack.File, error)
+		// Channels lists the public channels, or the private ones the bot is a member of
+		Channels(ctx context.Context, private bool) ([]ChatChannel, error)
+		// Identity returns the user the bot is connected as
+		Identity(ctx context.Context) (ChatUser, error)
+		// Users lists the members of the workspace
+		Users(ctx context.Context) ([]ChatUser, error)
+		// UserGroupMembers returns the IDs of the members of the user group with the given handle
+		UserGroupMembers(ctx context.Context, handle string) ([]string, error)
+	}
+
+	slackMessenger struct {
+		api *slack.Client
+	}
+)
+
+// NewSlackMessenger creates a Messenger backed by the Slack Web API
+func NewSlackMessenger(api *slack.Client) Messenger {
+	return &slackMessenger{api: api}
+}
+
+func (s *slackMessenger) PostMessage(ctx context.Context, channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	return s.api.PostMessageContext(ctx, channel, text, params)
+}
+
+func (s *slackMessenger) UpdateMessage(ctx context.Context, channel, timestamp, text string, params slack.PostMessageParameters) error {
+	_, _, _, err := s.api.SendMessageContext(ctx, channel,
+		slack.MsgOptionUpdate(timestamp),
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionAttachments(params.Attachments...),
+		slack.MsgOptionPostMessageParameters(params),
+	)
+	return err
+}
+
+func (s *slackMessenger) DirectMessage(ctx context.Context, user, text string, params slack.PostMessageParameters) (string, string, error) {
+	// Posting as the bot user to an user ID ends up in the DM with that user
+	return s.api.PostMessageContext(ctx, user, text, params)
+}
+
+func (s *slackMessenger) AddReaction(ctx context.Context, reaction string, item slack.ItemRef) error {
+	return s.api.AddReactionContext(ctx, reaction, item)
+}
+
+func (s *slackMessenger) UploadFile(ctx context.Context, channel string, file ChatFile) error {
+	_, err := s.api.UploadFileContext(ctx, slack.FileUploadParameters{
+		Content:  file.Content,
+		Filetype: file.Type,
+		Filename: file.Name,
+		Title:    file.Title,
+		Channels: []string{channel},
+	})
+	return err
+}
+
+func (s *slackMessenger) FileInfo(ctx context.Context, fileID string) (*slack.File, error) {
+	info, _, _, err := s.api.GetFileInfoContext(ctx, fileID, 0, 0)
+	return info, err
+}
+
+func (s *slackMessenger) Channels(ctx context.Context, private bool) ([]ChatChannel, error) {
+	result := []ChatChannel{}
+
+	if private {
+		groups, err := s.api.GetGroupsContext(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			result = append(result, ChatChannel{ID: group.ID, Name: group.Name, Private: true})
+		}
+		return result, nil
+	}
+
+	channels, err := s.api.GetChannelsContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		result = append(result, ChatChannel{ID: channel.ID, Name: channel.Name})
+	}
+	return result, nil
+}
+
+func (s *slackMessenger) Identity(ctx context.Context) (ChatUser, error) {
+	resp, err := s.api.AuthTestContext(ctx)
+	if err != nil {
+		return ChatUser{}, err
+	}
+	return ChatUser{ID: resp.UserID, Name: resp.User}, nil
+}
+
+func (s *slackMessenger) Users(ctx context.Context) ([]ChatUser, error) {
+	users, err := s.api.GetUsersContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ChatUser{}
+	for _, user := range users {
+		if user.Deleted {
+			continue
+		}
+		result = append(result, ChatUser{ID: user.ID, Name: user.Name})
+	}
+	return result, nil
+}
+
+func (s *slackMessenger) UserGroupMembers(ctx context.Context, handle string) ([]string, error) {
+	groups, err := s.api.GetUserGroupsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		if group.Handle == handle {
+			return s.api.GetUserGroupMembersContext(ctx, group.ID)
+		}
+	}
+	return nil, fmt.Errorf("user group %s not found", handle)
+}
@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// FakeMessage is a message recorded by the FakeMessenger
+	FakeMessage struct {
+		Channel   string
+		Timestamp string
+		Text      string
+		Direct    bool
+		Params    slack.PostMessageParameters
+		// Updated is set once UpdateMessage replaced the message
+		Updated bool
+	}
+
+	// FakeReaction is a reaction recorded by the FakeMessenger
+	FakeReaction struct {
+		Reaction string
+		Item     slack.ItemRef
+	}
+
+	// FakeUpload is a file uploaded with the FakeMessenger
+	FakeUpload struct {
+		Channel string
+		File    ChatFile
+	}
+
+	// FakeMessenger is an in-memory Messenger which records everything the bot sends
+	FakeMessenger struct {
+		mu        sync.Mutex
+		counter   int
+		messages  []FakeMessage
+		reactions []FakeReaction
+		uploads   []FakeUpload
+
+		// Files are returned by FileInfo, keyed by the file ID
+		Files map[string]*slack.File
+		// ChannelList is returned by Channels
+		ChannelList []ChatChannel
+		// Self is returned by Identity
+		Self ChatUser
+		// UserList is returned by Users
+		UserList []ChatUser
+		// UserGroups are the members of each user group, keyed by the group handle
+		UserGroups map[string][]string
+		// Err, when set, is returned by every call
+		Err error
+	}
+)
+
+// NewFakeMessenger creates an empty FakeMessenger
+func NewFakeMessenger() *FakeMessenger {
+	return &FakeMessenger{
+		Files:      map[string]*slack.File{},
+		Self:       ChatUser{ID: "UBOT", Name: "gopher"},
+		UserGroups: map[string][]string{},
+	}
+}
+
+func (f *FakeMessenger) post(channel, text string, direct bool, params slack.PostMessageParameters) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return "", "", f.Err
+	}
+
+	f.counter++
+	timestamp := fmt.Sprintf("1500000000.%06d", f.counter)
+	f.messages = append(f.messages, FakeMessage{
+		Channel:   channel,
+		Timestamp: timestamp,
+		Text:      text,
+		Direct:    direct,
+		Params:    params,
+	})
+
+	return channel, timestamp, nil
+}
+
+// PostMessage records a message sent to a channel
+func (f *FakeMessenger) PostMessage(ctx context.Context, channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	return f.post(channel, text, false, params)
+}
+
+// UpdateMessage replaces a recorded message
+func (f *FakeMessenger) UpdateMessage(ctx context.Context, channel, timestamp, text string, params slack.PostMessageParameters) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	for idx := range f.messages {
+		msg := &f.messages[idx]
+		if msg.Channel == channel && msg.Timestamp == timestamp {
+			msg.Text = text
+			msg.Params = params
+			msg.Updated = true
+			return nil
+		}
+	}
+	return fmt.Errorf("message %s in %s not found", timestamp, channel)
+}
+
+// DirectMessage records a message sent to a user
+func (f *FakeMessenger) DirectMessage(ctx context.Context, user, text string, params slack.PostMessageParameters) (string, string, error) {
+	return f.post(user, text, true, params)
+}
+
+// AddReaction records a reaction
+func (f *FakeMessenger) AddReaction(ctx context.Context, reaction string, item slack.ItemRef) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	f.reactions = append(f.reactions, FakeReaction{Reaction: reaction, Item: item})
+	return nil
+}
+
+// UploadFile records an uploaded file
+func (f *FakeMessenger) UploadFile(ctx context.Context, channel string, file ChatFile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	f.uploads = append(f.uploads, FakeUpload{Channel: channel, File: file})
+	return nil
+}
+
+// FileInfo returns the file from Files
+func (f *FakeMessenger) FileInfo(ctx context.Context, fileID string) (*slack.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	file, ok := f.Files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", fileID)
+	}
+	return file, nil
+}
+
+// Channels returns the channels from ChannelList
+func (f *FakeMessenger) Channels(ctx context.Context, private bool) ([]ChatChannel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	result := []ChatChannel{}
+	for _, channel := range f.ChannelList {
+		if channel.Private == private {
+			result = append(result, channel)
+		}
+	}
+	return result, nil
+}
+
+// Identity returns Self
+func (f *FakeMessenger) Identity(ctx context.Context) (ChatUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return ChatUser{}, f.Err
+	}
+	return f.Self, nil
+}
+
+// Users returns UserList
+func (f *FakeMessenger) Users(ctx context.Context) ([]ChatUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	users := make([]ChatUser, len(f.UserList))
+	copy(users, f.UserList)
+	return users, nil
+}
+
+// UserGroupMembers returns the members from UserGroups
+func (f *FakeMessenger) UserGroupMembers(ctx context.Context, handle string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	members, ok := f.UserGroups[handle]
+	if !ok {
+		return nil, fmt.Errorf("user group %s not found", handle)
+	}
+	return append([]string{}, members...), nil
+}
+
+// Messages returns the messages sent so far
+func (f *FakeMessenger) Messages() []FakeMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]FakeMessage, len(f.messages))
+	copy(messages, f.messages)
+	return messages
+}
+
+// Reactions returns the reactions added so far
+func (f *FakeMessenger) Reactions() []FakeReaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reactions := make([]FakeReaction, len(f.reactions))
+	copy(reactions, f.reactions)
+	return reactions
+}
+
+// Uploads returns the files uploaded so far
+func (f *FakeMessenger) Uploads() []FakeUpload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	uploads := make([]FakeUpload, len(f.uploads))
+	copy(uploads, f.uploads)
+	return uploads
+}
+
+// Reset forgets the recorded messages, reactions and uploads
+func (f *FakeMessenger) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = nil
+	f.reactions = nil
+	f.uploads = nil
+}
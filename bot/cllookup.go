@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// CLLinksConfig summarizes the CLs linked, or mentioned as "CL 12345", in
+	// the messages which are not sent to the bot
+	CLLinksConfig struct {
+		Enabled bool `json:"enabled"`
+		// Channels limits the summaries to some channels, all of them when empty
+		Channels []string `json:"channels"`
+	}
+
+	// clMentions remembers when the CLs were summarized in each channel
+	clMentions struct {
+		mu   sync.Mutex
+		seen map[string]time.Time
+	}
+)
+
+const (
+	// maxCLsPerMessage limits how many CLs are summarized for one message
+	maxCLsPerMessage = 3
+	// clMentionCooldown is how long the same CL isn't summarized again in a channel
+	clMentionCooldown = 10 * time.Minute
+
+	maxSummaryFiles = 10
+)
+
+var (
+	// lookupOptions ask Gerrit for everything the summary of a CL shows
+	lookupOptions = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "DETAILED_LABELS", "DETAILED_ACCOUNTS"}
+
+	clLinkRE    = regexp.MustCompile(`(?i)(?:golang\.org/cl/|go-review\.googlesource\.com/(?:c/(?:[\w.-]+/)*\+/|c/|#/c/)?)(\d+)`)
+	clMentionRE = regexp.MustCompile(`(?i)\bcl ?#?(\d{4,})\b`)
+)
+
+// validate checks the summaries against the configured channels
+func (cfg CLLinksConfig) validate(channels map[string]bool) []string {
+	problems := []string{}
+	for _, name := range cfg.Channels {
+		if !channels[strings.ToLower(name)] {
+			problems = append(problems, fmt.Sprintf("CL links are summarized in the unknown channel %q", name))
+		}
+	}
+	return problems
+}
+
+// findCLNumbers returns the CLs linked or mentioned in the text, in order
+func findCLNumbers(text string) []int {
+	numbers := []int{}
+	seen := map[int]bool{}
+
+	for _, re := range []*regexp.Regexp{clLinkRE, clMentionRE} {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			number, err := parseCLNumber(match[1])
+			if err != nil || seen[number] {
+				continue
+			}
+			seen[number] = true
+			numbers = append(numbers, number)
+		}
+	}
+
+	return numbers
+}
+
+// recent says if the CL was summarized in the channel lately, and remembers it was now
+func (m *clMentions) recent(channel string, number int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.seen == nil {
+		m.seen = map[string]time.Time{}
+	}
+	for key, at := range m.seen {
+		if now.Sub(at) > clMentionCooldown {
+			delete(m.seen, key)
+		}
+	}
+
+	key := fmt.Sprintf("%s|%d", channel, number)
+	if _, ok := m.seen[key]; ok {
+		return true
+	}
+	m.seen[key] = now
+	return false
+}
+
+func formatVote(value int) string {
+	if value > 0 {
+		return fmt.Sprintf("+%d", value)
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// labelsText lists the votes, such as "Code-Review +2 Bob, TryBot-Result +1 Gobot"
+func (cl *gerritCL) labelsText() string {
+	names := []string{}
+	for name := range cl.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	votes := []string{}
+	for _, name := range names {
+		label := cl.Labels[name]
+		for _, vote := range label.All {
+			if vote.Value != 0 {
+				votes = append(votes, fmt.Sprintf("%s %s %s", name, formatVote(vote.Value), vote.gerritAccount))
+			}
+		}
+		if len(label.All) > 0 {
+			continue
+		}
+		if label.Approved != nil {
+			votes = append(votes, fmt.Sprintf("%s approved by %s", name, label.Approved))
+		}
+		if label.Rejected != nil {
+			votes = append(votes, fmt.Sprintf("%s rejected by %s", name, label.Rejected))
+		}
+	}
+
+	if len(votes) == 0 {
+		return "none"
+	}
+	return strings.Join(votes, ", ")
+}
+
+func (cl *gerritCL) reviewersText() string {
+	reviewers := []string{}
+	for _, reviewer := range cl.Reviewers["REVIEWER"] {
+		if reviewer != cl.Owner {
+			reviewers = append(reviewers, reviewer.String())
+		}
+	}
+	if len(reviewers) == 0 {
+		return "none"
+	}
+	return strings.Join(reviewers, ", ")
+}
+
+// filesText lists the touched files with the lines they insert and delete
+func (cl *gerritCL) filesText() string {
+	revision := cl.Revisions[cl.CurrentRevision]
+	files := cl.files()
+	if len(files) == 0 {
+		return ""
+	}
+
+	buff := &bytes.Buffer{}
+	for idx, file := range files {
+		if idx == maxSummaryFiles {
+			buff.WriteString(fmt.Sprintf("and %d more files\n", len(files)-maxSummaryFiles))
+			break
+		}
+		stat := revision.Files[file]
+		buff.WriteString(fmt.Sprintf("%s +%d -%d\n", file, stat.LinesInserted, stat.LinesDeleted))
+	}
+	return "```" + buff.String() + "```"
+}
+
+// summary describes the state of the CL, its review and its changes
+func (cl *gerritCL) summary() slack.PostMessageParameters {
+	where := cl.Project
+	if cl.Branch != "" {
+		where += ", " + cl.Branch
+	}
+	status := strings.ToLower(cl.Status)
+	if cl.Status == gerritNew {
+		status = "open"
+	}
+	if patchSet := cl.patchSet(); patchSet > 0 {
+		status += fmt.Sprintf(", patch set %d", patchSet)
+	}
+
+	attachment := slack.Attachment{
+		Title:     cl.Subject,
+		TitleLink: cl.link(),
+		Text:      parseCommitMessage(cl.Revisions[cl.CurrentRevision].Commit.Message).mrkdwn(maxCommitMessageChars),
+		Footer:    cl.ChangeID,
+		Fields: []slack.AttachmentField{
+			{Title: "Status", Value: status, Short: true},
+			{Title: "Owner", Value: cl.Owner.String(), Short: true},
+			{Title: "Project", Value: where, Short: true},
+			{Title: "Reviewers", Value: cl.reviewersText(), Short: true},
+			{Title: "Labels", Value: cl.labelsText()},
+		},
+		MarkdownIn: []string{"text", "fields"},
+	}
+	if files := cl.filesText(); files != "" {
+		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+			Title: fmt.Sprintf("Files, +%d -%d", cl.Insertions, cl.Deletions),
+			Value: files,
+		})
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	params.Attachments = []slack.Attachment{attachment}
+	return params
+}
+
+// postCLSummary fetches the CL and posts its summary, in the thread when there is one
+func (b *Bot) postCLSummary(ctx context.Context, channel, thread string, number int) error {
+	cl, err := b.fetchCL(ctx, number, lookupOptions)
+	if err != nil {
+		return err
+	}
+
+	params := cl.summary()
+	params.ThreadTimestamp = thread
+	_, _, err = b.chat.PostMessage(ctx, channel, fmt.Sprintf("[%d] %s: %s", cl.Number, cl.message(), cl.link()), params)
+	return err
+}
+
+func lookupCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	args := b.arguments(event, "cl")
+	numbers := findCLNumbers(args)
+	if number, err := parseCLNumber(args); err == nil {
+		numbers = []int{number}
+	}
+	if len(numbers) == 0 {
+		b.directReply(ctx, event, `Usage: "cl <number>"`)
+		return
+	}
+
+	err := b.postCLSummary(ctx, event.Channel, event.ThreadTimestamp, numbers[0])
+	if err == errGerritNotFound {
+		b.directReply(ctx, event, fmt.Sprintf("There is no CL %d", numbers[0]))
+		return
+	}
+	if err != nil {
+		b.logf("could not summarize CL %d: %v\n", numbers[0], err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not find CL %d, please try again", numbers[0]))
+	}
+}
+
+// summarizeCLMentions replies in a thread to the messages which link, or mention, CLs
+func (b *Bot) summarizeCLMentions(ctx context.Context, event *slack.MessageEvent) {
+	b.mu.RLock()
+	cfg := b.config.CLLinks
+	b.mu.RUnlock()
+	if !cfg.Enabled {
+		return
+	}
+
+	if len(cfg.Channels) > 0 {
+		allowed := false
+		for _, name := range cfg.Channels {
+			allowed = allowed || strings.TrimPrefix(b.channel(strings.ToLower(name)).slackID, "#") == event.Channel
+		}
+		if !allowed {
+			return
+		}
+	}
+
+	thread := event.ThreadTimestamp
+	if thread == "" {
+		thread = event.Timestamp
+	}
+
+	numbers := findCLNumbers(event.Text)
+	if len(numbers) > maxCLsPerMessage {
+		numbers = numbers[:maxCLsPerMessage]
+	}
+	for _, number := range numbers {
+		if b.clMentions.recent(event.Channel, number) {
+			continue
+		}
+		err := b.postCLSummary(ctx, event.Channel, thread, number)
+		if err != nil && err != errGerritNotFound {
+			b.logf("could not summarize CL %d: %v\n", number, err)
+		}
+	}
+}
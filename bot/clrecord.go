@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clSchemaVersion is the version of the StoredCL records written by this
+// version of the bot. Bumping it needs a migration in clMigrations.
+const clSchemaVersion = 1
+
+// clMigrationBatch is how many stored CLs the migration job checks per run
+const clMigrationBatch = 50
+
+var (
+	// clMigrations upgrade the stored CLs, the one at index i from version i
+	// to i+1. The CL, as Gerrit has it now, is nil when Gerrit doesn't know it.
+	clMigrations = []func(cl *StoredCL, change *gerritCL){
+		migrateCLv1,
+	}
+
+	// migrationOptions ask Gerrit for what the migrations fill
+	migrationOptions = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "DETAILED_ACCOUNTS"}
+)
+
+// migrateCLv1 adds the owner, the merge time and the tweet ID, and what the
+// records of the first versions of the bot lack, such as the project
+func migrateCLv1(cl *StoredCL, change *gerritCL) {
+	if idx := strings.LastIndex(cl.TweetURL, "/status/"); idx >= 0 && cl.TweetID == "" {
+		cl.TweetID = cl.TweetURL[idx+len("/status/"):]
+	}
+	if change == nil {
+		return
+	}
+
+	fresh := newStoredCL(*change, cl.CrawledAt)
+	if cl.Project == "" {
+		cl.Project, cl.Branch, cl.Subject = fresh.Project, fresh.Branch, fresh.Subject
+	}
+	if cl.AuthorEmail == "" {
+		cl.AuthorName, cl.AuthorEmail = fresh.AuthorName, fresh.AuthorEmail
+	}
+	if cl.RelNote == "" {
+		cl.RelNote = fresh.RelNote
+	}
+	cl.ChangeID = fresh.ChangeID
+	cl.OwnerName, cl.OwnerEmail = fresh.OwnerName, fresh.OwnerEmail
+	if submitted, err := parseGerritTime(change.Submitted); err == nil {
+		cl.MergedAt = submitted
+	}
+}
+
+// upgradeCL migrates the stored CL to the current schema, with what Gerrit
+// knows about it, and returns the upgraded record
+func (b *Bot) upgradeCL(ctx context.Context, cl *StoredCL) (*StoredCL, error) {
+	if cl.SchemaVersion >= clSchemaVersion {
+		return cl, nil
+	}
+
+	var change *gerritCL
+	fetched, err := b.fetchCL(ctx, cl.Number, migrationOptions)
+	if err == nil {
+		change = &fetched
+	} else if err != errGerritNotFound {
+		return nil, fmt.Errorf("could not fetch CL %d: %v", cl.Number, err)
+	}
+
+	return b.store.UpdateCL(ctx, cl.Number, func(stored *StoredCL) error {
+		// it may have been upgraded since it was read
+		for stored.SchemaVersion < clSchemaVersion {
+			clMigrations[stored.SchemaVersion](stored, change)
+			stored.SchemaVersion++
+		}
+		return nil
+	})
+}
+
+// getCL returns the stored CL upgraded to the current schema, or as it is
+// stored when it can't be upgraded now
+func (b *Bot) getCL(ctx context.Context, number int) (*StoredCL, error) {
+	cl, err := b.store.GetCL(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	cl.Number = number
+
+	upgraded, err := b.upgradeCL(ctx, cl)
+	if err != nil {
+		b.logf("could not upgrade CL %d to the schema version %d: %v\n", number, clSchemaVersion, err)
+		return cl, nil
+	}
+	return upgraded, nil
+}
+
+// migrateCLs upgrades the next batch of stored CLs to the current schema,
+// and remembers where it stopped. It does nothing once they all were.
+func (b *Bot) migrateCLs(ctx context.Context) error {
+	migration, err := b.store.CLMigration(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load the progress of the CL migration: %v", err)
+	}
+	if migration.Version != clSchemaVersion {
+		migration = CLMigration{Version: clSchemaVersion}
+	}
+	if migration.Done {
+		return nil
+	}
+
+	cls, err := b.store.CLsAfter(ctx, migration.LastNumber, clMigrationBatch)
+	if err != nil {
+		return fmt.Errorf("could not load the CLs after %d: %v", migration.LastNumber, err)
+	}
+
+	for idx := range cls {
+		cl := &cls[idx]
+		if cl.SchemaVersion < clSchemaVersion {
+			if _, err = b.upgradeCL(ctx, cl); err != nil {
+				break
+			}
+			migration.Upgraded++
+		}
+		migration.LastNumber = cl.Number
+	}
+
+	migration.Done = err == nil && len(cls) < clMigrationBatch
+	migration.UpdatedAt = time.Now()
+	if saveErr := b.store.SaveCLMigration(ctx, migration); saveErr != nil {
+		return fmt.Errorf("could not save the progress of the CL migration: %v", saveErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if migration.Done {
+		b.logf("the stored CLs use the schema version %d, %d of them were upgraded\n", clSchemaVersion, migration.Upgraded)
+	}
+	return nil
+}
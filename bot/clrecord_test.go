@@ -0,0 +1,297 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// saveOldCLs stores the CLs the way the first versions of the bot did
+func saveOldCLs(t *testing.T, store Store, numbers ...int) {
+	t.Helper()
+	for _, number := range numbers {
+		cl := &StoredCL{
+			Number:    number,
+			URL:       "https://golang.org/cl/" + strconv.Itoa(number),
+			Message:   "[go] change",
+			CrawledAt: time.Now(),
+			Tweeted:   true,
+			TweetURL:  "https://twitter.com/golang_cls/status/42",
+		}
+		if err := store.SaveCL(context.Background(), cl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func numberRange(from, to int) []int {
+	numbers := []int{}
+	for n := from; n <= to; n++ {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func schemaVersion(t *testing.T, store Store, number int) int {
+	t.Helper()
+	cl, err := store.GetCL(context.Background(), number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl.SchemaVersion
+}
+
+func TestMigrateCLs(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 60)
+	store := NewMemoryStore()
+	b, _, log := newTestBot(t, store, newGerritServer(t, gerrit, nil))
+	ctx := context.Background()
+
+	// CL 999 is gone from Gerrit, and CL 1010 was already upgraded
+	saveOldCLs(t, store, append([]int{999}, numberRange(1000, 1059)...)...)
+	if _, err := store.UpdateCL(ctx, 1010, func(cl *StoredCL) error {
+		cl.SchemaVersion, cl.OwnerName = clSchemaVersion, "Kept"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []CLMigration{
+		{Version: clSchemaVersion, LastNumber: 1048, Upgraded: 49},
+		{Version: clSchemaVersion, LastNumber: 1059, Upgraded: 60, Done: true},
+	}
+	for i, want := range steps {
+		if err := b.migrateCLs(ctx); err != nil {
+			t.Fatal(err)
+		}
+		migration, err := store.CLMigration(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.UpdatedAt.IsZero() {
+			t.Errorf("run %d did not record when it ran", i+1)
+		}
+		migration.UpdatedAt = time.Time{}
+		if migration != want {
+			t.Errorf("after run %d the migration is %+v, want %+v", i+1, migration, want)
+		}
+	}
+	if log.count("60 of them were upgraded") != 1 {
+		t.Error("the end of the migration was not logged")
+	}
+
+	requests := gerrit.Requests()
+	if err := b.migrateCLs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gerrit.Requests() != requests {
+		t.Error("the migration ran again once done")
+	}
+
+	upgraded, err := store.GetCL(ctx, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.SchemaVersion != clSchemaVersion || upgraded.Project != "go" || upgraded.OwnerName != "Gopher" ||
+		upgraded.ChangeID == "" || upgraded.MergedAt.IsZero() || upgraded.TweetID != "42" {
+		t.Errorf("CL 1000 was not upgraded: %+v", upgraded)
+	}
+
+	gone, err := store.GetCL(ctx, 999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gone.SchemaVersion != clSchemaVersion || gone.TweetID != "42" || gone.Project != "" || gone.Message != "[go] change" {
+		t.Errorf("CL 999, which Gerrit doesn't know, was not upgraded with what the record has: %+v", gone)
+	}
+
+	kept, err := store.GetCL(ctx, 1010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.OwnerName != "Kept" || kept.Project != "" {
+		t.Errorf("CL 1010 was upgraded twice: %+v", kept)
+	}
+}
+
+func TestMigrateCLsFullBatch(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, clMigrationBatch)
+	store := NewMemoryStore()
+	b, _, _ := newTestBot(t, store, newGerritServer(t, gerrit, nil))
+	ctx := context.Background()
+	saveOldCLs(t, store, numberRange(1000, 1000+clMigrationBatch-1)...)
+
+	// a full batch may be followed by more CLs
+	for _, done := range []bool{false, true} {
+		if err := b.migrateCLs(ctx); err != nil {
+			t.Fatal(err)
+		}
+		migration, err := store.CLMigration(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.Done != done || migration.Upgraded != clMigrationBatch {
+			t.Errorf("got the migration %+v, want it done: %v", migration, done)
+		}
+	}
+}
+
+func TestMigrateCLsResumes(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration CLMigration
+		upgraded  []int
+		old       []int
+	}{
+		{
+			name:      "from the last CL checked",
+			migration: CLMigration{Version: clSchemaVersion, LastNumber: 1004, Upgraded: 5},
+			upgraded:  numberRange(1005, 1009),
+			old:       numberRange(1000, 1004),
+		},
+		{
+			name:      "from the start for a new schema version",
+			migration: CLMigration{Version: clSchemaVersion - 1, LastNumber: 1004, Done: true},
+			upgraded:  numberRange(1000, 1009),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gerrit := newFakeGerrit(1000)
+			mergeCLs(gerrit, 10)
+			store := NewMemoryStore()
+			b, _, _ := newTestBot(t, store, newGerritServer(t, gerrit, nil))
+			ctx := context.Background()
+			saveOldCLs(t, store, numberRange(1000, 1009)...)
+			if err := store.SaveCLMigration(ctx, test.migration); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.migrateCLs(ctx); err != nil {
+				t.Fatal(err)
+			}
+			for _, number := range test.upgraded {
+				if schemaVersion(t, store, number) != clSchemaVersion {
+					t.Errorf("CL %d was not upgraded", number)
+				}
+			}
+			for _, number := range test.old {
+				if schemaVersion(t, store, number) != 0 {
+					t.Errorf("CL %d was upgraded again", number)
+				}
+			}
+			if migration, _ := store.CLMigration(ctx); !migration.Done || migration.LastNumber != 1009 {
+				t.Errorf("got the migration %+v", migration)
+			}
+		})
+	}
+}
+
+func TestMigrateCLsStopsAtAnError(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 10)
+	var mu sync.Mutex
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing && r.URL.Path == "/changes/1003" {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		gerrit.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	b, _, _ := newTestBot(t, store, srv.URL+"/changes/?q=status:merged&n=10")
+	ctx := context.Background()
+	saveOldCLs(t, store, numberRange(1000, 1009)...)
+
+	if err := b.migrateCLs(ctx); err == nil {
+		t.Fatal("the migration ignored the error")
+	}
+	migration, err := store.CLMigration(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.Done || migration.LastNumber != 1002 || migration.Upgraded != 3 {
+		t.Errorf("got the migration %+v, want it stopped after CL 1002", migration)
+	}
+	if schemaVersion(t, store, 1003) != 0 || schemaVersion(t, store, 1004) != 0 {
+		t.Error("the CLs after the error were upgraded")
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	if err := b.migrateCLs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if migration, _ := store.CLMigration(ctx); !migration.Done || migration.Upgraded != 10 {
+		t.Errorf("got the migration %+v", migration)
+	}
+}
+
+func TestUpgradeCLUpgradedMeanwhile(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 1)
+	store := NewMemoryStore()
+
+	// another replica upgrades the CL while it's fetched from Gerrit
+	link := newGerritServer(t, gerrit, func(r *http.Request) {
+		_, err := store.UpdateCL(context.Background(), 1000, func(cl *StoredCL) error {
+			cl.SchemaVersion, cl.OwnerName = clSchemaVersion, "Another replica"
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	b, _, _ := newTestBot(t, store, link)
+	saveOldCLs(t, store, 1000)
+
+	stale, err := store.GetCL(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, err := b.upgradeCL(context.Background(), stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.SchemaVersion != clSchemaVersion || upgraded.OwnerName != "Another replica" || upgraded.Project != "" {
+		t.Errorf("the CL was migrated again: %+v", upgraded)
+	}
+}
+
+func TestGetCLUpgradesConcurrently(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 1)
+	store := NewMemoryStore()
+	b, _, _ := newTestBot(t, store, newGerritServer(t, gerrit, nil))
+	saveOldCLs(t, store, 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl, err := b.getCL(context.Background(), 1000)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if cl.SchemaVersion != clSchemaVersion || cl.Project != "go" {
+				t.Errorf("got the CL %+v", cl)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,312 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// MatchKind says how a command trigger is compared to the message text
+	MatchKind int
+
+	// Handler processes a message which matched a command
+	Handler func(ctx context.Context, b *Bot, event *slack.MessageEvent)
+
+	// Command describes something the bot knows how to respond to
+	Command struct {
+		// Name is the main trigger of the command and what help shows
+		Name string
+		// Aliases are additional triggers, compared the same way as Name
+		Aliases []string
+		// Match selects how Name and Aliases are compared to the message
+		Match MatchKind
+		// Pattern is the regular expression used when Match is MatchRegexp
+		Pattern string
+		// Priority decides which command wins when several match, higher first.
+		// Commands with the same priority are checked in registration order.
+		Priority int
+		// Ambient commands look at every message, not only those sent to the bot
+		Ambient bool
+		// Description is a short, one line, explanation of the command
+		Description string
+		// Usage shows how the command should be invoked
+		Usage string
+		// Category groups related commands together in the help
+		Category string
+		// Role is needed to use, and to see, the command. Everyone can use
+		// commands without a role.
+		Role string
+		// InChannel answers to the slash command are visible to the whole
+		// channel, otherwise only the user who typed it sees them
+		InChannel bool
+		// Handler is called when the command matches
+		Handler Handler
+
+		re         *regexp.Regexp
+		fromConfig bool
+	}
+
+	// CommandRegistry holds the commands known by a bot
+	CommandRegistry struct {
+		mu       sync.RWMutex
+		commands []*Command
+	}
+)
+
+// Supported ways of matching a command
+const (
+	MatchExact MatchKind = iota
+	MatchPrefix
+	MatchContains
+	MatchRegexp
+)
+
+// NewCommandRegistry creates an empty command registry
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{}
+}
+
+func (cmd *Command) triggers() []string {
+	return append([]string{cmd.Name}, cmd.Aliases...)
+}
+
+func (cmd *Command) matches(text string) bool {
+	if cmd.Match == MatchRegexp {
+		return cmd.re.MatchString(text)
+	}
+
+	for _, trigger := range cmd.triggers() {
+		switch cmd.Match {
+		case MatchExact:
+			if text == trigger {
+				return true
+			}
+		case MatchPrefix:
+			if strings.HasPrefix(text, trigger) {
+				return true
+			}
+		case MatchContains:
+			if strings.Contains(text, trigger) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Register adds a new command to the registry
+func (r *CommandRegistry) Register(cmd *Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.register(cmd)
+}
+
+func (r *CommandRegistry) register(cmd *Command) error {
+	if cmd == nil {
+		return errors.New("can't register a nil command")
+	}
+	if cmd.Name == "" {
+		return errors.New("command name must not be empty")
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %q has no handler", cmd.Name)
+	}
+
+	switch cmd.Match {
+	case MatchExact, MatchPrefix, MatchContains:
+		cmd.Name = strings.ToLower(cmd.Name)
+		for idx := range cmd.Aliases {
+			cmd.Aliases[idx] = strings.ToLower(cmd.Aliases[idx])
+		}
+	case MatchRegexp:
+		re, err := regexp.Compile(cmd.Pattern)
+		if err != nil {
+			return fmt.Errorf("command %q has an invalid pattern: %v", cmd.Name, err)
+		}
+		cmd.re = re
+	default:
+		return fmt.Errorf("command %q has an unknown match kind: %d", cmd.Name, cmd.Match)
+	}
+
+	for _, existing := range r.commands {
+		if existing.Name == cmd.Name && existing.Ambient == cmd.Ambient {
+			return fmt.Errorf("command %q is already registered", cmd.Name)
+		}
+		if existing.Match != MatchExact || cmd.Match != MatchExact || existing.Ambient != cmd.Ambient {
+			continue
+		}
+		for _, trigger := range cmd.triggers() {
+			if existing.matches(trigger) {
+				return fmt.Errorf("trigger %q of command %q is already used by %q", trigger, cmd.Name, existing.Name)
+			}
+		}
+	}
+
+	r.commands = append(r.commands, cmd)
+	sort.SliceStable(r.commands, func(i, j int) bool {
+		return r.commands[i].Priority > r.commands[j].Priority
+	})
+
+	return nil
+}
+
+// replaceConfigCommands swaps the commands created from the configuration
+// with new ones. The registry is not changed if any of them is invalid.
+func (r *CommandRegistry) replaceConfigCommands(commands []*Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updated := &CommandRegistry{}
+	for _, cmd := range r.commands {
+		if !cmd.fromConfig {
+			updated.commands = append(updated.commands, cmd)
+		}
+	}
+
+	for _, cmd := range commands {
+		cmd.fromConfig = true
+		if err := updated.register(cmd); err != nil {
+			return err
+		}
+	}
+
+	r.commands = updated.commands
+	return nil
+}
+
+// Unregister removes the command with the given name from the registry
+func (r *CommandRegistry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for idx, cmd := range r.commands {
+		if cmd.Name == name {
+			r.commands = append(r.commands[:idx], r.commands[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup finds a command by its name or one of its aliases
+func (r *CommandRegistry) Lookup(name string) *Command {
+	name = strings.ToLower(name)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, cmd := range r.commands {
+		for _, trigger := range cmd.triggers() {
+			if trigger == name {
+				return cmd
+			}
+		}
+	}
+
+	return nil
+}
+
+// Commands returns the registered commands in the order they are matched
+func (r *CommandRegistry) Commands() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	commands := make([]*Command, len(r.commands))
+	copy(commands, r.commands)
+	return commands
+}
+
+// Match returns the highest priority command which matches the text
+func (r *CommandRegistry) Match(text string, ambient bool) *Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, cmd := range r.commands {
+		if cmd.Ambient != ambient {
+			continue
+		}
+		if cmd.matches(text) {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// RegisterCommand adds a new command to the bot
+func (b *Bot) RegisterCommand(cmd *Command) error {
+	return b.commands.Register(cmd)
+}
+
+// Commands returns the command registry of the bot
+func (b *Bot) Commands() *CommandRegistry {
+	return b.commands
+}
+
+// Respond sends a message to the channel the event came from
+func (b *Bot) Respond(ctx context.Context, event *slack.MessageEvent, response string) {
+	respond(ctx, b, event, response)
+}
+
+// React adds a reaction to the message of the event
+func (b *Bot) React(ctx context.Context, event *slack.MessageEvent, reaction string) {
+	b.reactToEvent(ctx, event, reaction)
+}
+
+// arguments returns what follows the command name in the message, keeping
+// the original case so that mentions and other Slack markup stay intact
+func (b *Bot) arguments(event *slack.MessageEvent, name string) string {
+	text := strings.Trim(event.Text, " \n\r")
+	for _, prefix := range []string{b.msgprefix, "gopher"} {
+		if strings.HasPrefix(strings.ToLower(text), prefix) {
+			text = text[len(prefix):]
+			break
+		}
+	}
+	// the colon of "gopher: ..." is not part of the arguments, which can end with one
+	text = strings.TrimLeft(text, " :\n")
+
+	if strings.HasPrefix(strings.ToLower(text), name) {
+		text = text[len(name):]
+	}
+	return strings.TrimSpace(text)
+}
+
+func cannedResponse(lines ...string) Handler {
+	response := strings.Join(lines, "\n")
+	return func(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+		respond(ctx, b, event, response)
+	}
+}
+
+func reactWith(reactions ...string) Handler {
+	return func(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+		for _, reaction := range reactions {
+			b.reactToEvent(ctx, event, reaction)
+		}
+	}
+}
+
+func respondWith(responses ...string) Handler {
+	return func(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+		for _, response := range responses {
+			respond(ctx, b, event, response)
+		}
+	}
+}
+
+func godocHandler(prefix string, position int) Handler {
+	return func(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+		b.godoc(ctx, event, prefix, position)
+	}
+}
@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	commitTrailer struct {
+		key   string
+		value string
+	}
+
+	// commitMessage is a commit message split into its subject, its body
+	// and the trailers added by Gerrit, such as Change-Id and Reviewed-by
+	commitMessage struct {
+		subject  string
+		body     string
+		trailers []commitTrailer
+	}
+)
+
+// maxCommitMessageChars is how much of the body of a commit message is posted
+const maxCommitMessageChars = 600
+
+var (
+	commitTrailerRE = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*): *(.+)$`)
+	// the last lines are trailers when they have one of these, even when they
+	// follow the body without a blank line like Gerrit accepts them
+	knownTrailers = map[string]bool{
+		"change-id":          true,
+		"reviewed-on":        true,
+		"reviewed-by":        true,
+		"run-trybot":         true,
+		"trybot-result":      true,
+		"signed-off-by":      true,
+		"cq-include-trybots": true,
+	}
+
+	// list items and lines such as "Fixes #123" are not joined to the previous line
+	listItemRE  = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s`)
+	issueLineRE = regexp.MustCompile(`(?i)^(?:fixes|updates|closes|for)\s+[\w./-]*#\d+`)
+	// issues are mentioned as #123 or golang/go#123
+	issueRE = regexp.MustCompile(`(^|[\s(\[,;])((?:([\w.-]+/[\w.-]+))?#(\d+))\b`)
+	// relNoteRE matches the RELNOTE=yes, or RELNOTE=<text>, lines of the body
+	relNoteRE = regexp.MustCompile(`(?m)^\s*RELNOTES?=(.+)$`)
+)
+
+func parseCommitMessage(message string) commitMessage {
+	lines := strings.Split(strings.TrimSpace(strings.Replace(message, "\r\n", "\n", -1)), "\n")
+	msg := commitMessage{subject: lines[0]}
+	lines = lines[1:]
+
+	start := len(lines)
+	known := false
+	for start > 0 {
+		match := commitTrailerRE.FindStringSubmatch(lines[start-1])
+		if match == nil {
+			break
+		}
+		known = known || knownTrailers[strings.ToLower(match[1])]
+		start--
+	}
+	if known {
+		for _, line := range lines[start:] {
+			match := commitTrailerRE.FindStringSubmatch(line)
+			msg.trailers = append(msg.trailers, commitTrailer{key: match[1], value: strings.TrimSpace(match[2])})
+		}
+		lines = lines[:start]
+	}
+
+	msg.body = strings.TrimSpace(strings.Join(lines, "\n"))
+	return msg
+}
+
+// trailer returns the values of the trailers with the key
+func (msg commitMessage) trailer(key string) []string {
+	values := []string{}
+	for _, trailer := range msg.trailers {
+		if strings.EqualFold(trailer.key, key) {
+			values = append(values, trailer.value)
+		}
+	}
+	return values
+}
+
+// trailerName drops the e-mail address from "Name <email>"
+func trailerName(value string) string {
+	if idx := strings.Index(value, "<"); idx > 0 {
+		return strings.TrimSpace(value[:idx])
+	}
+	return value
+}
+
+func (msg commitMessage) reviewers() []string {
+	reviewers := []string{}
+	for _, value := range msg.trailer("Reviewed-by") {
+		reviewers = append(reviewers, trailerName(value))
+	}
+	return reviewers
+}
+
+// tryBots says if the TryBots passed, and who ran them, empty when they didn't run
+func (msg commitMessage) tryBots() string {
+	status := ""
+	if len(msg.trailer("TryBot-Result")) > 0 {
+		status = "passed"
+	}
+	if runBy := msg.trailer("Run-TryBot"); len(runBy) > 0 {
+		if status == "" {
+			status = "no result"
+		}
+		status += ", run by " + trailerName(runBy[0])
+	}
+	return status
+}
+
+// relNote returns what the RELNOTE lines say, such as "yes" or the text of
+// the note, or nothing when there are none or they say no
+func (msg commitMessage) relNote() string {
+	notes := []string{}
+	for _, match := range relNoteRE.FindAllStringSubmatch(msg.body, -1) {
+		note := strings.TrimSpace(match[1])
+		switch strings.ToLower(note) {
+		case "", "no", "n", "false":
+			continue
+		}
+		notes = append(notes, note)
+	}
+	return strings.Join(notes, "\n")
+}
+
+// fields folds the trailers worth showing into attachment fields
+func (msg commitMessage) fields() []slack.AttachmentField {
+	fields := []slack.AttachmentField{}
+	if reviewers := msg.reviewers(); len(reviewers) > 0 {
+		fields = append(fields, slack.AttachmentField{Title: "Reviewed by", Value: strings.Join(reviewers, ", "), Short: true})
+	}
+	if tryBots := msg.tryBots(); tryBots != "" {
+		fields = append(fields, slack.AttachmentField{Title: "TryBots", Value: tryBots, Short: true})
+	}
+	if note := msg.relNote(); note != "" {
+		fields = append(fields, slack.AttachmentField{Title: "Release note", Value: escapeMrkdwn(note)})
+	}
+	return fields
+}
+
+// truncateText cuts the text to at most limit bytes, at the end of a
+// paragraph or of a word when possible, and marks the cut
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+
+	cut := strings.LastIndex(text[:limit], "\n\n")
+	if cut < limit/2 {
+		cut = strings.LastIndexAny(text[:limit], " \n\t")
+	}
+	if cut < limit/2 {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimSpace(text[:cut]) + " …"
+}
+
+// escapeMrkdwn escapes the characters Slack uses for its markup
+func escapeMrkdwn(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// linkIssues turns the mentions of issues into links to GitHub
+func linkIssues(text string) string {
+	return issueRE.ReplaceAllStringFunc(text, func(mention string) string {
+		match := issueRE.FindStringSubmatch(mention)
+		link := "https://golang.org/issue/" + match[4]
+		if repo := match[3]; repo != "" && repo != "golang/go" {
+			link = "https://github.com/" + repo + "/issues/" + match[4]
+		}
+		return match[1] + "<" + link + "|" + match[2] + ">"
+	})
+}
+
+// mrkdwn renders the body for Slack: the hard wrapped lines of a paragraph
+// are joined, the indented lines become code blocks and the issues become links
+func (msg commitMessage) mrkdwn(limit int) string {
+	out := []string{}
+	paragraph := ""
+	inCode := false
+
+	flush := func() {
+		if paragraph != "" {
+			out = append(out, linkIssues(escapeMrkdwn(paragraph)))
+			paragraph = ""
+		}
+	}
+
+	for _, line := range strings.Split(truncateText(msg.body, limit), "\n") {
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ") {
+			flush()
+			if !inCode {
+				out = append(out, "```")
+				inCode = true
+			}
+			out = append(out, escapeMrkdwn(strings.TrimPrefix(line, "\t")))
+			continue
+		}
+		if inCode {
+			out[len(out)-1] += "```"
+			inCode = false
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+		case listItemRE.MatchString(line) || issueLineRE.MatchString(line):
+			flush()
+			paragraph = strings.TrimSpace(line)
+		case paragraph == "":
+			paragraph = strings.TrimSpace(line)
+		default:
+			paragraph += " " + strings.TrimSpace(line)
+		}
+	}
+	flush()
+	if inCode {
+		out[len(out)-1] += "```"
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseCommitMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		body     string
+		trailers []commitTrailer
+	}{
+		{
+			name:    "subject only",
+			message: "net/http: fix the timeouts\n",
+		},
+		{
+			name:    "trailers",
+			message: "net/http: fix the timeouts\r\n\r\nThe timeouts were ignored.\r\n\r\nFixes #123\r\n\r\nChange-Id: I1\r\nReviewed-by: Alice <alice@golang.org>\r\n",
+			body:    "The timeouts were ignored.\n\nFixes #123",
+			trailers: []commitTrailer{
+				{key: "Change-Id", value: "I1"},
+				{key: "Reviewed-by", value: "Alice <alice@golang.org>"},
+			},
+		},
+		{
+			name:     "trailers without a body",
+			message:  "net/http: fix the timeouts\nChange-Id: I1",
+			trailers: []commitTrailer{{key: "Change-Id", value: "I1"}},
+		},
+		{
+			name:    "trailers not preceded by a blank line",
+			message: "net/http: fix the timeouts\n\nFixes #123\nChange-Id: I1\nRun-TryBot: Bob <bob@golang.org>",
+			body:    "Fixes #123",
+			trailers: []commitTrailer{
+				{key: "Change-Id", value: "I1"},
+				{key: "Run-TryBot", value: "Bob <bob@golang.org>"},
+			},
+		},
+		{
+			name:    "unknown trailers",
+			message: "net/http: fix the timeouts\n\nSee the spec.\n\nSpec: RFC 7230\nNote: section 6",
+			body:    "See the spec.\n\nSpec: RFC 7230\nNote: section 6",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := parseCommitMessage(test.message)
+			if msg.subject != "net/http: fix the timeouts" {
+				t.Errorf("the subject is %q", msg.subject)
+			}
+			if msg.body != test.body {
+				t.Errorf("the body is %q, want %q", msg.body, test.body)
+			}
+			if !reflect.DeepEqual(msg.trailers, test.trailers) {
+				t.Errorf("the trailers are %q, want %q", msg.trailers, test.trailers)
+			}
+		})
+	}
+}
+
+func TestCommitMessageFields(t *testing.T) {
+	msg := parseCommitMessage("net/http: fix the timeouts\n\nRELNOTE=The <Server> timeouts\n\nReviewed-on: https://go-review.googlesource.com/1000\nRun-TryBot: Bob <bob@golang.org>\nTryBot-Result: Gobot <gobot@golang.org>\nReviewed-by: Alice <alice@golang.org>\nReviewed-by: Bob <bob@golang.org>")
+
+	want := map[string]string{
+		"Reviewed by":  "Alice, Bob",
+		"TryBots":      "passed, run by Bob",
+		"Release note": "The &lt;Server&gt; timeouts",
+	}
+	fields := msg.fields()
+	if len(fields) != len(want) {
+		t.Fatalf("got the fields %+v", fields)
+	}
+	for _, field := range fields {
+		if field.Value != want[field.Title] {
+			t.Errorf("%s is %q, want %q", field.Title, field.Value, want[field.Title])
+		}
+	}
+}
+
+func TestRelNote(t *testing.T) {
+	tests := []struct {
+		body string
+		note string
+	}{
+		{body: "Fixes #123", note: ""},
+		{body: "Fixes #123\n\nRELNOTE=yes", note: "yes"},
+		{body: "RELNOTES=The timeouts are honored", note: "The timeouts are honored"},
+		{body: "Fixes #123\n\nRELNOTE=no", note: ""},
+		{body: "RELNOTE=No\nRELNOTE=n\nRELNOTE=false", note: ""},
+		{body: "  RELNOTE=first\nRELNOTE=second", note: "first\nsecond"},
+		{body: "Set RELNOTE=yes to add a note", note: ""},
+	}
+
+	for _, test := range tests {
+		if note := (commitMessage{body: test.body}).relNote(); note != test.note {
+			t.Errorf("the release note of %q is %q, want %q", test.body, note, test.note)
+		}
+	}
+	msg := commitMessage{body: "RELNOTE=no"}
+	if fields := msg.fields(); len(fields) != 0 {
+		t.Errorf("RELNOTE=no got the fields %+v", fields)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{name: "short", text: "Short text", limit: 20, want: "Short text"},
+		{name: "paragraph", text: "First paragraph.\n\nSecond paragraph.", limit: 25, want: "First paragraph. …"},
+		{name: "word", text: "Some words to cut", limit: 12, want: "Some words …"},
+		{name: "middle of a character", text: strings.Repeat("é", 20), limit: 11, want: strings.Repeat("é", 5) + " …"},
+		{name: "middle of a word with characters", text: "ça fonctionné très bien", limit: 16, want: "ça fonctionné …"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := truncateText(test.text, test.limit)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestLinkIssues(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{
+			text: "Fixes #123",
+			want: "Fixes <https://golang.org/issue/123|#123>",
+		},
+		{
+			text: "Updates golang/go#123",
+			want: "Updates <https://golang.org/issue/123|golang/go#123>",
+		},
+		{
+			text: "Updates golang/tools#4",
+			want: "Updates <https://github.com/golang/tools/issues/4|golang/tools#4>",
+		},
+		{
+			text: "See #1, #2 (#3) and [#4]",
+			want: "See <https://golang.org/issue/1|#1>, <https://golang.org/issue/2|#2> (<https://golang.org/issue/3|#3>) and [<https://golang.org/issue/4|#4>]",
+		},
+		{
+			text: "The issue#5 and #6a are not issues",
+			want: "The issue#5 and #6a are not issues",
+		},
+	}
+
+	for _, test := range tests {
+		if got := linkIssues(test.text); got != test.want {
+			t.Errorf("linkIssues(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestCommitMessageMrkdwn(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		limit int
+		want  string
+	}{
+		{
+			name: "hard wrapped paragraphs",
+			body: "The timeouts\nwere ignored.\n\n\n\nNow they're <not>.",
+			want: "The timeouts were ignored.\n\nNow they're &lt;not&gt;.",
+		},
+		{
+			name: "lists and issues",
+			body: "Changes:\n- one\n  continued\n* two\n1. three\nUpdates golang/go#123\nUpdates golang/tools#4",
+			want: "Changes:\n- one continued\n* two\n1. three\n" +
+				"Updates <https://golang.org/issue/123|golang/go#123>\n" +
+				"Updates <https://github.com/golang/tools/issues/4|golang/tools#4>",
+		},
+		{
+			name: "code block",
+			body: "For example:\n\n\tif a < b {\n\t\treturn #1\n\t}\n\nDone.",
+			want: "For example:\n\n```\nif a &lt; b {\n\treturn #1\n}```\n\nDone.",
+		},
+		{
+			name: "code block at the end",
+			body: "For example:\n    x := 1",
+			want: "For example:\n```\n    x := 1```",
+		},
+		{
+			name:  "code block open at the cut",
+			body:  "For example:\n\n\tfunc f() {\n\t\treturn 1 < 2\n\t}\n\nDone.",
+			limit: 40,
+			want:  "For example:\n\n```\nfunc f() {\n\treturn 1 &lt; …```",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit := test.limit
+			if limit == 0 {
+				limit = maxCommitMessageChars
+			}
+			if got := (commitMessage{body: test.body}).mrkdwn(limit); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
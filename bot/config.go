@@ -0,0 +1,407 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// ChannelConfig describes a channel the bot knows about
+	ChannelConfig struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		// Welcome channels are listed in the message sent to new members
+		Welcome bool `json:"welcome"`
+		// Special channels are not listed in the recommended channels
+		Special bool `json:"special"`
+	}
+
+	// ResponseConfig is a canned response to a message sent to the bot
+	ResponseConfig struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Category    string   `json:"category"`
+		Lines       []string `json:"lines"`
+		// InChannel answers to the slash command are visible to the whole channel
+		InChannel bool `json:"in_channel"`
+	}
+
+	// ReactionConfig reacts, or replies, to messages containing or starting with some text
+	ReactionConfig struct {
+		Contains  []string `json:"contains"`
+		Prefix    []string `json:"prefix"`
+		Reactions []string `json:"reactions"`
+		Replies   []string `json:"replies"`
+		// Directed reactions only apply to messages sent to the bot
+		Directed bool `json:"directed"`
+	}
+
+	// WelcomeConfig is the message sent to new members, the welcome channels
+	// are listed between the header and the footer
+	WelcomeConfig struct {
+		Header string `json:"header"`
+		Footer string `json:"footer"`
+	}
+
+	// Config is the content of the bot which can be changed without a redeploy
+	Config struct {
+		Channels    []ChannelConfig   `json:"channels"`
+		Responses   []ResponseConfig  `json:"responses"`
+		Aliases     map[string]string `json:"aliases"`
+		Reactions   []ReactionConfig  `json:"reactions"`
+		XKCDAliases map[string]int    `json:"xkcd_aliases"`
+		Welcome     WelcomeConfig     `json:"welcome"`
+
+		CLRouting CLRoutingConfig `json:"cl_routing"`
+		Reviews   ReviewsConfig   `json:"reviews"`
+		CLLinks   CLLinksConfig   `json:"cl_links"`
+		Digest    DigestConfig    `json:"digest"`
+		RelNotes  RelNotesConfig  `json:"relnotes"`
+
+		Admins       AdminsConfig          `json:"admins"`
+		Roles        map[string]RoleConfig `json:"roles"`
+		DeployNotify DeployNotifyConfig    `json:"deploy_notify"`
+
+		path string
+	}
+)
+
+// requiredChannels are used by the bot itself so they must always be configured
+var requiredChannels = []string{"golang-cls", "golang_cls", "gotimefm"}
+
+// LoadConfig reads and validates the configuration file
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	cfg.path = path
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration for mistakes
+func (cfg *Config) Validate() error {
+	problems := []string{}
+
+	channels := map[string]bool{}
+	for idx, channel := range cfg.Channels {
+		name := strings.ToLower(channel.Name)
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("channel #%d has no name", idx+1))
+			continue
+		}
+		if channels[name] {
+			problems = append(problems, fmt.Sprintf("channel %q is configured twice", name))
+		}
+		channels[name] = true
+	}
+	for _, name := range requiredChannels {
+		if !channels[name] {
+			problems = append(problems, fmt.Sprintf("missing required channel %q", name))
+		}
+	}
+
+	responses := map[string]bool{}
+	for idx, response := range cfg.Responses {
+		name := strings.ToLower(response.Name)
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("response #%d has no name", idx+1))
+			continue
+		}
+		if responses[name] {
+			problems = append(problems, fmt.Sprintf("response %q is configured twice", name))
+		}
+		if len(response.Lines) == 0 {
+			problems = append(problems, fmt.Sprintf("response %q has no lines", name))
+		}
+		responses[name] = true
+	}
+	for alias, target := range cfg.Aliases {
+		if !responses[strings.ToLower(target)] {
+			problems = append(problems, fmt.Sprintf("alias %q points to the unknown response %q", alias, target))
+		}
+		if responses[strings.ToLower(alias)] {
+			problems = append(problems, fmt.Sprintf("alias %q is also the name of a response", alias))
+		}
+	}
+
+	for idx, reaction := range cfg.Reactions {
+		if (len(reaction.Contains) == 0) == (len(reaction.Prefix) == 0) {
+			problems = append(problems, fmt.Sprintf("reaction #%d must have either contains or prefix", idx+1))
+		}
+		if len(reaction.Reactions) == 0 && len(reaction.Replies) == 0 {
+			problems = append(problems, fmt.Sprintf("reaction #%d has neither reactions nor replies", idx+1))
+		}
+	}
+
+	for _, name := range cfg.DeployNotify.Channels {
+		if !channels[strings.ToLower(name)] {
+			problems = append(problems, fmt.Sprintf("deploy notifications go to the unknown channel %q", name))
+		}
+	}
+
+	problems = append(problems, cfg.CLRouting.validate(channels)...)
+	problems = append(problems, cfg.Reviews.validate(channels)...)
+	problems = append(problems, cfg.CLLinks.validate(channels)...)
+	problems = append(problems, cfg.Digest.validate(channels)...)
+	problems = append(problems, cfg.RelNotes.validate()...)
+
+	for role, holders := range cfg.Roles {
+		if !roleNameRE.MatchString(role) {
+			problems = append(problems, fmt.Sprintf("invalid role name %q, use lowercase letters, digits and dashes", role))
+		}
+		if role == RoleAdmin {
+			problems = append(problems, "admins are configured in the admins section, not in roles")
+		}
+		for _, name := range holders.Channels {
+			if !channels[strings.ToLower(name)] {
+				problems = append(problems, fmt.Sprintf("role %q is given to the unknown channel %q", role, name))
+			}
+		}
+	}
+
+	for alias, comic := range cfg.XKCDAliases {
+		if comic <= 0 {
+			problems = append(problems, fmt.Sprintf("xkcd alias %q points to the invalid comic %d", alias, comic))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// commands builds the commands for the canned responses and reactions
+func (cfg *Config) commands() []*Command {
+	commands := []*Command{}
+
+	aliases := map[string][]string{}
+	for alias, target := range cfg.Aliases {
+		target = strings.ToLower(target)
+		aliases[target] = append(aliases[target], alias)
+	}
+	for _, targetAliases := range aliases {
+		sort.Strings(targetAliases)
+	}
+
+	for _, response := range cfg.Responses {
+		commands = append(commands, &Command{
+			Name:        response.Name,
+			Aliases:     aliases[strings.ToLower(response.Name)],
+			Priority:    PriorityNormal,
+			Category:    response.Category,
+			Description: response.Description,
+			InChannel:   response.InChannel,
+			Handler:     cannedResponse(response.Lines...),
+			fromConfig:  true,
+		})
+	}
+
+	for _, reaction := range cfg.Reactions {
+		cmd := &Command{
+			Match:      MatchContains,
+			Ambient:    !reaction.Directed,
+			Priority:   PriorityReaction,
+			fromConfig: true,
+		}
+
+		triggers := reaction.Contains
+		if len(reaction.Prefix) > 0 {
+			triggers = reaction.Prefix
+			cmd.Match = MatchPrefix
+		}
+		cmd.Name, cmd.Aliases = triggers[0], append([]string{}, triggers[1:]...)
+
+		if reaction.Directed {
+			cmd.Priority = PriorityLow
+			if cmd.Match == MatchPrefix {
+				cmd.Priority = PriorityLowest
+			}
+		}
+
+		if len(reaction.Replies) > 0 {
+			cmd.Handler = respondWith(reaction.Replies...)
+		} else {
+			cmd.Handler = reactWith(reaction.Reactions...)
+		}
+
+		commands = append(commands, cmd)
+	}
+
+	return commands
+}
+
+// resolveChannels finds the Slack IDs of the configured channels
+func (b *Bot) resolveChannels(ctx context.Context, cfg *Config, span Span) (map[string]slackChan, error) {
+	channels := map[string]slackChan{}
+	for _, channel := range cfg.Channels {
+		channels[strings.ToLower(channel.Name)] = slackChan{
+			description: channel.Description,
+			welcome:     channel.Welcome,
+			special:     channel.Special,
+		}
+	}
+
+	b.logf("Determining channels ID\n")
+	childSpan := span.NewChild("slackApi.GetChannels")
+	publicChannels, err := b.chat.Channels(ctx, false)
+	childSpan.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range publicChannels {
+		channelName := strings.ToLower(channel.Name)
+		if chn, ok := channels[channelName]; ok {
+			chn.slackID = "#" + channel.ID
+			channels[channelName] = chn
+		}
+	}
+
+	b.logf("Determining groups ID\n")
+	childSpan = span.NewChild("slackApi.GetGroups")
+	botGroups, err := b.chat.Channels(ctx, true)
+	childSpan.Finish()
+	if err != nil {
+		b.logf("could not list the groups: %v\n", err)
+	}
+	for _, group := range botGroups {
+		groupName := strings.ToLower(group.Name)
+		if chn, ok := channels[groupName]; ok && chn.slackID == "" {
+			chn.slackID = group.ID
+			channels[groupName] = chn
+		}
+	}
+
+	missing := []string{}
+	for _, channel := range cfg.Channels {
+		if channels[strings.ToLower(channel.Name)].slackID == "" {
+			missing = append(missing, channel.Name)
+		}
+	}
+	if len(missing) > 0 {
+		err := fmt.Errorf("could not find the configured channels: %s", strings.Join(missing, ", "))
+		// development workspaces rarely have all the channels
+		if !b.devMode {
+			return nil, err
+		}
+		b.logf("%v\n", err)
+	}
+
+	return channels, nil
+}
+
+func (b *Bot) buildWelcomeMessage(cfg *Config, channels map[string]slackChan) string {
+	message := cfg.Welcome.Header
+	for _, channel := range cfg.Channels {
+		if !channel.Welcome {
+			continue
+		}
+		val := channels[strings.ToLower(channel.Name)]
+		message += `<` + val.slackID + `|` + channel.Name + `> -> ` + val.description + "\n"
+	}
+	return message + cfg.Welcome.Footer
+}
+
+// ApplyConfig switches the bot to a new configuration. Nothing changes if
+// the configuration can't be applied.
+func (b *Bot) ApplyConfig(ctx context.Context, cfg *Config, span Span) error {
+	channels, err := b.resolveChannels(ctx, cfg, span)
+	if err != nil {
+		return err
+	}
+
+	directory := &userDirectory{chat: b.chat}
+	admins, err := b.resolveAdmins(ctx, cfg, directory, span)
+	if err != nil {
+		return err
+	}
+
+	roles, err := b.resolveRoles(ctx, cfg, channels, directory, span)
+	if err != nil {
+		return err
+	}
+
+	if err := b.commands.replaceConfigCommands(cfg.commands()); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.config = cfg
+	b.channels = channels
+	b.admins = admins
+	b.roles = roles
+	b.welcomeMessage = b.buildWelcomeMessage(cfg, channels)
+	b.mu.Unlock()
+
+	return nil
+}
+
+// ReloadConfig loads the configuration file again and applies it
+func (b *Bot) ReloadConfig(ctx context.Context) error {
+	span := SpanFromContext(ctx).NewChild("b.ReloadConfig")
+	defer span.Finish()
+
+	b.mu.RLock()
+	path := b.config.path
+	b.mu.RUnlock()
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	return b.ApplyConfig(ctx, cfg, span)
+}
+
+func (b *Bot) channel(name string) slackChan {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.channels[name]
+}
+
+func (b *Bot) channelConfigs() ([]ChannelConfig, map[string]slackChan) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.config.Channels, b.channels
+}
+
+func (b *Bot) xkcdAlias(name string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.config.XKCDAliases[name]
+}
+
+func reloadConfig(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	response := `Configuration reloaded`
+	if err := b.ReloadConfig(ctx); err != nil {
+		b.logf("failed to reload the configuration: %v\n", err)
+		response = fmt.Sprintf("Could not reload the configuration, keeping the current one: %v", err)
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err := b.chat.DirectMessage(ctx, event.User, response, params)
+	if err != nil {
+		b.logf("%s\n", err)
+	}
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// At runs a job once, at the given time
+	At time.Time
+
+	// cronSchedule is a parsed cron expression: minute, hour, day of the
+	// month, month and day of the week
+	cronSchedule struct {
+		expr string
+		loc  *time.Location
+
+		minute, hour, dom, month, dow uint64
+		// a restricted day of the month and day of the week match either of
+		// them, like in cron, otherwise both have to match
+		domAny, dowAny bool
+	}
+
+	cronField struct {
+		name     string
+		min, max int
+		names    map[string]int
+	}
+)
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of the month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// both 0 and 7 are sunday
+	cronDow = cronField{name: "day of the week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Next returns the time of the run, or the zero time once it is in the past
+func (a At) Next(after time.Time) time.Time {
+	if time.Time(a).After(after) {
+		return time.Time(a)
+	}
+	return time.Time{}
+}
+
+func (a At) String() string {
+	return "once at " + time.Time(a).Format("2006-01-02 15:04 MST")
+}
+
+// ParseSchedule reads a cron expression with five fields, such as
+// "30 9 * * mon-fri", a descriptor such as "@daily", or "@every 10m".
+// The cron expressions are evaluated in the location, UTC when nil.
+func ParseSchedule(expr string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	expr = strings.TrimSpace(expr)
+	lower := strings.ToLower(expr)
+
+	if strings.HasPrefix(lower, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(lower[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in %q: %v", expr, err)
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("the interval in %q must be at least a minute", expr)
+		}
+		return Every(interval), nil
+	}
+
+	fields := strings.Fields(lower)
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		descriptor, ok := cronDescriptors[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule %q", expr)
+		}
+		fields = strings.Fields(descriptor)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("the cron expression %q must have 5 fields: minute, hour, day of the month, month and day of the week", expr)
+	}
+
+	cron := &cronSchedule{expr: expr, loc: loc}
+	var err error
+	if cron.minute, _, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if cron.hour, _, err = cronHour.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if cron.dom, cron.domAny, err = cronDom.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if cron.month, _, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if cron.dow, cron.dowAny, err = cronDow.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+
+	return cron, nil
+}
+
+// parse reads a comma separated list of values, ranges and steps into a bit
+// set, any says if the field was "*"
+func (f cronField) parse(field string) (bits uint64, any bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			rangePart = part[:idx]
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return 0, false, fmt.Errorf("invalid step in the %s %q", f.name, part)
+			}
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case rangePart == "*":
+			any = any || step == 1
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, false, err
+			}
+			if high, err = f.value(bounds[1]); err != nil {
+				return 0, false, err
+			}
+			if low > high {
+				return 0, false, fmt.Errorf("invalid range in the %s %q", f.name, part)
+			}
+		default:
+			if low, err = f.value(rangePart); err != nil {
+				return 0, false, err
+			}
+			if step == 1 {
+				high = low
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, any, nil
+}
+
+func (f cronField) value(text string) (int, error) {
+	if value, ok := f.names[text]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid %s %q, it must be between %d and %d", f.name, text, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next finds the first minute after the given time which matches every field
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+
+	// an expression such as "0 0 30 2 *" never matches
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+			// like in cron, the runs of an hour skipped when the clocks go
+			// forward happen right after it
+			skipped := t.Hour() + 1
+			if skipped < 24 && next.Hour() != skipped && c.hour&(1<<uint(skipped)) != 0 {
+				return next
+			}
+			t = next
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) String() string {
+	if c.loc == time.UTC {
+		return c.expr
+	}
+	return c.expr + " " + c.loc.String()
+}
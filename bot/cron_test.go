@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("the time zone %s is not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	paris := mustLoadLocation(t, "Europe/Paris")
+	newYork := mustLoadLocation(t, "America/New_York")
+	utc := func(text string) time.Time {
+		at, err := time.Parse("2006-01-02 15:04", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	in := func(loc *time.Location, text string) time.Time {
+		at, err := time.ParseInLocation("2006-01-02 15:04 MST", text, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		loc   *time.Location
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			name:  "every quarter of an hour",
+			expr:  "*/15 * * * *",
+			after: utc("2018-01-15 10:07"),
+			want:  []time.Time{utc("2018-01-15 10:15"), utc("2018-01-15 10:30"), utc("2018-01-15 10:45"), utc("2018-01-15 11:00")},
+		},
+		{
+			name:  "range with a step",
+			expr:  "0 9 1-5/2 * *",
+			after: utc("2018-01-01 10:00"),
+			want:  []time.Time{utc("2018-01-03 09:00"), utc("2018-01-05 09:00"), utc("2018-02-01 09:00")},
+		},
+		{
+			name:  "list of minutes and hours",
+			expr:  "0,30 8,17 * * *",
+			after: utc("2018-01-15 08:00"),
+			want:  []time.Time{utc("2018-01-15 08:30"), utc("2018-01-15 17:00"), utc("2018-01-15 17:30"), utc("2018-01-16 08:00")},
+		},
+		{
+			name:  "named days",
+			expr:  "30 9 * * mon-fri",
+			after: utc("2018-01-19 10:00"),
+			want:  []time.Time{utc("2018-01-22 09:30"), utc("2018-01-23 09:30")},
+		},
+		{
+			name:  "named months",
+			expr:  "0 0 1 jan,jul *",
+			after: utc("2018-01-15 00:00"),
+			want:  []time.Time{utc("2018-07-01 00:00"), utc("2019-01-01 00:00")},
+		},
+		{
+			name:  "7 is sunday",
+			expr:  "0 12 * * 7",
+			after: utc("2018-01-01 00:00"),
+			want:  []time.Time{utc("2018-01-07 12:00"), utc("2018-01-14 12:00")},
+		},
+		{
+			name:  "day of the month or day of the week",
+			expr:  "0 0 1 * 1",
+			after: utc("2018-01-24 00:00"),
+			want:  []time.Time{utc("2018-01-29 00:00"), utc("2018-02-01 00:00"), utc("2018-02-05 00:00")},
+		},
+		{
+			name:  "leap day",
+			expr:  "0 0 29 2 *",
+			after: utc("2018-01-01 00:00"),
+			want:  []time.Time{utc("2020-02-29 00:00"), utc("2024-02-29 00:00")},
+		},
+		{
+			name:  "never",
+			expr:  "0 0 30 2 *",
+			after: utc("2018-01-01 00:00"),
+			want:  []time.Time{{}},
+		},
+		{
+			name:  "descriptor",
+			expr:  "@weekly",
+			after: utc("2018-01-15 10:00"),
+			want:  []time.Time{utc("2018-01-21 00:00"), utc("2018-01-28 00:00")},
+		},
+		{
+			name:  "time zone",
+			expr:  "0 16 * * fri",
+			loc:   newYork,
+			after: utc("2018-01-19 20:00"),
+			want:  []time.Time{utc("2018-01-19 21:00"), utc("2018-01-26 21:00")},
+		},
+		{
+			name:  "the clocks go forward",
+			expr:  "30 2 * * *",
+			loc:   paris,
+			after: in(paris, "2021-03-27 23:00 CET"),
+			want:  []time.Time{in(paris, "2021-03-28 03:00 CEST"), in(paris, "2021-03-29 02:30 CEST")},
+		},
+		{
+			name:  "every hour when the clocks go forward",
+			expr:  "0 * * * *",
+			loc:   paris,
+			after: in(paris, "2021-03-28 00:30 CET"),
+			want:  []time.Time{in(paris, "2021-03-28 01:00 CET"), in(paris, "2021-03-28 03:00 CEST"), in(paris, "2021-03-28 04:00 CEST")},
+		},
+		{
+			name:  "the clocks go back",
+			expr:  "30 2 * * *",
+			loc:   paris,
+			after: in(paris, "2021-10-30 23:00 CEST"),
+			want:  []time.Time{in(paris, "2021-10-31 02:30 CET"), in(paris, "2021-11-01 02:30 CET")},
+		},
+		{
+			name:  "every hour when the clocks go back",
+			expr:  "0 * * * *",
+			loc:   paris,
+			after: in(paris, "2021-10-31 01:30 CEST"),
+			want:  []time.Time{in(paris, "2021-10-31 02:00 CEST"), in(paris, "2021-10-31 02:00 CET"), in(paris, "2021-10-31 03:00 CET")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.expr, test.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after := test.after
+			for _, want := range test.want {
+				next := schedule.Next(after)
+				if !next.Equal(want) {
+					t.Fatalf("the run after %s is %s, want %s", after, next, want)
+				}
+				after = next
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	paris := mustLoadLocation(t, "Europe/Paris")
+
+	tests := []struct {
+		expr   string
+		loc    *time.Location
+		string string
+	}{
+		{expr: "30 9 * * mon-fri", string: "30 9 * * mon-fri"},
+		{expr: " 30 9 * * MON-FRI ", loc: paris, string: "30 9 * * MON-FRI Europe/Paris"},
+		{expr: "@daily", string: "@daily"},
+		{expr: "@every 2h", string: "every 2h0m0s"},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.expr, test.loc)
+		if err != nil {
+			t.Errorf("could not parse %q: %v", test.expr, err)
+			continue
+		}
+		if schedule.String() != test.string {
+			t.Errorf("%q reads %q, want %q", test.expr, schedule.String(), test.string)
+		}
+	}
+	if schedule, _ := ParseSchedule("@every 2h", nil); schedule != Every(2*time.Hour) {
+		t.Errorf("@every 2h is %#v, want an interval", schedule)
+	}
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"0 0 * * funday",
+		"@sometimes",
+		"@every 30s",
+		"@every soon",
+	} {
+		if _, err := ParseSchedule(expr, nil); err == nil {
+			t.Errorf("%q was parsed, want an error", expr)
+		}
+	}
+}
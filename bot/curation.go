@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nlopes/slack"
+)
+
+// The buttons on the CL posts of the curation channel
+const (
+	curationCallbackID = "cl_curation"
+
+	curationTweet = "tweet"
+	curationSkip  = "skip"
+	curationEdit  = "edit"
+)
+
+// curationOptions ask Gerrit for what the curation post shows
+var curationOptions = []string{"CURRENT_REVISION", "CURRENT_COMMIT"}
+
+// curationAttachment adds the curation buttons to the attachment of a CL post
+func curationAttachment(msg slack.Attachment, number int) slack.Attachment {
+	value := strconv.Itoa(number)
+
+	msg.CallbackID = curationCallbackID
+	msg.Actions = []slack.AttachmentAction{
+		{
+			Name:  curationTweet,
+			Text:  "Tweet",
+			Type:  "button",
+			Style: "primary",
+			Value: value,
+			Confirm: &slack.ConfirmationField{
+				Text:   fmt.Sprintf("Tweet CL %d?", number),
+				OkText: "Tweet",
+			},
+		},
+		{Name: curationSkip, Text: "Skip", Type: "button", Value: value},
+		{Name: curationEdit, Text: "Edit text", Type: "button", Value: value},
+	}
+	return msg
+}
+
+// errAlreadyTweeted is returned when the CL was, or is being, tweeted
+var errAlreadyTweeted = errors.New("the CL was already tweeted")
+
+// tweetCL tweets the text once for the CL, and returns the CL as stored. The
+// CL is claimed in the store before tweeting, so a concurrent click or command
+// gets errAlreadyTweeted with the CL which may not have its tweet link yet.
+func (b *Bot) tweetCL(ctx context.Context, number int, text string) (*StoredCL, error) {
+	claimed, err := b.store.UpdateCL(ctx, number, func(cl *StoredCL) error {
+		if cl.Tweeted {
+			return errAlreadyTweeted
+		}
+		cl.Tweeted = true
+		return nil
+	})
+	if err == errAlreadyTweeted {
+		cl, err := b.store.GetCL(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		return cl, errAlreadyTweeted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tweet, err := b.twitterAPI.PostTweet(text, nil)
+	if err != nil {
+		// the claim is released so the CL can be tweeted again
+		_, releaseErr := b.store.UpdateCL(ctx, number, func(cl *StoredCL) error {
+			cl.Tweeted = false
+			return nil
+		})
+		if releaseErr != nil {
+			b.logf("could not release the tweet of CL %d: %v\n", number, releaseErr)
+		}
+		return nil, err
+	}
+
+	tweetID, tweetURL := tweet.IdStr, fmt.Sprintf("https://twitter.com/%s/status/%s", tweet.User.ScreenName, tweet.IdStr)
+	cl, err := b.store.UpdateCL(ctx, number, func(cl *StoredCL) error {
+		cl.TweetID, cl.TweetURL = tweetID, tweetURL
+		return nil
+	})
+	if err != nil {
+		// the CL stays claimed, it was tweeted
+		b.logf("could not record the tweet %s of CL %d: %v\n", tweetURL, number, err)
+		claimed.TweetID, claimed.TweetURL = tweetID, tweetURL
+		return claimed, nil
+	}
+	return cl, nil
+}
+
+// tweetedStatus describes the tweet of a CL which was already tweeted
+func tweetedStatus(cl *StoredCL) string {
+	if cl.TweetURL == "" {
+		return "Already being tweeted"
+	}
+	return "Already tweeted: " + cl.TweetURL
+}
+
+// InteractionHandler receives the clicks on the buttons of the bot messages
+func (b *Bot) InteractionHandler(signingSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestSpan := b.tracer.SpanFromRequest(r)
+		defer requestSpan.Finish()
+
+		body, err := VerifySlackRequest(r, signingSecret, time.Now())
+		if err != nil {
+			b.logf("rejected interaction request: %v\n", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		callback := &slack.AttachmentActionCallback{}
+		if err := json.Unmarshal([]byte(form.Get("payload")), callback); err != nil || len(callback.Actions) == 0 {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		requestSpan.SetLabel("callback", callback.CallbackID)
+
+		if callback.CallbackID != curationCallbackID {
+			b.logf("ignoring interaction with unknown callback %q\n", callback.CallbackID)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		b.Go(func() { b.curateCL(callback) })
+
+		// An empty answer keeps the original message until it is updated
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// curateCL handles a click on one of the buttons of a curation post
+func (b *Bot) curateCL(callback *slack.AttachmentActionCallback) {
+	action := callback.Actions[0]
+
+	span := b.tracer.NewSpan("b.curateCL")
+	span.SetLabel("action", action.Name)
+	defer span.Finish()
+
+	user, channel := callback.User.ID, callback.Channel.ID
+
+	// direct messages to the user become ephemeral answers in the channel
+	ctx := NewSpanContext(context.Background(), span)
+	ctx = context.WithValue(ctx, slashResponseKey{}, &slashResponse{
+		url:          callback.ResponseURL,
+		responseType: ResponseEphemeral,
+		user:         user,
+		channel:      channel,
+	})
+	params := slack.PostMessageParameters{AsUser: true}
+
+	if !b.authorize(ctx, RoleCLCurator, "cl "+action.Name, user, channel) {
+		_, _, err := b.chat.DirectMessage(ctx, user, `You are not authorized to curate CLs`, params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	number, err := strconv.Atoi(action.Value)
+	if err != nil {
+		b.logf("invalid CL number in the curation action: %#v\n", action)
+		return
+	}
+
+	cl, err := b.getCL(ctx, number)
+	if err != nil {
+		b.logf("error while retriving CL %d from the DB: %v\n", number, err)
+		_, _, err := b.chat.DirectMessage(ctx, user, fmt.Sprintf(`Could not find CL %d, please try again`, number), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	status, done := "", true
+	switch {
+	case cl.Tweeted:
+		status = tweetedStatus(cl)
+
+	case action.Name == curationTweet:
+		tweeted, err := b.tweetCL(ctx, number, cl.Message+" "+cl.URL)
+		if err == errAlreadyTweeted {
+			status = tweetedStatus(tweeted)
+			break
+		}
+		if err != nil {
+			b.logf("got error while tweeting CL: %d %#v\n", number, err)
+			_, _, err := b.chat.DirectMessage(ctx, user, fmt.Sprintf(`Could not share CL %d, please try again`, number), params)
+			if err != nil {
+				b.logf("%s\n", err)
+			}
+			return
+		}
+		status = fmt.Sprintf("Tweeted by <@%s>: %s", user, tweeted.TweetURL)
+
+	case action.Name == curationSkip:
+		_, err := b.store.UpdateCL(ctx, number, func(cl *StoredCL) error {
+			cl.Skipped = true
+			return nil
+		})
+		if err != nil {
+			b.logf("got error while updating CL to datastore: %v\n", err)
+		}
+		status = fmt.Sprintf("Skipped by <@%s>", user)
+
+	case action.Name == curationEdit:
+		status, done = fmt.Sprintf("<@%s> is editing the text", user), false
+		_, _, err := b.chat.DirectMessage(ctx, user, fmt.Sprintf("Send `tweet cl %d <text>` to tweet the CL with your own text", number), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+
+	default:
+		b.logf("unknown curation action: %#v\n", action)
+		return
+	}
+
+	b.updateCurationPost(ctx, callback, status, done)
+}
+
+// updateCurationPost shows the status of the CL under the post, the buttons
+// are removed once the CL was tweeted or skipped
+func (b *Bot) updateCurationPost(ctx context.Context, callback *slack.AttachmentActionCallback, status string, done bool) {
+	original := callback.OriginalMessage
+	if len(original.Attachments) == 0 {
+		return
+	}
+
+	post := original.Attachments[0]
+	if done {
+		post.Actions = nil
+	}
+	b.editCurationPost(ctx, callback.Channel.ID, callback.MessageTs, original.Text, post, status)
+}
+
+// closeCurationPost removes the buttons of the curation post of a CL tweeted
+// with a command, and shows the status under it. The post is rebuilt from
+// Gerrit as it is not stored.
+func (b *Bot) closeCurationPost(ctx context.Context, cl *StoredCL, status string) {
+	if cl.PostTimestamp == "" {
+		return
+	}
+
+	change, err := b.fetchCL(ctx, cl.Number, curationOptions)
+	if err != nil {
+		b.logf("could not fetch CL %d to update its curation post: %v\n", cl.Number, err)
+		return
+	}
+	text := fmt.Sprintf("[%d] %s: %s", change.Number, change.message(), change.link())
+	b.editCurationPost(ctx, cl.PostChannel, cl.PostTimestamp, text, clAttachment(change), status)
+}
+
+// editCurationPost replaces the curation post with the CL and the status under it
+func (b *Bot) editCurationPost(ctx context.Context, channel, timestamp, text string, post slack.Attachment, status string) {
+	params := slack.PostMessageParameters{AsUser: true}
+	params.Attachments = []slack.Attachment{post, {Text: status, Footer: time.Now().UTC().Format("2006-01-02 15:04 MST")}}
+
+	err := b.chat.UpdateMessage(ctx, channel, timestamp, text, params)
+	if err != nil {
+		b.logf("could not update the curation post: %v\n", err)
+	}
+}
+
+func tweetCLWithText(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	params := slack.PostMessageParameters{AsUser: true}
+
+	// the text starts after any space following the number, new lines included
+	args := strings.TrimSpace(b.arguments(event, "tweet cl"))
+	end := strings.IndexFunc(args, unicode.IsSpace)
+	if end < 0 {
+		end = len(args)
+	}
+	number, err := strconv.Atoi(args[:end])
+	text := strings.TrimSpace(args[end:])
+	if err != nil || text == "" {
+		_, _, err := b.chat.DirectMessage(ctx, event.User, `Usage: "tweet cl <number> <text>"`, params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	cl, err := b.getCL(ctx, number)
+	if err != nil {
+		b.logf("error while retriving CL %d from the DB: %v\n", number, err)
+		_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not find CL %d, it was not merged yet or I haven't seen it`, number), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	if cl.Tweeted {
+		_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`CL %d: %s`, number, tweetedStatus(cl)), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	cl, err = b.tweetCL(ctx, number, text)
+	if err == errAlreadyTweeted {
+		_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`CL %d: %s`, number, tweetedStatus(cl)), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+	if err != nil {
+		b.logf("got error while tweeting CL: %d %#v\n", number, err)
+		_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, number), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	_, _, err = b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Tweeted CL %d: %s`, number, cl.TweetURL), params)
+	if err != nil {
+		b.logf("%s\n", err)
+	}
+
+	b.closeCurationPost(ctx, cl, fmt.Sprintf("Tweeted by <@%s> with their own text: %s", event.User, cl.TweetURL))
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// newCurationBot returns a bot which has posted CL 1000 for curation
+func newCurationBot(t *testing.T) (*Bot, *FakeMessenger, *FakeTweeter) {
+	gerrit := newFakeGerrit(1000)
+	gerrit.Merge("go", "master", "net/http: fix the timeouts", "")
+	b, chat, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	if _, err := b.processCLList(context.Background(), 999, NewNoopTracer().NewSpan("test")); err != nil {
+		t.Fatal(err)
+	}
+	return b, chat, b.twitterAPI.(*FakeTweeter)
+}
+
+func TestTweetCLOnce(t *testing.T) {
+	b, _, tweeter := newCurationBot(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := b.tweetCL(context.Background(), 1000, "net/http: fix the timeouts")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	tweeted := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			tweeted++
+		case errAlreadyTweeted:
+		default:
+			t.Errorf("got the error %v", err)
+		}
+	}
+	if tweeted != 1 || len(tweeter.Tweets()) != 1 {
+		t.Errorf("tweeted %d times with %d tweets, want once", tweeted, len(tweeter.Tweets()))
+	}
+
+	cl, err := b.store.GetCL(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cl.Tweeted || cl.TweetID == "" || cl.TweetURL == "" {
+		t.Errorf("the tweet was not recorded: %+v", cl)
+	}
+}
+
+func TestTweetCLReleasesTheClaim(t *testing.T) {
+	b, _, tweeter := newCurationBot(t)
+
+	tweeter.Err = errors.New("over capacity")
+	if _, err := b.tweetCL(context.Background(), 1000, "net/http: fix the timeouts"); err != tweeter.Err {
+		t.Fatalf("got the error %v, want %v", err, tweeter.Err)
+	}
+
+	tweeter.Err = nil
+	if _, err := b.tweetCL(context.Background(), 1000, "net/http: fix the timeouts"); err != nil {
+		t.Errorf("the CL could not be tweeted after a failure: %v", err)
+	}
+}
+
+// curationStatus returns the status under the curation post, and fails
+// the test if it wasn't updated or still has its buttons
+func curationStatus(t *testing.T, chat *FakeMessenger) string {
+	t.Helper()
+	for _, msg := range chat.Messages() {
+		if msg.Channel != "#golang_cls" {
+			continue
+		}
+		if !msg.Updated || len(msg.Params.Attachments) != 2 {
+			t.Fatalf("the curation post was not updated: %+v", msg)
+		}
+		if len(msg.Params.Attachments[0].Actions) != 0 {
+			t.Error("the curation post still has its buttons")
+		}
+		return msg.Params.Attachments[1].Text
+	}
+	t.Fatal("the CL was not posted for curation")
+	return ""
+}
+
+func TestTweetCLWithTextClosesTheCurationPost(t *testing.T) {
+	b, chat, tweeter := newCurationBot(t)
+
+	ctx := context.Background()
+	tweetCLWithText(ctx, b, &slack.MessageEvent{Msg: slack.Msg{User: "UADMIN", Text: "tweet cl 1000 Faster timeouts in net/http"}})
+
+	if tweets := tweeter.Tweets(); len(tweets) != 1 || tweets[0] != "Faster timeouts in net/http" {
+		t.Fatalf("got the tweets %q", tweets)
+	}
+	if status := curationStatus(t, chat); !strings.Contains(status, "Tweeted by <@UADMIN> with their own text") {
+		t.Errorf("got the status %q", status)
+	}
+}
+
+func TestShareCLClosesTheCurationPost(t *testing.T) {
+	b, chat, tweeter := newCurationBot(t)
+
+	shareCL(context.Background(), b, &slack.MessageEvent{Msg: slack.Msg{User: "UADMIN", Text: "share cl 1000"}})
+
+	if tweets := tweeter.Tweets(); len(tweets) != 1 {
+		t.Fatalf("got the tweets %q", tweets)
+	}
+	if status := curationStatus(t, chat); !strings.HasPrefix(status, "Tweeted by <@UADMIN>: https://twitter.com/") {
+		t.Errorf("got the status %q", status)
+	}
+}
+
+func TestTweetCLWithThanks(t *testing.T) {
+	b, chat, tweeter := newCurationBot(t)
+
+	// the directed reactions of config.json match any message which thanks
+	b.mu.RLock()
+	cfg := *b.config
+	b.mu.RUnlock()
+	cfg.Reactions = append(cfg.Reactions, ReactionConfig{Contains: []string{"thank"}, Reactions: []string{"gopher"}, Directed: true})
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ApplyConfig(context.Background(), &cfg, NewNoopTracer().NewSpan("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	b.HandleMessage(&slack.MessageEvent{Msg: slack.Msg{User: "UADMIN", Channel: "C1", Text: "gopher tweet cl 1000 Thanks to @rsc for the fix"}})
+
+	if tweets := tweeter.Tweets(); len(tweets) != 1 || tweets[0] != "Thanks to @rsc for the fix" {
+		t.Errorf("got the tweets %q", tweets)
+	}
+	if reactions := chat.Reactions(); len(reactions) != 0 {
+		t.Errorf("the message got the reactions %v", reactions)
+	}
+}
+
+func TestTweetCLWithTextArguments(t *testing.T) {
+	tests := []struct {
+		text  string
+		tweet string
+	}{
+		{text: "tweet cl 1000 Faster timeouts", tweet: "Faster timeouts"},
+		{text: "tweet cl 1000  Faster timeouts ", tweet: "Faster timeouts"},
+		{text: "tweet cl 1000\nFaster timeouts\nin net/http", tweet: "Faster timeouts\nin net/http"},
+		{text: "tweet cl 1000\tFaster timeouts", tweet: "Faster timeouts"},
+		{text: "tweet cl 1000"},
+		{text: "tweet cl 1000 \n "},
+		{text: "tweet cl #1000 Faster timeouts"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			b, chat, tweeter := newCurationBot(t)
+			tweetCLWithText(context.Background(), b, &slack.MessageEvent{Msg: slack.Msg{User: "UADMIN", Text: test.text}})
+
+			tweets := tweeter.Tweets()
+			if test.tweet == "" {
+				if len(tweets) != 0 {
+					t.Errorf("got the tweets %q, want none", tweets)
+				}
+				for _, msg := range chat.Messages() {
+					if msg.Direct && strings.HasPrefix(msg.Text, "Usage:") {
+						return
+					}
+				}
+				t.Error("the usage was not sent")
+				return
+			}
+			if len(tweets) != 1 || tweets[0] != test.tweet {
+				t.Errorf("got the tweets %q, want %q", tweets, test.tweet)
+			}
+		})
+	}
+}
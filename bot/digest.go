@@ -0,0 +1,417 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// DigestConfig posts a weekly digest of the merged CLs
+	DigestConfig struct {
+		Enabled bool `json:"enabled"`
+		// Channel gets the digest, golang-cls when empty
+		Channel string `json:"channel"`
+	}
+
+	// clDigest is the report of the CLs merged during a period
+	clDigest struct {
+		since, until time.Time
+		cls          []StoredCL
+		// firstTimers are the first CL of each author who had none before
+		firstTimers []StoredCL
+	}
+
+	digestGroup struct {
+		name string
+		cls  []StoredCL
+	}
+)
+
+// TaskDigest sends the digest of the CLs merged since its previous run to a user
+const TaskDigest = "digest"
+
+const (
+	// digestSchedule is when the weekly digest is posted, in UTC
+	digestSchedule = "0 16 * * fri"
+	digestPeriod   = 7 * 24 * time.Hour
+	// maxDigestPeriod limits how far back a digest looks
+	maxDigestPeriod = 90 * 24 * time.Hour
+
+	otherPackages = "other"
+)
+
+// validate checks the digest against the configured channels
+func (cfg DigestConfig) validate(channels map[string]bool) []string {
+	if cfg.Channel != "" && !channels[strings.ToLower(cfg.Channel)] {
+		return []string{fmt.Sprintf("the digest is posted in the unknown channel %q", cfg.Channel)}
+	}
+	return nil
+}
+
+// digestProject returns the project of the CL, the CLs stored before the
+// project was recorded count as go
+func digestProject(cl StoredCL) string {
+	if cl.Project == "" {
+		return "go"
+	}
+	return cl.Project
+}
+
+func digestSubject(cl StoredCL) string {
+	if cl.Subject == "" {
+		return cl.Message
+	}
+	return cl.Subject
+}
+
+// subjectPackage returns the package a subject starts with, such as net/http
+// for "net/http, net/url: fix parsing", or other when there is none
+func subjectPackage(subject string) string {
+	// cherry-picks, and the CLs stored with their tags, start with brackets
+	for strings.HasPrefix(subject, "[") {
+		end := strings.Index(subject, "]")
+		if end < 0 {
+			break
+		}
+		subject = strings.TrimSpace(subject[end+1:])
+	}
+
+	idx := strings.Index(subject, ":")
+	if idx <= 0 {
+		return otherPackages
+	}
+	pkg := strings.TrimSpace(strings.Split(subject[:idx], ",")[0])
+	if pkg == "" || strings.ContainsAny(pkg, " \"'") {
+		return otherPackages
+	}
+	return pkg
+}
+
+// parseDigestSince reads a date such as 2018-01-22, or a duration such as 3d,
+// 2w or 36h, and returns the start of the digest
+func parseDigestSince(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return now.Add(-digestPeriod), nil
+	}
+
+	since, err := time.ParseInLocation("2006-01-02", text, time.UTC)
+	if err != nil {
+		period, err := parsePeriod(text)
+		if err != nil || period <= 0 {
+			return time.Time{}, fmt.Errorf("invalid period %q, use a date such as 2018-01-22 or a duration such as 3d", text)
+		}
+		since = now.Add(-period)
+	}
+
+	if !since.Before(now) {
+		return time.Time{}, fmt.Errorf("%s is in the future", text)
+	}
+	if now.Sub(since) > maxDigestPeriod {
+		return time.Time{}, fmt.Errorf("a digest can't go back more than %d days", maxDigestPeriod/(24*time.Hour))
+	}
+	return since, nil
+}
+
+// parsePeriod reads a duration which can also be in days or weeks, such as 3d or 2w
+func parsePeriod(text string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(text, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(text, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		return time.ParseDuration(text)
+	}
+
+	count, err := strconv.Atoi(text[:len(text)-1])
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// mergeTime returns when the CL was merged, or crawled for the records which don't say
+func (cl *StoredCL) mergeTime() time.Time {
+	if cl.MergedAt.IsZero() {
+		return cl.CrawledAt
+	}
+	return cl.MergedAt
+}
+
+// buildDigest collects the CLs merged during the period and finds the
+// authors whose first CL it has
+func (b *Bot) buildDigest(ctx context.Context, since, until time.Time) (*clDigest, error) {
+	// the CLs are crawled after they are merged
+	stored, err := b.store.CLsSince(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &clDigest{since: since, until: until}
+	inPeriod := map[int]bool{}
+	for _, cl := range stored {
+		if merged := cl.mergeTime(); !merged.Before(since) && merged.Before(until) {
+			digest.cls = append(digest.cls, cl)
+			inPeriod[cl.Number] = true
+		}
+	}
+	sort.SliceStable(digest.cls, func(i, j int) bool {
+		return digest.cls[i].mergeTime().Before(digest.cls[j].mergeTime())
+	})
+
+	seen := map[string]bool{}
+	for _, cl := range digest.cls {
+		if cl.AuthorEmail == "" || seen[cl.AuthorEmail] {
+			continue
+		}
+		seen[cl.AuthorEmail] = true
+
+		numbers, err := b.store.AuthorCLs(ctx, cl.AuthorEmail)
+		if err != nil {
+			return nil, err
+		}
+		first := true
+		for _, number := range numbers {
+			first = first && inPeriod[number]
+		}
+		if first {
+			digest.firstTimers = append(digest.firstTimers, cl)
+		}
+	}
+
+	return digest, nil
+}
+
+// groups splits the CLs by project, go first, then by package, the busiest first
+func (d *clDigest) groups() []digestGroup {
+	projects := map[string][]StoredCL{}
+	for _, cl := range d.cls {
+		project := digestProject(cl)
+		projects[project] = append(projects[project], cl)
+	}
+
+	groups := []digestGroup{}
+	for name, cls := range projects {
+		groups = append(groups, digestGroup{name: name, cls: cls})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i].name == "go") != (groups[j].name == "go") {
+			return groups[i].name == "go"
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+func (g digestGroup) packages() []digestGroup {
+	packages := map[string][]StoredCL{}
+	for _, cl := range g.cls {
+		pkg := subjectPackage(digestSubject(cl))
+		packages[pkg] = append(packages[pkg], cl)
+	}
+
+	groups := []digestGroup{}
+	for name, cls := range packages {
+		sort.Slice(cls, func(i, j int) bool {
+			return cls[i].Number < cls[j].Number
+		})
+		groups = append(groups, digestGroup{name: name, cls: cls})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i].name == otherPackages) != (groups[j].name == otherPackages) {
+			return groups[j].name == otherPackages
+		}
+		if len(groups[i].cls) != len(groups[j].cls) {
+			return len(groups[i].cls) > len(groups[j].cls)
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// text renders the digest, the tweeted CLs are marked with a bird
+func (d *clDigest) text() string {
+	period := fmt.Sprintf("from %s to %s", d.since.UTC().Format("Mon Jan 2"), d.until.UTC().Format("Mon Jan 2"))
+	if len(d.cls) == 0 {
+		return fmt.Sprintf("No CLs were merged %s", period)
+	}
+
+	authors := map[string]bool{}
+	tweeted := []StoredCL{}
+	for _, cl := range d.cls {
+		author := cl.AuthorEmail
+		if author == "" {
+			author = cl.AuthorName
+		}
+		if author != "" {
+			authors[author] = true
+		}
+		if cl.Tweeted {
+			tweeted = append(tweeted, cl)
+		}
+	}
+	groups := d.groups()
+
+	buff := &bytes.Buffer{}
+	buff.WriteString(fmt.Sprintf("*Merged CLs %s*\n%s", period, plural(len(d.cls), "CL")))
+	if len(authors) > 0 {
+		buff.WriteString(" by " + plural(len(authors), "author"))
+	}
+	buff.WriteString(" in " + plural(len(groups), "project"))
+	if len(tweeted) > 0 {
+		buff.WriteString(fmt.Sprintf(", %d tweeted", len(tweeted)))
+	}
+	buff.WriteString("\n")
+
+	for _, project := range groups {
+		buff.WriteString(fmt.Sprintf("\n*%s*, %s\n", project.name, plural(len(project.cls), "CL")))
+		for _, pkg := range project.packages() {
+			links := []string{}
+			for _, cl := range pkg.cls {
+				link := fmt.Sprintf("<%s|%d>", cl.URL, cl.Number)
+				if cl.Tweeted {
+					link += " :bird:"
+				}
+				links = append(links, link)
+			}
+			buff.WriteString(fmt.Sprintf("• `%s` %d: %s\n", pkg.name, len(pkg.cls), strings.Join(links, ", ")))
+		}
+	}
+
+	if len(tweeted) > 0 {
+		buff.WriteString("\n*Tweeted*\n")
+		for _, cl := range tweeted {
+			buff.WriteString(fmt.Sprintf("• <%s|CL %d> %s", cl.URL, cl.Number, escapeMrkdwn(digestSubject(cl))))
+			if cl.TweetURL != "" {
+				buff.WriteString(fmt.Sprintf(", <%s|tweet>", cl.TweetURL))
+			}
+			buff.WriteString("\n")
+		}
+	}
+
+	if len(d.firstTimers) > 0 {
+		buff.WriteString("\n*First-time contributors* :tada:\n")
+		for _, cl := range d.firstTimers {
+			name := cl.AuthorName
+			if name == "" {
+				name = cl.AuthorEmail
+			}
+			buff.WriteString(fmt.Sprintf("• %s, <%s|CL %d> %s\n", escapeMrkdwn(name), cl.URL, cl.Number, escapeMrkdwn(digestSubject(cl))))
+		}
+	}
+
+	return strings.TrimSpace(buff.String())
+}
+
+// postWeeklyDigest posts the digest of the last week, it is run by the digest job
+func (b *Bot) postWeeklyDigest(ctx context.Context) error {
+	b.mu.RLock()
+	cfg := b.config.Digest
+	b.mu.RUnlock()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	name := cfg.Channel
+	if name == "" {
+		name = defaultCLChannel
+	}
+
+	now := time.Now()
+	digest, err := b.buildDigest(ctx, now.Add(-digestPeriod), now)
+	if err != nil {
+		return fmt.Errorf("could not build the digest: %v", err)
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	channel := strings.TrimPrefix(b.channel(strings.ToLower(name)).slackID, "#")
+	_, _, err = b.chat.PostMessage(ctx, channel, digest.text(), params)
+	return err
+}
+
+// sendDigest sends the CLs merged since the previous run of the task, or
+// during the last week, to the user of the task
+func sendDigest(ctx context.Context, b *Bot, task ScheduledTask) error {
+	now := time.Now()
+	since := task.LastRun
+	if since.IsZero() {
+		since = now.Add(-digestPeriod)
+	}
+	if now.Sub(since) > maxDigestPeriod {
+		since = now.Add(-maxDigestPeriod)
+	}
+
+	digest, err := b.buildDigest(ctx, since, now)
+	if err != nil {
+		return err
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err = b.chat.DirectMessage(ctx, task.Target, digest.text(), params)
+	return err
+}
+
+func clDigestCommand(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	args := b.arguments(event, "cl digest")
+	if strings.HasPrefix(strings.ToLower(args), "every ") {
+		scheduleDigest(ctx, b, event, args[len("every "):])
+		return
+	}
+
+	now := time.Now()
+	since, err := parseDigestSince(args, now)
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\nUsage: \"cl digest [<YYYY-MM-DD | duration such as 3d or 2w>]\"", err))
+		return
+	}
+
+	digest, err := b.buildDigest(ctx, since, now)
+	if err != nil {
+		b.logf("could not build the digest since %s: %v\n", since, err)
+		b.directReply(ctx, event, `Could not build the digest, please try again`)
+		return
+	}
+
+	b.directReply(ctx, event, digest.text())
+}
+
+// scheduleDigest sends the digest to the user periodically
+func scheduleDigest(ctx context.Context, b *Bot, event *slack.MessageEvent, args string) {
+	usage := `Usage: "cl digest every "<cron expression>" [in <time zone>]"`
+
+	task := ScheduledTask{
+		ID:        newTaskID(),
+		Kind:      TaskDigest,
+		Target:    event.User,
+		Text:      "digest of the merged CLs",
+		CreatedBy: event.User,
+		CreatedAt: time.Now(),
+	}
+	rest, err := parseTaskCommand(args, task.CreatedAt, &task)
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\n%s", err, usage))
+		return
+	}
+	if rest != "" || task.Schedule == "" {
+		b.directReply(ctx, event, usage)
+		return
+	}
+
+	b.saveTask(ctx, event, task)
+}
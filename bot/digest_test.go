@@ -0,0 +1,42 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBuildDigestUsesTheMergeTime(t *testing.T) {
+	store := NewMemoryStore()
+	b, _, _ := newTestBot(t, store, "")
+
+	since := time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC)
+	until := since.AddDate(0, 0, 7)
+	ctx := context.Background()
+	for _, cl := range []*StoredCL{
+		// merged the week before, crawled during the week
+		{Number: 1000, MergedAt: since.Add(-time.Minute), CrawledAt: since.Add(time.Minute)},
+		// merged at the end of the week, crawled after it
+		{Number: 1001, MergedAt: until.Add(-time.Minute), CrawledAt: until.Add(time.Minute)},
+		// stored before the merge time was recorded
+		{Number: 1002, CrawledAt: since.Add(time.Hour)},
+		// merged after the week
+		{Number: 1003, MergedAt: until, CrawledAt: until.Add(time.Minute)},
+	} {
+		if err := store.SaveCL(ctx, cl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest, err := b.buildDigest(ctx, since, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers := []int{}
+	for _, cl := range digest.cls {
+		numbers = append(numbers, cl.Number)
+	}
+	if len(numbers) != 2 || numbers[0] != 1002 || numbers[1] != 1001 {
+		t.Errorf("the digest has the CLs %v, want [1002 1001]", numbers)
+	}
+}
@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	// slackRequestMaxAge protects against replayed requests
+	slackRequestMaxAge = 5 * time.Minute
+	// slackRequestMaxSize is more than enough for any event Slack sends
+	slackRequestMaxSize = 1 << 20
+	// seenEventsSize is how many event IDs are remembered to ignore retries
+	seenEventsSize = 1000
+)
+
+type (
+	// ReactionHandler is called when a reaction is added to a message
+	ReactionHandler func(ctx context.Context, b *Bot, event *slack.ReactionAddedEvent)
+
+	slackEventEnvelope struct {
+		Type      string          `json:"type"`
+		Challenge string          `json:"challenge"`
+		EventID   string          `json:"event_id"`
+		Event     json.RawMessage `json:"event"`
+	}
+
+	slackEventType struct {
+		Type string `json:"type"`
+	}
+
+	// seenEvents remembers the most recent event IDs, Slack sends an event
+	// again when it thinks the first delivery failed
+	seenEvents struct {
+		mu    sync.Mutex
+		ids   map[string]bool
+		order []string
+	}
+)
+
+// Errors returned when verifying a request from Slack
+var (
+	ErrSlackRequestSignature = errors.New("invalid slack request signature")
+	ErrSlackRequestExpired   = errors.New("slack request timestamp is too old")
+	ErrSlackRequestTooLarge  = errors.New("slack request body is too large")
+)
+
+// SignSlackRequest computes the signature Slack sends in the X-Slack-Signature header
+func SignSlackRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySlackRequest checks the signature of a request sent by Slack and returns its body
+func VerifySlackRequest(r *http.Request, secret string, now time.Time) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, slackRequestMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > slackRequestMaxSize {
+		return nil, ErrSlackRequestTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrSlackRequestSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return nil, ErrSlackRequestExpired
+	}
+
+	expected := SignSlackRequest(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, ErrSlackRequestSignature
+	}
+
+	return body, nil
+}
+
+// seen records the event ID and says if it was already recorded
+func (s *seenEvents) seen(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids[id] {
+		return true
+	}
+	if s.ids == nil {
+		s.ids = map[string]bool{}
+	}
+
+	s.ids[id] = true
+	s.order = append(s.order, id)
+	if len(s.order) > seenEventsSize {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	return false
+}
+
+// OnReaction registers a handler for a reaction, use "" to be called for every reaction
+func (b *Bot) OnReaction(reaction string, handler ReactionHandler) {
+	b.reactionsMu.Lock()
+	defer b.reactionsMu.Unlock()
+
+	if b.reactionHandlers == nil {
+		b.reactionHandlers = map[string][]ReactionHandler{}
+	}
+	b.reactionHandlers[reaction] = append(b.reactionHandlers[reaction], handler)
+}
+
+// ReactionAdded is called when someone adds a reaction to a message
+func (b *Bot) ReactionAdded(event *slack.ReactionAddedEvent) {
+	if b.devMode {
+		b.logf("got reaction %s from %s\n", event.Reaction, event.User)
+		return
+	}
+
+	if event.User == b.id {
+		return
+	}
+
+	b.reactionsMu.RLock()
+	handlers := append([]ReactionHandler{}, b.reactionHandlers[""]...)
+	handlers = append(handlers, b.reactionHandlers[event.Reaction]...)
+	b.reactionsMu.RUnlock()
+
+	if len(handlers) == 0 {
+		return
+	}
+
+	span := b.tracer.NewSpan("b.ReactionAdded")
+	span.SetLabel("reaction", event.Reaction)
+	defer span.Finish()
+
+	ctx := NewSpanContext(context.Background(), span)
+	for _, handler := range handlers {
+		handler(ctx, b, event)
+	}
+}
+
+// EventsHandler receives the Slack Events API callbacks, it replaces the RTM
+// connection when the bot runs behind a load balancer
+func (b *Bot) EventsHandler(signingSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span := b.tracer.SpanFromRequest(r)
+		defer span.Finish()
+
+		body, err := VerifySlackRequest(r, signingSecret, time.Now())
+		if err != nil {
+			b.logf("rejected events request: %v\n", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		envelope := slackEventEnvelope{}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		span.SetLabel("type", envelope.Type)
+
+		switch envelope.Type {
+		case "url_verification":
+			w.Header().Add("Content-Type", "text/plain")
+			fmt.Fprint(w, envelope.Challenge)
+			return
+
+		case "event_callback":
+			if b.seenEvents.seen(envelope.EventID) {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if err := b.dispatchEvent(envelope.Event); err != nil {
+				b.logf("could not handle event %s: %v\n", envelope.EventID, err)
+				http.Error(w, "invalid event", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Slack expects an answer within 3 seconds, the handlers run in the background
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// dispatchEvent decodes the inner event and calls the same handler as the RTM connection
+func (b *Bot) dispatchEvent(raw json.RawMessage) error {
+	kind := slackEventType{}
+	if err := json.Unmarshal(raw, &kind); err != nil {
+		return err
+	}
+
+	switch kind.Type {
+	case "message":
+		event := &slack.MessageEvent{}
+		if err := json.Unmarshal(raw, event); err != nil {
+			return err
+		}
+		b.Go(func() { b.HandleMessage(event) })
+
+	case "team_join":
+		event := &slack.TeamJoinEvent{}
+		if err := json.Unmarshal(raw, event); err != nil {
+			return err
+		}
+		b.Go(func() { b.TeamJoined(event) })
+
+	case "reaction_added":
+		event := &slack.ReactionAddedEvent{}
+		if err := json.Unmarshal(raw, event); err != nil {
+			return err
+		}
+		b.Go(func() { b.ReactionAdded(event) })
+
+	default:
+		b.logf("ignoring event of type %q\n", kind.Type)
+	}
+
+	return nil
+}
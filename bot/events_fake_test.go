@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// fakeSlackEvents posts signed Events API payloads, slash commands and
+// interactions, the same way Slack does, to the bot HTTP handlers
+type fakeSlackEvents struct {
+	// URL of the endpoint
+	URL string
+	// SigningSecret used to sign the requests
+	SigningSecret string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+	// Now returns the time used for the request timestamp, time.Now when nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	counter int
+}
+
+// Post signs and sends a raw payload
+func (f *fakeSlackEvents) Post(payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return f.send("application/json", body)
+}
+
+// SlashCommand signs and sends a slash command
+func (f *fakeSlackEvents) SlashCommand(command, text, userID, channelID, responseURL string) (*http.Response, error) {
+	form := url.Values{}
+	form.Set("command", command)
+	form.Set("text", text)
+	form.Set("user_id", userID)
+	form.Set("channel_id", channelID)
+	form.Set("response_url", responseURL)
+	return f.send("application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+// Interaction signs and sends a click on a button of an interactive message
+func (f *fakeSlackEvents) Interaction(callback slack.AttachmentActionCallback) (*http.Response, error) {
+	payload, err := json.Marshal(callback)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("payload", string(payload))
+	return f.send("application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+func (f *fakeSlackEvents) send(contentType string, body []byte) (*http.Response, error) {
+	now := time.Now()
+	if f.Now != nil {
+		now = f.Now()
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest("POST", f.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", SignSlackRequest(f.SigningSecret, timestamp, body))
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// URLVerification sends the challenge Slack uses when the events URL is configured
+func (f *fakeSlackEvents) URLVerification(challenge string) (*http.Response, error) {
+	return f.Post(map[string]string{
+		"type":      "url_verification",
+		"challenge": challenge,
+	})
+}
+
+// Event wraps an event in an event_callback and sends it
+func (f *fakeSlackEvents) Event(event interface{}) (*http.Response, error) {
+	f.mu.Lock()
+	f.counter++
+	eventID := fmt.Sprintf("Ev%06d", f.counter)
+	f.mu.Unlock()
+
+	return f.Post(map[string]interface{}{
+		"type":     "event_callback",
+		"event_id": eventID,
+		"event":    event,
+	})
+}
+
+// Message sends a message event
+func (f *fakeSlackEvents) Message(msg slack.Msg) (*http.Response, error) {
+	msg.Type = "message"
+	return f.Event(msg)
+}
+
+// TeamJoin sends a team_join event
+func (f *fakeSlackEvents) TeamJoin(user slack.User) (*http.Response, error) {
+	return f.Event(slack.TeamJoinEvent{Type: "team_join", User: user})
+}
+
+// ReactionAdded sends a reaction_added event for a message
+func (f *fakeSlackEvents) ReactionAdded(user, reaction, channel, timestamp string) (*http.Response, error) {
+	event := slack.ReactionAddedEvent{
+		Type:     "reaction_added",
+		User:     user,
+		Reaction: reaction,
+	}
+	event.Item.Type = "message"
+	event.Item.Channel = channel
+	event.Item.Timestamp = timestamp
+
+	return f.Event(event)
+}
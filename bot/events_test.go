@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// newEventsServer serves the events endpoint of a test bot, and counts the reactions it handles
+func newEventsServer(t *testing.T) (string, func() int) {
+	b, _, _ := newTestBot(t, NewMemoryStore(), "")
+
+	mu, reactions := sync.Mutex{}, 0
+	b.OnReaction("", func(ctx context.Context, b *Bot, event *slack.ReactionAddedEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		reactions++
+	})
+
+	srv := httptest.NewServer(b.EventsHandler(testSigningSecret))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, func() int {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return reactions
+	}
+}
+
+func reactionEvent(eventID string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "event_callback",
+		"event_id": eventID,
+		"event": map[string]interface{}{
+			"type":     "reaction_added",
+			"user":     "U1",
+			"reaction": "gopher",
+			"item":     map[string]string{"type": "message", "channel": "C1", "ts": "1500000000.000001"},
+		},
+	}
+}
+
+func TestEventsHandlerVerifiesRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		age     time.Duration
+		payload interface{}
+		status  int
+		handled int
+	}{
+		{name: "valid signature", secret: testSigningSecret, payload: reactionEvent("Ev1"), status: http.StatusOK, handled: 1},
+		{name: "wrong signature", secret: "not the secret", payload: reactionEvent("Ev1"), status: http.StatusUnauthorized},
+		{name: "expired timestamp", secret: testSigningSecret, age: slackRequestMaxAge + time.Minute, payload: reactionEvent("Ev1"), status: http.StatusUnauthorized},
+		{name: "recent timestamp", secret: testSigningSecret, age: slackRequestMaxAge - time.Minute, payload: reactionEvent("Ev1"), status: http.StatusOK, handled: 1},
+		{
+			name:    "body over 1 MB",
+			secret:  testSigningSecret,
+			payload: map[string]interface{}{"type": "event_callback", "event_id": "Ev1", "padding": strings.Repeat("x", slackRequestMaxSize)},
+			status:  http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link, reactions := newEventsServer(t)
+			events := &fakeSlackEvents{
+				URL:           link,
+				SigningSecret: test.secret,
+				Now:           func() time.Time { return time.Now().Add(-test.age) },
+			}
+
+			resp, err := events.Post(test.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Errorf("got the status %d, want %d", resp.StatusCode, test.status)
+			}
+			if got := reactions(); got != test.handled {
+				t.Errorf("handled %d events, want %d", got, test.handled)
+			}
+		})
+	}
+}
+
+func TestVerifySlackRequestErrors(t *testing.T) {
+	now := time.Now()
+	sign := func(body string, at time.Time) *http.Request {
+		r := httptest.NewRequest("POST", "/slack/events", strings.NewReader(body))
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		r.Header.Set("X-Slack-Signature", SignSlackRequest(testSigningSecret, timestamp, []byte(body)))
+		return r
+	}
+
+	if _, err := VerifySlackRequest(sign(`{}`, now), testSigningSecret, now); err != nil {
+		t.Errorf("a signed request was rejected: %v", err)
+	}
+	if _, err := VerifySlackRequest(sign(`{}`, now), "another secret", now); err != ErrSlackRequestSignature {
+		t.Errorf("got %v for a wrong signature, want %v", err, ErrSlackRequestSignature)
+	}
+	if _, err := VerifySlackRequest(sign(`{}`, now.Add(-6*time.Minute)), testSigningSecret, now); err != ErrSlackRequestExpired {
+		t.Errorf("got %v for an old request, want %v", err, ErrSlackRequestExpired)
+	}
+	if _, err := VerifySlackRequest(sign(strings.Repeat("x", slackRequestMaxSize+1), now), testSigningSecret, now); err != ErrSlackRequestTooLarge {
+		t.Errorf("got %v for a large request, want %v", err, ErrSlackRequestTooLarge)
+	}
+}
+
+func TestEventsHandlerEchoesTheChallenge(t *testing.T) {
+	link, _ := newEventsServer(t)
+	events := &fakeSlackEvents{URL: link, SigningSecret: testSigningSecret}
+
+	resp, err := events.URLVerification("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("got %d %q, want the challenge", resp.StatusCode, body)
+	}
+}
+
+func TestEventsHandlerDispatchesRetriesOnce(t *testing.T) {
+	link, reactions := newEventsServer(t)
+	events := &fakeSlackEvents{URL: link, SigningSecret: testSigningSecret}
+
+	for _, eventID := range []string{"Ev1", "Ev1", "Ev2", "Ev1"} {
+		resp, err := events.Post(reactionEvent(eventID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("got the status %d for %s, want 200", resp.StatusCode, eventID)
+		}
+	}
+
+	if got := reactions(); got != 2 {
+		t.Errorf("handled %d events, want 2", got)
+	}
+}
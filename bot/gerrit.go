@@ -3,162 +3,315 @@ package bot
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
-	"cloud.google.com/go/trace"
 	"github.com/nlopes/slack"
-	"google.golang.org/api/iterator"
 )
 
 type (
+	gerritAccount struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+
+	gerritRevision struct {
+		// Number is the patch set number
+		Number   int           `json:"_number"`
+		Uploader gerritAccount `json:"uploader"`
+		Commit   struct {
+			Subject string        `json:"subject"`
+			Message string        `json:"message"`
+			Author  gerritAccount `json:"author"`
+		} `json:"commit"`
+		// Files are only listed when the CURRENT_FILES option is requested
+		Files map[string]gerritFile `json:"files,omitempty"`
+	}
+
+	gerritFile struct {
+		LinesInserted int `json:"lines_inserted,omitempty"`
+		LinesDeleted  int `json:"lines_deleted,omitempty"`
+	}
+
+	gerritApproval struct {
+		gerritAccount
+		Value int `json:"value"`
+	}
+
+	// gerritLabel has the account which gave the highest, or lowest, vote,
+	// and every vote when the DETAILED_LABELS option is requested
+	gerritLabel struct {
+		Approved *gerritAccount   `json:"approved,omitempty"`
+		Rejected *gerritAccount   `json:"rejected,omitempty"`
+		All      []gerritApproval `json:"all,omitempty"`
+	}
+
 	gerritCL struct {
-		Project         string `json:"project"`
-		ChangeID        string `json:"change_id"`
-		Number          int    `json:"_number"`
-		Subject         string `json:"subject"`
-		Branch          string `json:"branch"`
-		CurrentRevision string `json:"current_revision"`
-		Revisions       map[string]struct {
-			Commit struct {
-				Subject string `json:"subject"`
-				Message string `json:"message"`
-			} `json:"commit"`
-		} `json:"revisions"`
-	}
-
-	storedCL struct {
-		Tweeted   bool      `datastore:"Tweeted,noindex"`
-		URL       string    `datastore:"URL,noindex"`
-		Message   string    `datastore:"Message,noindex"`
-		CrawledAt time.Time `datastore:"CrawledAt"`
+		Project         string                    `json:"project"`
+		ChangeID        string                    `json:"change_id"`
+		Number          int                       `json:"_number"`
+		Subject         string                    `json:"subject"`
+		Branch          string                    `json:"branch"`
+		Status          string                    `json:"status"`
+		Owner           gerritAccount             `json:"owner"`
+		Created         string                    `json:"created"`
+		Updated         string                    `json:"updated"`
+		Submitted       string                    `json:"submitted,omitempty"`
+		Insertions      int                       `json:"insertions"`
+		Deletions       int                       `json:"deletions"`
+		CurrentRevision string                    `json:"current_revision"`
+		Revisions       map[string]gerritRevision `json:"revisions"`
+		// Labels are only listed when the LABELS option is requested
+		Labels map[string]gerritLabel `json:"labels,omitempty"`
+		// Reviewers are only listed, by state such as REVIEWER or CC, when the DETAILED_LABELS option is requested
+		Reviewers map[string][]gerritAccount `json:"reviewers,omitempty"`
+		// MoreChanges is set on the last change of a page when there are more
+		MoreChanges bool `json:"_more_changes,omitempty"`
 	}
 )
 
+var errGerritNotFound = errors.New("not found in gerrit")
+
+// gerritMaxPages limits how many pages gerritPages fetches, and so how far
+// back the pollers look
+const gerritMaxPages = 10
+
+// Statuses of the Gerrit changes
+const (
+	gerritNew       = "NEW"
+	gerritMerged    = "MERGED"
+	gerritAbandoned = "ABANDONED"
+)
+
+// gerritTimeLayout is the format of the Gerrit timestamps, which are in UTC
+const gerritTimeLayout = "2006-01-02 15:04:05.000000000"
+
+// gerritQueryTimeLayout is the format of the times in the queries, such as mergedafter
+const gerritQueryTimeLayout = "2006-01-02 15:04:05"
+
+func parseGerritTime(value string) (time.Time, error) {
+	return time.ParseInLocation(gerritTimeLayout, value, time.UTC)
+}
+
 func (cl *gerritCL) link() string {
 	return fmt.Sprintf("https://golang.org/cl/%d/", cl.Number)
 }
 
 func (cl *gerritCL) message() string {
-	subject := cl.Subject
+	tags := []string{}
 	if cl.Project != "go" {
-		subject = fmt.Sprintf("[%s] %s", cl.Project, subject)
+		tags = append(tags, cl.Project)
+	}
+	// cherry-picks already start with the release branch
+	if cl.Branch != "" && cl.Branch != "master" && !strings.HasPrefix(cl.Subject, "["+cl.Branch+"]") {
+		tags = append(tags, cl.Branch)
 	}
 
-	return subject
+	if len(tags) == 0 {
+		return cl.Subject
+	}
+	return fmt.Sprintf("[%s] %s", strings.Join(tags, " "), cl.Subject)
 }
 
-func (b *Bot) getCLFromDS(ctx context.Context, query *datastore.Query) (*datastore.Key, *storedCL, error) {
-	iter := b.dsClient.Run(ctx, query)
-
-	dst := &storedCL{}
-	key, err := iter.Next(dst)
-	if err != nil && err != iterator.Done {
-		b.logf("error while fetching history: %v\n", err)
-		return nil, nil, err
+// files returns the paths touched by the current revision, sorted
+func (cl *gerritCL) files() []string {
+	files := []string{}
+	for file := range cl.Revisions[cl.CurrentRevision].Files {
+		// skip the magic files, such as /COMMIT_MSG
+		if strings.HasPrefix(file, "/") {
+			continue
+		}
+		files = append(files, file)
 	}
-
-	return key, dst, nil
+	sort.Strings(files)
+	return files
 }
 
+// GetLastSeenCL returns the number of the last CL the bot has processed
 func (b *Bot) GetLastSeenCL(ctx context.Context) (int, error) {
-	latestCLQuery := datastore.NewQuery("GoCL").
-		Order("-CrawledAt").
-		Limit(1).
-		KeysOnly()
-
-	key, _, err := b.getCLFromDS(ctx, latestCLQuery)
-	if err != nil {
-		return -1, err
-	}
-	if key == nil {
-		return -1, nil
-	}
-	return int(key.ID), nil
+	return b.store.LastSeenCL(ctx)
 }
 
 func (b *Bot) wasShown(ctx context.Context, cl gerritCL) (bool, error) {
-	key := datastore.IDKey("GoCL", int64(cl.Number), nil)
-	query := datastore.NewQuery("GoCL").Ancestor(key)
-	key, _, err := b.getCLFromDS(ctx, query)
-	return key != nil, err
+	_, err := b.store.GetCL(ctx, cl.Number)
+	if err == ErrCLNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-func (b *Bot) saveCL(ctx context.Context, cl gerritCL) error {
-	taskKey := datastore.IDKey("GoCL", int64(cl.Number), nil)
-	gocl := &storedCL{
-		URL:       cl.link(),
-		Message:   cl.message(),
-		CrawledAt: time.Now(),
+// newStoredCL returns what is remembered about the CL, crawled at the given time
+func newStoredCL(cl gerritCL, crawledAt time.Time) *StoredCL {
+	commit := cl.Revisions[cl.CurrentRevision].Commit
+	return &StoredCL{
+		Number:      cl.Number,
+		URL:         cl.link(),
+		Message:     cl.message(),
+		CrawledAt:   crawledAt,
+		Project:     cl.Project,
+		Branch:      cl.Branch,
+		Subject:     cl.Subject,
+		AuthorName:  commit.Author.Name,
+		AuthorEmail: strings.ToLower(commit.Author.Email),
+		// indexed strings are limited to 1500 bytes
+		RelNote:       truncateText(parseCommitMessage(commit.Message).relNote(), 1000),
+		SchemaVersion: clSchemaVersion,
+		ChangeID:      cl.ChangeID,
+		OwnerName:     cl.Owner.Name,
+		OwnerEmail:    strings.ToLower(cl.Owner.Email),
+		MergedAt:      cl.mergedAt(),
 	}
-	_, err := b.dsClient.Put(ctx, taskKey, gocl)
-	return err
 }
 
-func (b *Bot) updateCL(ctx context.Context, key *datastore.Key, cl *storedCL) error {
-	taskKey := datastore.IDKey("GoCL", key.ID, nil)
-	_, err := b.dsClient.Put(ctx, taskKey, cl)
-	return err
+// gerritURL returns a link to the Gerrit API on the host of the Gerrit link
+func (b *Bot) gerritURL(path string, query url.Values) (string, error) {
+	link, err := url.Parse(b.gerritLink)
+	if err != nil {
+		return "", err
+	}
+	link.Path = path
+	link.RawQuery = query.Encode()
+	return link.String(), nil
 }
 
-func (b *Bot) processCLList(ctx context.Context, lastID int, span *trace.Span) int {
-	req, err := http.NewRequest("GET", b.gerritLink, nil)
+// gerritGet decodes the answer of the Gerrit API to a request for the link
+func (b *Bot) gerritGet(ctx context.Context, link string, v interface{}) error {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return err
+	}
 	req.Header.Add("User-Agent", "Gophers Slack bot")
 	req = req.WithContext(ctx)
 
 	resp, err := b.client.Do(req)
 	if err != nil {
-		b.logf("%s\n", err)
-		return lastID
+		return err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return errGerritNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		b.logf("got non-200 code: %d from gerrit api", resp.StatusCode)
-		return lastID
+		return fmt.Errorf("got non-200 code: %d from gerrit api", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		b.logf("%s\n", err)
-		return lastID
+		return err
 	}
 
 	if len(body) < 4 {
-		b.logf("got body: %s\n", string(body))
-		return lastID
+		return fmt.Errorf("got body: %s", string(body))
 	}
 
 	// Fix Gerrit adding a random prefix )]}'
 	body = body[4:]
+	return json.Unmarshal(body, v)
+}
+
+// gerritPages fetches the changes matching the query, page after page, until
+// Gerrit has no more or stop returned true for a change of the page. stop sees
+// every change once, as those updated while paginating push the others to the
+// next page. It says if gerritMaxPages were fetched before the end.
+func (b *Bot) gerritPages(ctx context.Context, query url.Values, stop func(cl gerritCL) bool) ([]gerritCL, bool, error) {
 	cls := []gerritCL{}
-	err = json.Unmarshal(body, &cls)
+	seen := map[int]bool{}
+	// the pages start after the rows received, duplicates included
+	received := 0
+
+	for page := 0; page < gerritMaxPages; page++ {
+		if received > 0 {
+			query.Set("S", strconv.Itoa(received))
+		}
+		link, err := b.gerritURL("/changes/", query)
+		if err != nil {
+			return nil, false, err
+		}
+
+		pageCLs := []gerritCL{}
+		if err := b.gerritGet(ctx, link, &pageCLs); err != nil {
+			return nil, false, err
+		}
+
+		received += len(pageCLs)
+		more, stopped := false, false
+		for _, cl := range pageCLs {
+			more = cl.MoreChanges
+			if seen[cl.Number] {
+				continue
+			}
+			seen[cl.Number] = true
+			cls = append(cls, cl)
+			stopped = stop(cl) || stopped
+		}
+
+		if stopped || !more || len(pageCLs) == 0 {
+			return cls, false, nil
+		}
+	}
+
+	return cls, true, nil
+}
+
+// fetchCLsSince fetches the merged CLs, newest first, page after page until
+// the last seen CL is found. It says if it was found, when it wasn't some CLs
+// may be missing.
+func (b *Bot) fetchCLsSince(ctx context.Context, lastID int) ([]gerritCL, bool, error) {
+	link, err := url.Parse(b.gerritLink)
 	if err != nil {
-		b.logf("%s\n", err)
-		return lastID
+		return nil, false, err
+	}
+
+	found := false
+	cls, _, err := b.gerritPages(ctx, link.Query(), func(cl gerritCL) bool {
+		found = found || cl.Number == lastID
+		// without history there is nothing to catch up with
+		return found || lastID == -1
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return cls, found, nil
+}
+
+func (b *Bot) processCLList(ctx context.Context, lastID int, span Span) (int, error) {
+	cls, found, err := b.fetchCLsSince(ctx, lastID)
+	if err != nil {
+		return lastID, err
+	}
+	if len(cls) == 0 {
+		return lastID, nil
 	}
 
 	foundIdx := len(cls) - 1
-	for idx := len(cls) - 1; idx >= 0; idx-- {
-		if cls[idx].Number == lastID {
-			foundIdx = idx
-			break
+	if found {
+		for idx := len(cls) - 1; idx >= 0; idx-- {
+			if cls[idx].Number == lastID {
+				foundIdx = idx
+				break
+			}
 		}
+	} else if lastID != -1 {
+		// the stored CLs are skipped, the gap is between the oldest CL fetched and the last seen one
+		b.logf("gap in the Gerrit history: CL %d was not found in the %d most recently merged CLs, the CLs merged before %d may be missing\n", lastID, len(cls), cls[len(cls)-1].Number)
+		span.SetLabel("gap", strconv.Itoa(lastID))
+		foundIdx = len(cls)
 	}
 
-	pubChannel := b.channels["golang-cls"].slackID
-	if pubChannel[:1] == "#" {
-		pubChannel = pubChannel[1:]
+	subscriptions, err := b.store.Subscriptions(ctx)
+	if err != nil {
+		b.logf("could not load the subscriptions, nobody is notified: %v\n", err)
 	}
 
-	pvtChannel := b.channels["golang_cls"].slackID
-
 	for idx := foundIdx - 1; idx >= 0; idx-- {
 		cl := cls[idx]
 
@@ -171,37 +324,74 @@ func (b *Bot) processCLList(ctx context.Context, lastID int, span *trace.Span) i
 			continue
 		}
 
-		msg := slack.Attachment{
-			Title:     cl.Subject,
-			TitleLink: cl.link(),
-			Text:      cl.Revisions[cl.CurrentRevision].Commit.Message,
-			Footer:    cl.ChangeID,
-		}
-		params := slack.PostMessageParameters{AsUser: true}
-		params.Attachments = append(params.Attachments, msg)
-
-		err = b.saveCL(ctx, cl)
+		posted, err := b.postMergedCL(ctx, cl, newStoredCL(cl, time.Now()), subscriptions)
 		if err != nil {
-			b.logf("got error while saving CL to datastore: %v", err)
-			return lastID
+			return lastID, err
 		}
-
-		_, _, err = b.slackBotAPI.PostMessageContext(ctx, pvtChannel, fmt.Sprintf("[%d] %s: %s", cl.Number, cl.message(), cl.link()), params)
-		if err != nil {
-			b.logf("%s\n", err)
-			continue
+		if posted {
+			lastID = cl.Number
 		}
+	}
+
+	return lastID, nil
+}
 
-		lastID = cl.Number
+// clAttachment shows the commit message of the CL
+func clAttachment(cl gerritCL) slack.Attachment {
+	commit := parseCommitMessage(cl.Revisions[cl.CurrentRevision].Commit.Message)
+	return slack.Attachment{
+		Title:      cl.Subject,
+		TitleLink:  cl.link(),
+		Text:       commit.mrkdwn(maxCommitMessageChars),
+		Fields:     commit.fields(),
+		Footer:     cl.ChangeID,
+		MarkdownIn: []string{"text"},
+	}
+}
 
-		_, _, err = b.slackBotAPI.PostMessageContext(ctx, pubChannel, fmt.Sprintf("[%d] %s: %s", cl.Number, cl.message(), cl.link()), params)
+// postMergedCL saves the CL, posts it for curation and in its channels, and
+// notifies the subscribers. It says false when the curation post failed, the
+// CL is then saved but not posted.
+func (b *Bot) postMergedCL(ctx context.Context, cl gerritCL, stored *StoredCL, subscriptions []Subscription) (bool, error) {
+	msg := clAttachment(cl)
+	params := slack.PostMessageParameters{AsUser: true}
+	params.Attachments = append(params.Attachments, msg)
+
+	if err := b.store.SaveCL(ctx, stored); err != nil {
+		return false, fmt.Errorf("got error while saving CL to datastore: %v", err)
+	}
+
+	var err error
+	pvtChannel := b.channel("golang_cls").slackID
+	curationParams := slack.PostMessageParameters{AsUser: true}
+	curationParams.Attachments = []slack.Attachment{curationAttachment(msg, cl.Number)}
+	stored.PostChannel, stored.PostTimestamp, err = b.chat.PostMessage(ctx, pvtChannel, fmt.Sprintf("[%d] %s: %s", cl.Number, cl.message(), cl.link()), curationParams)
+	if err != nil {
+		b.logf("%s\n", err)
+		return false, nil
+	}
+
+	for _, name := range b.routeCL(cl) {
+		channel := strings.TrimPrefix(b.channel(name).slackID, "#")
+		postChannel, timestamp, err := b.chat.PostMessage(ctx, channel, fmt.Sprintf("[%d] %s: %s", cl.Number, cl.message(), cl.link()), params)
 		if err != nil {
 			b.logf("%s\n", err)
 			continue
 		}
+		stored.Posts = append(stored.Posts, CLPost{Channel: postChannel, Timestamp: timestamp})
 	}
 
-	return lastID
+	// the CL may have been tweeted from the curation post in the meantime
+	_, err = b.store.UpdateCL(ctx, cl.Number, func(saved *StoredCL) error {
+		saved.PostChannel, saved.PostTimestamp, saved.Posts = stored.PostChannel, stored.PostTimestamp, stored.Posts
+		return nil
+	})
+	if err != nil {
+		b.logf("got error while saving the posts of CL %d: %v\n", cl.Number, err)
+	}
+
+	b.notifySubscribers(ctx, cl, subscriptions)
+	return true, nil
 }
 
 func shareCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
@@ -209,17 +399,6 @@ func shareCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 	eventText := strings.Trim(strings.ToLower(event.Text), " \n\r")
 	eventText = b.trimBot(eventText)
 
-	if !b.specialRestrictions("golang_cls", event) {
-		b.logf("share attempt caught: %#v\n", event)
-
-		params := slack.PostMessageParameters{AsUser: true}
-		_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, `You are not authorized to share CLs`, params)
-		if err != nil {
-			b.logf("%s\n", err)
-		}
-		return
-	}
-
 	eventText = strings.Replace(eventText, "share cl", "", -1)
 	eventText = strings.Trim(eventText, " \n")
 
@@ -229,21 +408,27 @@ func shareCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 			b.logf("could not convert string to int: %v from event: %#v\n", err, event)
 
 			params := slack.PostMessageParameters{AsUser: true}
-			_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
 			if err != nil {
 				b.logf("%s\n", err)
 			}
 			continue
 		}
 
-		key := datastore.IDKey("GoCL", clNumber, nil)
-		query := datastore.NewQuery("GoCL").Ancestor(key)
-		key, cl, err := b.getCLFromDS(ctx, query)
+		cl, err := b.getCL(ctx, int(clNumber))
+		if err == ErrCLNotFound {
+			params := slack.PostMessageParameters{AsUser: true}
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not find CL %d, it was not merged yet or I haven't seen it`, clNumber), params)
+			if err != nil {
+				b.logf("%s\n", err)
+			}
+			continue
+		}
 		if err != nil {
 			b.logf("error while retriving CL from the DB: %v\n", err)
 
 			params := slack.PostMessageParameters{AsUser: true}
-			_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
 			if err != nil {
 				b.logf("%s\n", err)
 			}
@@ -252,60 +437,47 @@ func shareCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
 
 		if cl.Tweeted {
 			params := slack.PostMessageParameters{AsUser: true}
-			_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf(`Already tweeted CL %d`, clNumber), params)
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Already tweeted CL %d`, clNumber), params)
 			if err != nil {
 				b.logf("%s\n", err)
 			}
 			continue
 		}
 
-		message := cl.Message + " " + cl.URL
-		_, err = b.twitterAPI.PostTweet(message, nil)
-		if err != nil {
-			b.logf("got error while tweeting CL: %d %#v\n", clNumber, err)
-
+		tweeted, err := b.tweetCL(ctx, int(clNumber), cl.Message+" "+cl.URL)
+		if err == errAlreadyTweeted {
 			params := slack.PostMessageParameters{AsUser: true}
-			_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Already tweeted CL %d`, clNumber), params)
 			if err != nil {
 				b.logf("%s\n", err)
 			}
 			continue
 		}
-
-		cl.Tweeted = true
-		err = b.updateCL(ctx, key, cl)
 		if err != nil {
-			b.logf("got error while updating CL to datastore: %v", err)
+			b.logf("got error while tweeting CL: %d %#v\n", clNumber, err)
 
 			params := slack.PostMessageParameters{AsUser: true}
-			_, _, err := b.slackBotAPI.PostMessageContext(ctx, event.User, fmt.Sprintf(`Could not update tweet status for CL %d in the DB`, clNumber), params)
+			_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`Could not share CL %d, please try again`, clNumber), params)
 			if err != nil {
 				b.logf("%s\n", err)
 			}
+			continue
 		}
+
+		b.closeCurationPost(ctx, tweeted, fmt.Sprintf("Tweeted by <@%s>: %s", event.User, tweeted.TweetURL))
 	}
 }
 
-// MonitorGerrit handles the Gerrit changes
-func (b *Bot) MonitorGerrit(duration time.Duration) {
-	tk := time.NewTicker(duration)
-	defer tk.Stop()
-
-	span := b.traceClient.NewSpan("b.MonitorGerrit")
-	ctx := trace.NewContext(context.Background(), span)
+// pollGerrit posts the CLs merged since the last one the bot has processed
+func (b *Bot) pollGerrit(ctx context.Context) error {
+	b.clsMu.Lock()
+	defer b.clsMu.Unlock()
 
 	lastID, err := b.GetLastSeenCL(ctx)
 	if err != nil {
-		b.logf("got error while loading last ID from the datastore: %v\n", err)
-		return
+		return fmt.Errorf("got error while loading last ID from the datastore: %v", err)
 	}
 
-	lastID = b.processCLList(ctx, lastID, span)
-	span.Finish()
-	for range tk.C {
-		span = b.traceClient.NewSpan("b.processCLList")
-		ctx := trace.NewContext(context.Background(), span)
-		lastID = b.processCLList(ctx, lastID, span)
-		span.Finish()
-	}
+	_, err = b.processCLList(ctx, lastID, SpanFromContext(ctx))
+	return err
 }
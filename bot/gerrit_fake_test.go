@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeGerrit serves changes the way the Gerrit REST API does, with the )]}'
+// prefix and the _more_changes pagination. Point the Gerrit link of the bot
+// to an httptest.Server running it, with a query such as
+// "/changes/?q=status:merged&n=100". Queries can only filter by status, such
+// as "status:open OR status:closed", and by submission time with mergedafter
+// and mergedbefore.
+type fakeGerrit struct {
+	mu       sync.Mutex
+	changes  []gerritCL
+	number   int
+	requests int
+	updated  time.Time
+
+	// PageSize is used when the request has no n parameter, 100 when zero
+	PageSize int
+}
+
+var fakeMergedRE = regexp.MustCompile(`\s*merged(after|before):"([^"]*)"`)
+
+// newFakeGerrit creates a fakeGerrit with no changes, the first merged one gets the number first
+func newFakeGerrit(first int) *fakeGerrit {
+	return &fakeGerrit{number: first - 1}
+}
+
+// Merge adds a merged change touching the files and returns its number
+func (f *fakeGerrit) Merge(project, branch, subject, message string, files ...string) int {
+	return f.add(gerritMerged, project, branch, subject, message, files)
+}
+
+// Open adds an open change touching the files and returns its number
+func (f *fakeGerrit) Open(project, branch, subject, message string, files ...string) int {
+	return f.add(gerritNew, project, branch, subject, message, files)
+}
+
+func (f *fakeGerrit) add(status, project, branch, subject, message string, files []string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.number++
+	now := f.now()
+	cl := gerritCL{
+		Project:         project,
+		Branch:          branch,
+		ChangeID:        fmt.Sprintf("I%040d", f.number),
+		Number:          f.number,
+		Subject:         subject,
+		Status:          status,
+		Owner:           gerritAccount{Name: "Gopher", Email: "gopher@golang.org"},
+		Created:         now,
+		Updated:         now,
+		CurrentRevision: fmt.Sprintf("%040d", f.number),
+		Labels:          map[string]gerritLabel{},
+	}
+	if status == gerritMerged {
+		cl.Submitted = now
+	}
+	cl.Revisions = map[string]gerritRevision{}
+	revision := gerritRevision{Number: 1, Uploader: cl.Owner}
+	revision.Commit.Subject = subject
+	revision.Commit.Message = message
+	revision.Commit.Author = cl.Owner
+	revision.Files = map[string]gerritFile{}
+	for _, file := range files {
+		revision.Files[file] = gerritFile{}
+	}
+	cl.Revisions[cl.CurrentRevision] = revision
+
+	// Gerrit lists the most recently updated changes first
+	f.changes = append([]gerritCL{cl}, f.changes...)
+	return cl.Number
+}
+
+// now returns the time of an update, later than every other one
+func (f *fakeGerrit) now() string {
+	now := time.Now().UTC()
+	if !now.After(f.updated) {
+		now = f.updated.Add(time.Microsecond)
+	}
+	f.updated = now
+	return now.Format(gerritTimeLayout)
+}
+
+// update changes a change and makes it the most recently updated one
+func (f *fakeGerrit) update(number int, change func(cl *gerritCL)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx := range f.changes {
+		cl := f.changes[idx]
+		if cl.Number != number {
+			continue
+		}
+
+		change(&cl)
+		cl.Updated = f.now()
+		f.changes = append(f.changes[:idx], f.changes[idx+1:]...)
+		f.changes = append([]gerritCL{cl}, f.changes...)
+		return
+	}
+}
+
+// UploadPatchSet adds a patch set to a change, which clears its votes
+func (f *fakeGerrit) UploadPatchSet(number int, uploader string) {
+	f.update(number, func(cl *gerritCL) {
+		revision := cl.Revisions[cl.CurrentRevision]
+		revision.Number++
+		revision.Uploader = gerritAccount{Name: uploader}
+
+		cl.CurrentRevision = fmt.Sprintf("%020d%020d", cl.Number, revision.Number)
+		cl.Revisions = map[string]gerritRevision{cl.CurrentRevision: revision}
+		cl.Labels = map[string]gerritLabel{}
+	})
+}
+
+// Approve gives the highest vote of the label, such as Code-Review +2 or TryBot-Result +1
+func (f *fakeGerrit) Approve(number int, label, name string) {
+	f.vote(number, label, name, 1)
+}
+
+// Reject gives the lowest vote of the label, such as TryBot-Result -1
+func (f *fakeGerrit) Reject(number int, label, name string) {
+	f.vote(number, label, name, -1)
+}
+
+func (f *fakeGerrit) vote(number int, label, name string, sign int) {
+	f.update(number, func(cl *gerritCL) {
+		value := sign
+		if label == codeReviewLabel {
+			value *= 2
+		}
+
+		account := gerritAccount{Name: name}
+		votes := gerritLabel{All: []gerritApproval{{account, value}}}
+		if value > 0 {
+			votes.Approved = &account
+		} else {
+			votes.Rejected = &account
+		}
+		for _, vote := range cl.Labels[label].All {
+			if vote.Name != name {
+				votes.All = append(votes.All, vote)
+			}
+		}
+		cl.Labels[label] = votes
+		f.addReviewer(cl, account)
+	})
+}
+
+// AddReviewer asks someone to review a change
+func (f *fakeGerrit) AddReviewer(number int, name string) {
+	f.update(number, func(cl *gerritCL) {
+		f.addReviewer(cl, gerritAccount{Name: name})
+	})
+}
+
+func (f *fakeGerrit) addReviewer(cl *gerritCL, account gerritAccount) {
+	if cl.Reviewers == nil {
+		cl.Reviewers = map[string][]gerritAccount{}
+	}
+	for _, reviewer := range cl.Reviewers["REVIEWER"] {
+		if reviewer.Name == account.Name {
+			return
+		}
+	}
+	cl.Reviewers["REVIEWER"] = append(cl.Reviewers["REVIEWER"], account)
+}
+
+// EditFile sets how many lines of a file the current patch set of a change inserts and deletes
+func (f *fakeGerrit) EditFile(number int, file string, inserted, deleted int) {
+	f.update(number, func(cl *gerritCL) {
+		revision := cl.Revisions[cl.CurrentRevision]
+		files := map[string]gerritFile{}
+		for name, stat := range revision.Files {
+			files[name] = stat
+		}
+		files[file] = gerritFile{LinesInserted: inserted, LinesDeleted: deleted}
+		revision.Files = files
+		cl.Revisions[cl.CurrentRevision] = revision
+
+		cl.Insertions, cl.Deletions = 0, 0
+		for _, stat := range files {
+			cl.Insertions += stat.LinesInserted
+			cl.Deletions += stat.LinesDeleted
+		}
+	})
+}
+
+// Submit merges an open change
+func (f *fakeGerrit) Submit(number int) {
+	f.update(number, func(cl *gerritCL) {
+		cl.Status = gerritMerged
+		cl.Submitted = f.now()
+	})
+}
+
+// SetSubmitted changes when a merged change was submitted, to fake older merges
+func (f *fakeGerrit) SetSubmitted(number int, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx := range f.changes {
+		if f.changes[idx].Number == number {
+			f.changes[idx].Submitted = at.UTC().Format(gerritTimeLayout)
+		}
+	}
+}
+
+// Abandon abandons an open change
+func (f *fakeGerrit) Abandon(number int) {
+	f.update(number, func(cl *gerritCL) {
+		cl.Status = gerritAbandoned
+	})
+}
+
+// SetAuthor changes the author of the commit of a change
+func (f *fakeGerrit) SetAuthor(number int, name, email string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx := range f.changes {
+		cl := &f.changes[idx]
+		if cl.Number != number {
+			continue
+		}
+		revision := cl.Revisions[cl.CurrentRevision]
+		revision.Commit.Author = gerritAccount{Name: name, Email: email}
+		cl.Revisions[cl.CurrentRevision] = revision
+	}
+}
+
+// Requests returns how many requests were served
+func (f *fakeGerrit) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+func (f *fakeGerrit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+
+	if number, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/changes/")); err == nil {
+		for _, cl := range f.changes {
+			if cl.Number == number {
+				writeGerritJSON(w, cl)
+				return
+			}
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	var after, before time.Time
+	for _, match := range fakeMergedRE.FindAllStringSubmatch(query, -1) {
+		at, err := time.ParseInLocation(gerritQueryTimeLayout, match[2], time.UTC)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if match[1] == "after" {
+			after = at
+		} else {
+			before = at
+		}
+	}
+	query = fakeMergedRE.ReplaceAllString(query, "")
+
+	statuses := map[string]bool{}
+	for _, term := range strings.Split(query, " OR ") {
+		switch strings.TrimSpace(term) {
+		case "status:open":
+			statuses[gerritNew] = true
+		case "status:merged":
+			statuses[gerritMerged] = true
+		case "status:abandoned":
+			statuses[gerritAbandoned] = true
+		case "status:closed":
+			statuses[gerritMerged], statuses[gerritAbandoned] = true, true
+		}
+	}
+	changes := []gerritCL{}
+	for _, cl := range f.changes {
+		if len(statuses) > 0 && !statuses[cl.Status] {
+			continue
+		}
+		if !after.IsZero() || !before.IsZero() {
+			submitted, err := parseGerritTime(cl.Submitted)
+			if err != nil || submitted.Before(after) || (!before.IsZero() && !submitted.Before(before)) {
+				continue
+			}
+		}
+		changes = append(changes, cl)
+	}
+
+	size := f.PageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 {
+		size = n
+	}
+	if size <= 0 {
+		size = 100
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("S"))
+	if start > len(changes) {
+		start = len(changes)
+	}
+
+	end := start + size
+	if end > len(changes) {
+		end = len(changes)
+	}
+	page := append([]gerritCL{}, changes[start:end]...)
+	if end < len(changes) && len(page) > 0 {
+		page[len(page)-1].MoreChanges = true
+	}
+
+	writeGerritJSON(w, page)
+}
+
+func writeGerritJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	fmt.Fprintf(w, ")]}'\n%s", body)
+}
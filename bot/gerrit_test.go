@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newGerritServer serves the fake Gerrit, before runs before every request
+func newGerritServer(t *testing.T, gerrit *fakeGerrit, before func(r *http.Request)) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if before != nil {
+			before(r)
+		}
+		gerrit.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/changes/?q=status:merged&n=10"
+}
+
+func mergeCLs(gerrit *fakeGerrit, count int) {
+	for i := 0; i < count; i++ {
+		gerrit.Merge("go", "master", fmt.Sprintf("pkg%d: change", i), "")
+	}
+}
+
+func clNumbers(cls []gerritCL) []int {
+	numbers := []int{}
+	for _, cl := range cls {
+		numbers = append(numbers, cl.Number)
+	}
+	return numbers
+}
+
+func TestGerritPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		merged   int
+		stopAt   int
+		cls      int
+		more     bool
+		requests int
+	}{
+		{name: "until the end", merged: 35, cls: 35, requests: 4},
+		{name: "until the page where it stops", merged: 35, stopAt: 1022, cls: 20, requests: 2},
+		{name: "up to gerritMaxPages", merged: 150, cls: 10 * gerritMaxPages, more: true, requests: gerritMaxPages},
+		{name: "at the last of gerritMaxPages", merged: 150, stopAt: 1050, cls: 10 * gerritMaxPages, requests: gerritMaxPages},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gerrit := newFakeGerrit(1000)
+			mergeCLs(gerrit, test.merged)
+			b, _, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+			stopped := []int{}
+			query := url.Values{"q": {"status:merged"}, "n": {"10"}}
+			cls, more, err := b.gerritPages(context.Background(), query, func(cl gerritCL) bool {
+				stopped = append(stopped, cl.Number)
+				return cl.Number == test.stopAt
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cls) != test.cls || more != test.more {
+				t.Errorf("got %d CLs with more: %v, want %d with more: %v", len(cls), more, test.cls, test.more)
+			}
+			if gerrit.Requests() != test.requests {
+				t.Errorf("got %d requests, want %d", gerrit.Requests(), test.requests)
+			}
+			if fmt.Sprint(stopped) != fmt.Sprint(clNumbers(cls)) {
+				t.Errorf("stop saw the CLs %v, want %v", stopped, clNumbers(cls))
+			}
+		})
+	}
+}
+
+func TestFetchCLsSincePaginates(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 35)
+
+	starts := []string{}
+	link := newGerritServer(t, gerrit, func(r *http.Request) {
+		starts = append(starts, r.URL.Query().Get("S"))
+	})
+	b, _, _ := newTestBot(t, NewMemoryStore(), link)
+
+	cls, found, err := b.fetchCLsSince(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("the last seen CL was not found")
+	}
+	if got, want := strings.Join(starts, ","), ",10,20,30"; got != want {
+		t.Errorf("fetched the pages starting at %q, want %q", got, want)
+	}
+
+	numbers := clNumbers(cls)
+	if len(numbers) != 35 {
+		t.Fatalf("got %d CLs, want 35: %v", len(numbers), numbers)
+	}
+	for idx, number := range numbers {
+		if number != 1034-idx {
+			t.Fatalf("got the CLs %v, want 1034 to 1000, newest first", numbers)
+		}
+	}
+}
+
+func TestFetchCLsSinceStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		merged   int
+		lastID   int
+		found    bool
+		requests int
+		cls      int
+	}{
+		{name: "at the last seen CL", merged: 35, lastID: 1025, found: true, requests: 1, cls: 10},
+		{name: "at the page of the last seen CL", merged: 35, lastID: 1012, found: true, requests: 3, cls: 30},
+		{name: "without history", merged: 35, lastID: -1, found: false, requests: 1, cls: 10},
+		{name: "at the last page", merged: 35, lastID: 999, found: false, requests: 4, cls: 35},
+		{name: "after gerritMaxPages", merged: 150, lastID: 999, found: false, requests: gerritMaxPages, cls: 10 * gerritMaxPages},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gerrit := newFakeGerrit(1000)
+			mergeCLs(gerrit, test.merged)
+			b, _, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+			cls, found, err := b.fetchCLsSince(context.Background(), test.lastID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != test.found {
+				t.Errorf("found is %v, want %v", found, test.found)
+			}
+			if gerrit.Requests() != test.requests {
+				t.Errorf("got %d requests, want %d", gerrit.Requests(), test.requests)
+			}
+			if len(cls) != test.cls {
+				t.Errorf("got %d CLs, want %d", len(cls), test.cls)
+			}
+		})
+	}
+}
+
+// newShiftingGerrit returns a fake Gerrit with 25 merged CLs, in which 3 CLs
+// are merged before the second page is served, which pushes the last 3 CLs of
+// the first page to the second one
+func newShiftingGerrit(t *testing.T) string {
+	gerrit := newFakeGerrit(1000)
+	mergeCLs(gerrit, 25)
+
+	return newGerritServer(t, gerrit, func(r *http.Request) {
+		if r.URL.Query().Get("S") == "10" {
+			mergeCLs(gerrit, 3)
+		}
+	})
+}
+
+func TestFetchCLsSinceDedupes(t *testing.T) {
+	b, _, _ := newTestBot(t, NewMemoryStore(), newShiftingGerrit(t))
+
+	cls, found, err := b.fetchCLsSince(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("the last seen CL was not found")
+	}
+
+	numbers := clNumbers(cls)
+	if len(numbers) != 25 {
+		t.Fatalf("got %d CLs, want 25: %v", len(numbers), numbers)
+	}
+	for idx, number := range numbers {
+		if number != 1024-idx {
+			t.Fatalf("got the CLs %v, want 1024 to 1000 once, newest first", numbers)
+		}
+	}
+}
+
+func TestProcessCLListLogsGaps(t *testing.T) {
+	b, chat, log := newTestBot(t, NewMemoryStore(), newShiftingGerrit(t))
+
+	// the last seen CL is older than every CL Gerrit returns
+	lastID, err := b.processCLList(context.Background(), 999, NewNoopTracer().NewSpan("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != 1024 {
+		t.Errorf("the last seen CL is %d, want 1024", lastID)
+	}
+	if log.count("gap in the Gerrit history: CL 999 was not found") != 1 {
+		t.Errorf("the gap was not logged: %q", log.lines)
+	}
+
+	posts := map[int]int{}
+	for _, msg := range chat.Messages() {
+		if msg.Channel != "#golang_cls" {
+			continue
+		}
+		var number int
+		if _, err := fmt.Sscanf(msg.Text, "[%d]", &number); err == nil {
+			posts[number]++
+		}
+	}
+	for number := 1000; number <= 1024; number++ {
+		if posts[number] != 1 {
+			t.Errorf("CL %d was posted %d times, want once", number, posts[number])
+		}
+		if _, err := b.store.GetCL(context.Background(), number); err != nil {
+			t.Errorf("CL %d was not stored: %v", number, err)
+		}
+	}
+	if len(posts) != 25 {
+		t.Errorf("got posts for %d CLs, want 25", len(posts))
+	}
+}
@@ -3,6 +3,7 @@ package bot
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -10,49 +11,63 @@ import (
 	"github.com/nlopes/slack"
 )
 
+// goTimeFMCheckpoint names the checkpoint of the last live announcement, it is
+// kept in the store so a new leader doesn't announce the same stream again
+const goTimeFMCheckpoint = "gotimefm"
+
 type goTimeFMStatus struct {
 	Streaming bool `json:"streaming"`
 }
 
-func (b *Bot) GoTimeFM() {
-	ctx := context.Background()
+// GoTimeFM tells the gotimefm channel when the podcast is live
+func (b *Bot) GoTimeFM(ctx context.Context) error {
 	req, err := http.NewRequest("GET", "https://changelog.com/live/status", nil)
-	req = req.WithContext(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	req = req.WithContext(ctx)
 	resp, err := b.client.Do(req)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		b.logf("got error while reading body for gotimefm %s", err)
-		return
+		return fmt.Errorf("got error while reading body for gotimefm: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		b.logf("got non-200 code from gotimefm: %d\n", resp.StatusCode)
-		return
+		return fmt.Errorf("got non-200 code from gotimefm: %d", resp.StatusCode)
 	}
 
 	status := &goTimeFMStatus{}
 	err = json.Unmarshal(body, status)
 	if err != nil {
-		b.logf("got error while unmarshalling gotimefm response: %s\n", err)
-		return
+		return fmt.Errorf("got error while unmarshalling gotimefm response: %v", err)
 	}
 
+	if !status.Streaming {
+		return nil
+	}
+
+	lastNotified, err := b.store.Checkpoint(ctx, goTimeFMCheckpoint)
+	if err != nil {
+		return fmt.Errorf("got error while loading the last gotimefm notification: %v", err)
+	}
 	timeNow := time.Now()
-	if status.Streaming && timeNow.Sub(b.goTimeLastNotified).Hours() > 24 {
-		b.goTimeLastNotified = timeNow
-		response := ":tada: GoTimeFM is now live :tada:"
-		params := slack.PostMessageParameters{AsUser: true}
-		_, _, err := b.slackBotAPI.PostMessageContext(ctx, b.channels["gotimefm"].slackID, response, params)
-		if err != nil {
-			b.logf("got error while notifying slack: %s\n", err)
-		}
-		return
+	if timeNow.Sub(lastNotified).Hours() <= 24 {
+		return nil
+	}
+	if err := b.store.SaveCheckpoint(ctx, goTimeFMCheckpoint, timeNow); err != nil {
+		return fmt.Errorf("got error while saving the gotimefm notification: %v", err)
+	}
+
+	response := ":tada: GoTimeFM is now live :tada:"
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err = b.chat.PostMessage(ctx, b.channel("gotimefm").slackID, response, params)
+	if err != nil {
+		return fmt.Errorf("got error while notifying slack: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	helpPageSize        = 15
+	helpDefaultCategory = "General"
+)
+
+// helpCommands returns the commands that should be listed for the event author,
+// sorted by category and then by name
+func (b *Bot) helpCommands(ctx context.Context, event *slack.MessageEvent) []*Command {
+	roles := b.rolesOf(ctx, event.User, event.Channel)
+
+	commands := []*Command{}
+	for _, cmd := range b.commands.Commands() {
+		if cmd.Description == "" {
+			continue
+		}
+		if !roles.allow(cmd.Role) {
+			continue
+		}
+		commands = append(commands, cmd)
+	}
+
+	sort.SliceStable(commands, func(i, j int) bool {
+		ci, cj := commands[i].category(), commands[j].category()
+		if ci != cj {
+			return ci < cj
+		}
+		return commands[i].Name < commands[j].Name
+	})
+
+	return commands
+}
+
+func (cmd *Command) category() string {
+	if cmd.Category == "" {
+		return helpDefaultCategory
+	}
+	return cmd.Category
+}
+
+func (cmd *Command) usage() string {
+	if cmd.Usage != "" {
+		return cmd.Usage
+	}
+	return cmd.Name
+}
+
+func (cmd *Command) helpLine() string {
+	line := `- "` + cmd.usage() + `"`
+	for _, alias := range cmd.Aliases {
+		line += ` OR "` + alias + `"`
+	}
+	return line + ` -> ` + cmd.Description
+}
+
+func (b *Bot) helpPage(ctx context.Context, event *slack.MessageEvent, page int) string {
+	commands := b.helpCommands(ctx, event)
+
+	pages := (len(commands) + helpPageSize - 1) / helpPageSize
+	if pages == 0 {
+		return `There are no commands available for you`
+	}
+	if page < 1 || page > pages {
+		return fmt.Sprintf(`There is no help page %d, please pick one between 1 and %d`, page, pages)
+	}
+
+	start := (page - 1) * helpPageSize
+	end := start + helpPageSize
+	if end > len(commands) {
+		end = len(commands)
+	}
+
+	buff := &bytes.Buffer{}
+	buff.WriteString("Here's a list of supported commands")
+	category := ""
+	for _, cmd := range commands[start:end] {
+		if cmd.category() != category {
+			category = cmd.category()
+			buff.WriteString("\n\n*" + category + "*")
+		}
+		buff.WriteString("\n" + cmd.helpLine())
+	}
+
+	if pages > 1 {
+		buff.WriteString(fmt.Sprintf("\n\nPage %d of %d.", page, pages))
+		if page < pages {
+			buff.WriteString(fmt.Sprintf(` Type "help %d" to see more.`, page+1))
+		}
+	}
+	buff.WriteString("\nType \"help <command>\" to learn more about a command.")
+
+	return buff.String()
+}
+
+func (b *Bot) helpCommand(ctx context.Context, event *slack.MessageEvent, name string) string {
+	cmd := b.commands.Lookup(name)
+	if cmd == nil || cmd.Description == "" || !b.rolesOf(ctx, event.User, event.Channel).allow(cmd.Role) {
+		return fmt.Sprintf(`I don't know the command %q, type "help" to see what I can do`, name)
+	}
+
+	lines := []string{
+		`*` + cmd.Name + `* -> ` + cmd.Description,
+		`Usage: "` + cmd.usage() + `"`,
+	}
+	if len(cmd.Aliases) > 0 {
+		lines = append(lines, `Also known as: "`+strings.Join(cmd.Aliases, `", "`)+`"`)
+	}
+	lines = append(lines, `Category: `+cmd.category())
+	if cmd.Role != "" {
+		lines = append(lines, `Needs the role: `+cmd.Role)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func help(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	eventText := strings.Trim(strings.ToLower(event.Text), " \n\r")
+	eventText = b.trimBot(eventText)
+	eventText = strings.TrimSpace(strings.TrimPrefix(eventText, "help"))
+
+	if eventText == "" {
+		respond(ctx, b, event, b.helpPage(ctx, event, 1))
+		return
+	}
+
+	if page, err := strconv.Atoi(eventText); err == nil {
+		respond(ctx, b, event, b.helpPage(ctx, event, page))
+		return
+	}
+
+	respond(ctx, b, event, b.helpCommand(ctx, event, strings.Trim(eventText, `"`)))
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// AdminsConfig lists who administers the bot
+	AdminsConfig struct {
+		// Users are user handles, or user IDs
+		Users []string `json:"users"`
+		// UserGroups are handles of user groups, all their members are admins
+		UserGroups []string `json:"user_groups"`
+	}
+
+	// DeployNotifyConfig says who is told about new deployments
+	DeployNotifyConfig struct {
+		// Admins sends a direct message to every admin
+		Admins bool `json:"admins"`
+		// Channels are names of configured channels
+		Channels []string `json:"channels"`
+	}
+)
+
+var userIDRE = regexp.MustCompile(`^[UW][0-9A-Z]+$`)
+
+// userDirectory resolves user handles to IDs, the members of the workspace
+// are listed at most once
+type userDirectory struct {
+	chat  Messenger
+	users map[string]string
+}
+
+func (d *userDirectory) resolve(ctx context.Context, handle string) (string, error) {
+	handle = strings.TrimPrefix(handle, "@")
+	if userIDRE.MatchString(handle) {
+		return handle, nil
+	}
+
+	if d.users == nil {
+		list, err := d.chat.Users(ctx)
+		if err != nil {
+			return "", err
+		}
+		d.users = map[string]string{}
+		for _, user := range list {
+			d.users[strings.ToLower(user.Name)] = user.ID
+		}
+	}
+
+	id, ok := d.users[strings.ToLower(handle)]
+	if !ok {
+		return "", fmt.Errorf("could not find the user %q", handle)
+	}
+	return id, nil
+}
+
+// resolveAdmins turns the configured handles and user groups into user IDs
+func (b *Bot) resolveAdmins(ctx context.Context, cfg *Config, directory *userDirectory, span Span) (map[string]string, error) {
+	childSpan := span.NewChild("b.resolveAdmins")
+	defer childSpan.Finish()
+
+	admins := map[string]string{}
+
+	for _, handle := range cfg.Admins.Users {
+		id, err := directory.resolve(ctx, handle)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve the admin %q: %v", handle, err)
+		}
+		admins[id] = strings.TrimPrefix(handle, "@")
+	}
+
+	for _, group := range cfg.Admins.UserGroups {
+		group = strings.TrimPrefix(group, "@")
+		members, err := b.chat.UserGroupMembers(ctx, group)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the members of the admin group %q: %v", group, err)
+		}
+		for _, id := range members {
+			if _, ok := admins[id]; !ok {
+				admins[id] = "@" + group
+			}
+		}
+	}
+
+	return admins, nil
+}
+
+func (b *Bot) isAdmin(userID string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, ok := b.admins[userID]
+	return ok
+}
+
+// adminIDs returns the user IDs of all admins
+func (b *Bot) adminIDs() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ids := make([]string, 0, len(b.admins))
+	for id := range b.admins {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// alertAdmins sends a direct message to every admin
+func (b *Bot) alertAdmins(ctx context.Context, message string) {
+	params := slack.PostMessageParameters{AsUser: true}
+	for _, id := range b.adminIDs() {
+		_, _, err := b.chat.DirectMessage(ctx, id, message, params)
+		if err != nil {
+			b.logf("failed to alert admin %s: %v\n", id, err)
+		}
+	}
+}
+
+// notifyAdmins sends a message to the configured deploy notification targets
+func (b *Bot) notifyAdmins(ctx context.Context, message string) {
+	b.mu.RLock()
+	notify := b.config.DeployNotify
+	b.mu.RUnlock()
+
+	params := slack.PostMessageParameters{AsUser: true}
+
+	if notify.Admins {
+		for _, id := range b.adminIDs() {
+			_, _, err := b.chat.DirectMessage(ctx, id, message, params)
+			if err != nil {
+				b.logf("failed to notify admin %s: %v\n", id, err)
+			}
+		}
+	}
+
+	for _, name := range notify.Channels {
+		channel := strings.TrimPrefix(b.channel(strings.ToLower(name)).slackID, "#")
+		_, _, err := b.chat.PostMessage(ctx, channel, message, params)
+		if err != nil {
+			b.logf("failed to notify channel %s: %v\n", name, err)
+		}
+	}
+}
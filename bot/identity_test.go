@@ -0,0 +1,24 @@
+package bot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNotifyAdmins(t *testing.T) {
+	b, chat, _ := newTestBot(t, NewMemoryStore(), "")
+
+	b.mu.Lock()
+	b.config.DeployNotify = DeployNotifyConfig{Admins: true, Channels: []string{"Golang-CLs"}}
+	b.mu.Unlock()
+
+	b.notifyAdmins(context.Background(), "Deployed version test")
+
+	sent := map[string]bool{}
+	for _, msg := range chat.Messages() {
+		sent[msg.Channel] = msg.Text == "Deployed version test"
+	}
+	if len(sent) != 2 || !sent["UADMIN"] || !sent["golang-cls"] {
+		t.Errorf("notified %v, want the admin and the channel ID", sent)
+	}
+}
@@ -0,0 +1,472 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// Schedule decides when a job runs next
+	Schedule interface {
+		// Next returns the first run strictly after the given time, or the
+		// zero time when the job must not run anymore
+		Next(after time.Time) time.Time
+		// String describes the schedule for humans
+		String() string
+	}
+
+	// Every runs a job at a fixed interval
+	Every time.Duration
+
+	// RestartPolicy says how a failed run is retried before waiting for the next scheduled run
+	RestartPolicy struct {
+		// MaxRetries is how many times a failed run is retried, 0 disables the retries
+		MaxRetries int
+		// Backoff is the wait before the first retry, it doubles after each retry
+		Backoff time.Duration
+		// MaxBackoff caps the wait between retries
+		MaxBackoff time.Duration
+	}
+
+	// Job is a periodic task run by the Supervisor
+	Job struct {
+		Name     string
+		Schedule Schedule
+		Restart  RestartPolicy
+		// Immediate jobs also run as soon as the supervisor starts
+		Immediate bool
+		// Jitter delays every scheduled run by a random duration up to this
+		// one, so the jobs don't all hit the same services at once
+		Jitter time.Duration
+		// Singleton jobs only run on the leader when there are several replicas
+		Singleton bool
+		// Run does the work, a panic is recovered and counts as an error
+		Run func(ctx context.Context) error
+	}
+
+	// JobStatus is what the Supervisor knows about a job
+	JobStatus struct {
+		Name         string
+		Schedule     string
+		Running      bool
+		Runs         int
+		Panics       int
+		Failures     int
+		LastRun      time.Time
+		LastDuration time.Duration
+		LastError    string
+		LastErrorAt  time.Time
+		NextRun      time.Time
+		// Standby singleton jobs wait for this replica to become the leader
+		Standby bool
+	}
+
+	supervisedJob struct {
+		job    Job
+		status JobStatus
+	}
+
+	// Supervisor runs the periodic jobs, recovers their panics, retries
+	// their failures and keeps track of how they are doing
+	Supervisor struct {
+		tracer Tracer
+		logf   Logger
+		report func(ctx context.Context, message string)
+		leader *LeaderElection
+
+		mu      sync.RWMutex
+		jobs    []*supervisedJob
+		started bool
+	}
+
+	jobPanic struct {
+		value interface{}
+		stack []byte
+	}
+)
+
+// Next runs the job one interval after the previous run
+func (e Every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+func (e Every) String() string {
+	return "every " + time.Duration(e).String()
+}
+
+func (p *jobPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// NewSupervisor creates a Supervisor, report is called for the jobs which
+// keep failing after their retries, panics included, and when they work again
+func NewSupervisor(tracer Tracer, log Logger, report func(ctx context.Context, message string)) *Supervisor {
+	return &Supervisor{
+		tracer: tracer,
+		logf:   log,
+		report: report,
+	}
+}
+
+// Register adds a job, all the jobs must be registered before Run is called
+func (s *Supervisor) Register(job Job) error {
+	if job.Name == "" {
+		return fmt.Errorf("the job has no name")
+	}
+	if job.Schedule == nil || job.Run == nil {
+		return fmt.Errorf("the job %q needs a schedule and a function to run", job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return fmt.Errorf("the job %q was registered after the supervisor started", job.Name)
+	}
+	for _, existing := range s.jobs {
+		if existing.job.Name == job.Name {
+			return fmt.Errorf("the job %q is already registered", job.Name)
+		}
+	}
+
+	s.jobs = append(s.jobs, &supervisedJob{
+		job:    job,
+		status: JobStatus{Name: job.Name, Schedule: job.Schedule.String()},
+	})
+	return nil
+}
+
+// UseLeaderElection runs the singleton jobs only while this replica is the
+// leader, the supervisor takes part in the election while it runs
+func (s *Supervisor) UseLeaderElection(leader *LeaderElection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.leader = leader
+}
+
+func (s *Supervisor) isLeader() bool {
+	return s.leader == nil || s.leader.IsLeader()
+}
+
+// Status returns the status of every job, sorted by name
+func (s *Supervisor) Status() []JobStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		statuses = append(statuses, job.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Run runs the jobs until the context is cancelled
+func (s *Supervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	jobs := append([]*supervisedJob{}, s.jobs...)
+	s.mu.Unlock()
+
+	wg := sync.WaitGroup{}
+	if s.leader != nil {
+		// the immediate singleton jobs run right away on the first leader
+		s.leader.campaign(ctx)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.leader.Run(ctx)
+		}()
+	}
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job *supervisedJob) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (s *Supervisor) update(job *supervisedJob, fn func(status *JobStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&job.status)
+}
+
+// sleep waits for the duration and says if the context is still alive
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withJitter delays the time by a random duration up to jitter
+func withJitter(t time.Time, jitter time.Duration) time.Time {
+	if t.IsZero() || jitter <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(jitter))))
+}
+
+func (s *Supervisor) loop(ctx context.Context, job *supervisedJob) {
+	next := withJitter(job.job.Schedule.Next(time.Now()), job.job.Jitter)
+	if job.job.Immediate {
+		next = time.Now()
+	}
+
+	for {
+		s.update(job, func(status *JobStatus) {
+			status.NextRun = next
+		})
+		if next.IsZero() {
+			return
+		}
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+
+		// a standby interval job runs as soon as this replica becomes the
+		// leader, the other jobs skip the runs they missed on standby, or a
+		// new leader would post the weekly digest in the middle of the week
+		if job.job.Singleton && !s.isLeader() {
+			s.update(job, func(status *JobStatus) {
+				status.Standby = true
+			})
+			if _, interval := job.job.Schedule.(Every); interval {
+				next = time.Now().Add(s.leader.renew)
+			} else {
+				next = withJitter(job.job.Schedule.Next(time.Now()), job.job.Jitter)
+			}
+			continue
+		}
+
+		s.runWithRetries(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+
+		next = withJitter(job.job.Schedule.Next(time.Now()), job.job.Jitter)
+	}
+}
+
+func (s *Supervisor) runWithRetries(ctx context.Context, job *supervisedJob) {
+	policy := job.job.Restart
+	backoff := policy.Backoff
+
+	s.mu.RLock()
+	reported := job.status.Failures > policy.MaxRetries
+	s.mu.RUnlock()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = s.runOnce(ctx, job)
+		if err == nil {
+			if reported {
+				s.report(ctx, fmt.Sprintf("Job %s is working again", job.job.Name))
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if attempt >= policy.MaxRetries {
+			break
+		}
+
+		s.logf("job %s failed, retrying in %s: %v\n", job.job.Name, backoff, err)
+		retry := time.Now().Add(backoff)
+		s.update(job, func(status *JobStatus) {
+			status.NextRun = retry
+		})
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+
+	s.logf("job %s failed: %v\n", job.job.Name, err)
+
+	// a failing job, panics included, is only reported once, until it works
+	// again, to avoid flooding the admins
+	if !reported {
+		s.report(ctx, fmt.Sprintf("Job %s is failing: %v", job.job.Name, err))
+	}
+}
+
+func (s *Supervisor) runOnce(ctx context.Context, job *supervisedJob) (err error) {
+	span := s.tracer.NewSpan("job." + job.job.Name)
+	defer span.Finish()
+
+	start := time.Now()
+	s.update(job, func(status *JobStatus) {
+		status.Running, status.Standby = true, false
+	})
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &jobPanic{value: recovered, stack: debug.Stack()}
+		}
+
+		failed := err != nil
+		s.update(job, func(status *JobStatus) {
+			status.Running = false
+			status.Runs++
+			status.LastRun = start
+			status.LastDuration = time.Since(start)
+			if !failed {
+				status.Failures = 0
+				return
+			}
+			status.Failures++
+			status.LastError = err.Error()
+			status.LastErrorAt = time.Now()
+		})
+
+		if failed {
+			span.SetLabel("error", err.Error())
+		}
+		if p, ok := err.(*jobPanic); ok {
+			s.update(job, func(status *JobStatus) {
+				status.Panics++
+			})
+			s.logf("job %s panicked: %v\n%s\n", job.job.Name, p.value, p.stack)
+		}
+	}()
+
+	return job.job.Run(NewSpanContext(ctx, span))
+}
+
+// Jobs returns the supervisor of the periodic jobs of the bot, more jobs can
+// be registered on it until it runs
+func (b *Bot) Jobs() *Supervisor {
+	return b.jobs
+}
+
+func (b *Bot) registerBuiltinJobs() {
+	weekly, err := ParseSchedule(digestSchedule, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+
+	jobs := []Job{
+		{
+			Name:      "gerrit",
+			Schedule:  Every(30 * time.Minute),
+			Restart:   RestartPolicy{MaxRetries: 6, Backoff: 10 * time.Second, MaxBackoff: 5 * time.Minute},
+			Immediate: true,
+			Singleton: true,
+			Run:       b.pollGerrit,
+		},
+		{
+			Name:      "reviews",
+			Schedule:  Every(reviewsInterval),
+			Restart:   RestartPolicy{MaxRetries: 3, Backoff: 10 * time.Second, MaxBackoff: time.Minute},
+			Immediate: true,
+			Singleton: true,
+			Run:       b.pollReviews,
+		},
+		{
+			Name:      "digest",
+			Schedule:  weekly,
+			Restart:   RestartPolicy{MaxRetries: 3, Backoff: time.Minute, MaxBackoff: 10 * time.Minute},
+			Singleton: true,
+			Run:       b.postWeeklyDigest,
+		},
+		{
+			Name:      "cl-migration",
+			Schedule:  Every(time.Minute),
+			Restart:   RestartPolicy{MaxRetries: 3, Backoff: 10 * time.Second, MaxBackoff: time.Minute},
+			Immediate: true,
+			Singleton: true,
+			Run:       b.migrateCLs,
+		},
+		{
+			Name:      "gotimefm",
+			Schedule:  Every(1 * time.Minute),
+			Singleton: true,
+			Run:       b.GoTimeFM,
+		},
+		{
+			Name:      "scheduler",
+			Schedule:  Every(scheduledTasksInterval),
+			Immediate: true,
+			Run:       b.runScheduledTasks,
+		},
+	}
+
+	for _, job := range jobs {
+		if err := b.jobs.Register(job); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func formatJobTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format("2006-01-02 15:04:05 MST")
+}
+
+func listJobs(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	statuses := b.jobs.Status()
+	if len(statuses) == 0 {
+		b.directReply(ctx, event, `There are no jobs`)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	if b.leader.IsLeader() {
+		buff.WriteString(fmt.Sprintf("This replica, %s, is the leader. ", b.leader.ID()))
+	} else {
+		buff.WriteString(fmt.Sprintf("This replica, %s, is on standby. ", b.leader.ID()))
+	}
+	buff.WriteString("Here are the background jobs")
+	for _, status := range statuses {
+		buff.WriteString(fmt.Sprintf("\n- *%s* (%s): last run %s", status.Name, status.Schedule, formatJobTime(status.LastRun)))
+		if !status.LastRun.IsZero() {
+			buff.WriteString(fmt.Sprintf(" in %s", status.LastDuration.Round(time.Millisecond)))
+		}
+		if status.Running {
+			buff.WriteString(", running now")
+		} else if status.Standby {
+			buff.WriteString(", waiting to become the leader")
+		} else {
+			buff.WriteString(", next run " + formatJobTime(status.NextRun))
+		}
+		if status.LastError != "" {
+			buff.WriteString(fmt.Sprintf(", last error at %s: %s", formatJobTime(status.LastErrorAt), status.LastError))
+		}
+		if status.Failures > 0 {
+			buff.WriteString(fmt.Sprintf(", failing for %d runs", status.Failures))
+		}
+		if status.Panics > 0 {
+			buff.WriteString(fmt.Sprintf(", %d panics", status.Panics))
+		}
+	}
+
+	b.directReply(ctx, event, buff.String())
+}
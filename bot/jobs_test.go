@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisorReportsPanicsOnce(t *testing.T) {
+	mu, reports := sync.Mutex{}, []string{}
+	log := &testLog{}
+	s := NewSupervisor(NewNoopTracer(), log.logf, func(ctx context.Context, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, message)
+	})
+
+	panics := 6
+	err := s.Register(Job{
+		Name:      "flaky",
+		Schedule:  Every(5 * time.Millisecond),
+		Restart:   RestartPolicy{MaxRetries: 1, Backoff: time.Millisecond},
+		Immediate: true,
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if panics > 0 {
+				panics--
+				panic("boom")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	recovered := waitFor(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reports) > 0 && strings.Contains(reports[len(reports)-1], "working again")
+	})
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !recovered || len(reports) != 2 || reports[0] != "Job flaky is failing: panic: boom" {
+		t.Errorf("got the reports %q, want the failure once and the recovery", reports)
+	}
+	if log.count("job flaky panicked: boom") != 6 {
+		t.Errorf("logged %d panics, want 6", log.count("job flaky panicked: boom"))
+	}
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	leaderLease = "leader"
+	leaderTTL   = 30 * time.Second
+)
+
+type (
+	// LeaderElection elects one leader among the replicas of the bot with a
+	// lease kept in the store. The leader renews the lease, if it stops doing
+	// so the lease expires and another replica takes over.
+	LeaderElection struct {
+		store LeaseStore
+		name  string
+		id    string
+		ttl   time.Duration
+		renew time.Duration
+		logf  Logger
+
+		mu          sync.RWMutex
+		leaderUntil time.Time
+		leader      bool
+	}
+)
+
+// NewLeaderElection creates an election for the lease with the given name,
+// id identifies this replica and the lease is renewed every third of the ttl
+func NewLeaderElection(store LeaseStore, name, id string, ttl time.Duration, log Logger) *LeaderElection {
+	return &LeaderElection{
+		store: store,
+		name:  name,
+		id:    id,
+		ttl:   ttl,
+		renew: ttl / 3,
+		logf:  log,
+	}
+}
+
+// newReplicaID identifies a replica by its host name, which is the pod name
+// on Kubernetes, and a random suffix
+func newReplicaID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gopher"
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return host
+	}
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// ID identifies this replica
+func (e *LeaderElection) ID() string {
+	return e.id
+}
+
+// IsLeader says if this replica holds the lease. A leader which can't renew
+// the lease stops being one before the lease expires in the store.
+func (e *LeaderElection) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return time.Now().Before(e.leaderUntil)
+}
+
+// Run keeps trying to get, or renew, the lease until the context is
+// cancelled, then releases it so another replica can take over right away
+func (e *LeaderElection) Run(ctx context.Context) {
+	tk := time.NewTicker(e.renew)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-tk.C:
+		}
+
+		e.campaign(ctx)
+	}
+}
+
+// campaign tries to get, or renew, the lease once
+func (e *LeaderElection) campaign(ctx context.Context) {
+	// the lease is counted from before the request, the store has it for longer
+	start := time.Now()
+	acquired, err := e.store.AcquireLease(ctx, e.name, e.id, e.ttl)
+	if err != nil && ctx.Err() == nil {
+		e.logf("could not renew the %s lease: %v\n", e.name, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if acquired {
+		e.leaderUntil = start.Add(e.ttl)
+	} else if err == nil {
+		e.leaderUntil = time.Time{}
+	}
+
+	leader := time.Now().Before(e.leaderUntil)
+	if leader != e.leader {
+		if leader {
+			e.logf("%s is now the leader\n", e.id)
+		} else {
+			e.logf("%s is not the leader anymore\n", e.id)
+		}
+	}
+	e.leader = leader
+}
+
+func (e *LeaderElection) release() {
+	e.mu.Lock()
+	wasLeader := time.Now().Before(e.leaderUntil)
+	e.leaderUntil, e.leader = time.Time{}, false
+	e.mu.Unlock()
+
+	if !wasLeader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.renew)
+	defer cancel()
+	if err := e.store.ReleaseLease(ctx, e.name, e.id); err != nil {
+		e.logf("could not release the %s lease: %v\n", e.name, err)
+	}
+}
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clientFunc is a Client answering with a function
+type clientFunc func(r *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// streamingClient answers that GoTimeFM is live
+var streamingClient = clientFunc(func(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"streaming":true}`)),
+	}, nil
+})
+
+const testLeaderTTL = 300 * time.Millisecond
+
+// replica is a bot whose supervisor only runs GoTimeFM and a counting
+// singleton job, with an election faster than the default one
+type replica struct {
+	*Bot
+	chat *FakeMessenger
+
+	mu   sync.Mutex
+	runs int
+}
+
+func newReplica(t *testing.T, store Store, id string) *replica {
+	b, chat, log := newTestBot(t, store, "")
+	r := &replica{Bot: b, chat: chat}
+
+	b.client = streamingClient
+	b.leader = NewLeaderElection(store, leaderLease, id, testLeaderTTL, log.logf)
+	b.jobs = NewSupervisor(NewNoopTracer(), log.logf, b.alertAdmins)
+	b.jobs.UseLeaderElection(b.leader)
+
+	jobs := []Job{
+		{Name: "gotimefm", Schedule: Every(20 * time.Millisecond), Immediate: true, Singleton: true, Run: b.GoTimeFM},
+		{Name: "count", Schedule: Every(20 * time.Millisecond), Immediate: true, Singleton: true, Run: func(ctx context.Context) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.runs++
+			return nil
+		}},
+	}
+	for _, job := range jobs {
+		if err := b.jobs.Register(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+// start runs the jobs until the returned function is called, which waits for them to stop
+func (r *replica) start() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.jobs.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (r *replica) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs
+}
+
+// jobRuns returns how many times the job ran
+func (r *replica) jobRuns(name string) int {
+	for _, status := range r.jobs.Status() {
+		if status.Name == name {
+			return status.Runs
+		}
+	}
+	return 0
+}
+
+func (r *replica) liveAnnouncements() int {
+	count := 0
+	for _, msg := range r.chat.Messages() {
+		if strings.Contains(msg.Text, "GoTimeFM is now live") {
+			count++
+		}
+	}
+	return count
+}
+
+// waitFor polls the condition until it is true or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestSingletonJobsRunOnOneReplica(t *testing.T) {
+	store := NewMemoryStore()
+	first, second := newReplica(t, store, "first"), newReplica(t, store, "second")
+
+	stopFirst := first.start()
+	if !waitFor(t, time.Second, func() bool { return first.count() > 0 }) {
+		t.Fatal("the first replica never ran the singleton job")
+	}
+	stopSecond := second.start()
+	defer stopSecond()
+
+	time.Sleep(2 * testLeaderTTL)
+	if !first.leader.IsLeader() || second.leader.IsLeader() {
+		t.Fatalf("the leaders are first: %v, second: %v, want only the first", first.leader.IsLeader(), second.leader.IsLeader())
+	}
+	if second.count() != 0 {
+		t.Errorf("the standby replica ran the singleton job %d times", second.count())
+	}
+
+	// the first replica releases the lease when it stops
+	stopFirst()
+	if !waitFor(t, testLeaderTTL, func() bool { return second.count() > 0 }) {
+		t.Fatal("the second replica didn't take over once the lease was released")
+	}
+
+	// the stream was announced by the first leader only
+	if !waitFor(t, time.Second, func() bool { return second.jobRuns("gotimefm") > 0 }) {
+		t.Fatal("the second replica never checked GoTimeFM")
+	}
+	if first.liveAnnouncements() != 1 || second.liveAnnouncements() != 0 {
+		t.Errorf("GoTimeFM was announced %d times by the first replica and %d times by the second, want once by the first",
+			first.liveAnnouncements(), second.liveAnnouncements())
+	}
+}
+
+func TestLeaderLeaseExpires(t *testing.T) {
+	store := NewMemoryStore()
+	log := &testLog{}
+	first := NewLeaderElection(store, leaderLease, "first", testLeaderTTL, log.logf)
+	second := NewLeaderElection(store, leaderLease, "second", testLeaderTTL, log.logf)
+	ctx := context.Background()
+
+	first.campaign(ctx)
+	second.campaign(ctx)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("the leaders are first: %v, second: %v, want only the first", first.IsLeader(), second.IsLeader())
+	}
+
+	// the first replica stops renewing the lease without releasing it
+	time.Sleep(testLeaderTTL + 50*time.Millisecond)
+	if first.IsLeader() {
+		t.Error("the first replica is still the leader after its lease expired")
+	}
+
+	second.campaign(ctx)
+	first.campaign(ctx)
+	if first.IsLeader() || !second.IsLeader() {
+		t.Errorf("the leaders are first: %v, second: %v, want only the second", first.IsLeader(), second.IsLeader())
+	}
+}
+
+// slotSchedule runs a job at fixed times, like a cron expression
+type slotSchedule []time.Time
+
+func (s slotSchedule) Next(after time.Time) time.Time {
+	for _, slot := range s {
+		if slot.After(after) {
+			return slot
+		}
+	}
+	return time.Time{}
+}
+
+func (s slotSchedule) String() string {
+	return "at fixed times"
+}
+
+func TestStandbyJobsSkipTheMissedSlots(t *testing.T) {
+	store := NewMemoryStore()
+	log := &testLog{}
+	first := NewLeaderElection(store, leaderLease, "first", testLeaderTTL, log.logf)
+	first.campaign(context.Background())
+
+	mu, runs := sync.Mutex{}, map[string]int{}
+	count := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return nil
+		}
+	}
+	second := NewLeaderElection(store, leaderLease, "second", testLeaderTTL, log.logf)
+	jobs := NewSupervisor(NewNoopTracer(), log.logf, func(ctx context.Context, message string) {})
+	jobs.UseLeaderElection(second)
+
+	// the slot of the weekly job passes while the first replica is the leader
+	slot := time.Now().Add(testLeaderTTL / 2)
+	weekly := slotSchedule{slot, slot.Add(time.Hour)}
+	for _, job := range []Job{
+		{Name: "weekly", Schedule: weekly, Singleton: true, Run: count("weekly")},
+		{Name: "poll", Schedule: Every(time.Hour), Immediate: true, Singleton: true, Run: count("poll")},
+	} {
+		if err := jobs.Register(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jobs.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(testLeaderTTL)
+	first.release()
+
+	if !waitFor(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs["poll"] > 0
+	}) {
+		t.Fatal("the second replica didn't run the interval job once it became the leader")
+	}
+	time.Sleep(testLeaderTTL)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["weekly"] != 0 {
+		t.Errorf("the new leader ran the weekly job %d times after its slot passed", runs["weekly"])
+	}
+}
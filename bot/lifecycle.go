@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+type (
+	shutdownHook struct {
+		name string
+		fn   func(ctx context.Context) error
+	}
+
+	// Lifecycle runs the background loops of the bot and stops them in order
+	// when the process is asked to terminate
+	Lifecycle struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+		logf   Logger
+
+		wg sync.WaitGroup
+
+		mu    sync.Mutex
+		hooks []shutdownHook
+		err   error
+	}
+)
+
+// NewLifecycle creates a Lifecycle with a root context derived from parent
+func NewLifecycle(parent context.Context, log Logger) *Lifecycle {
+	ctx, cancel := context.WithCancel(parent)
+	return &Lifecycle{
+		ctx:    ctx,
+		cancel: cancel,
+		logf:   log,
+	}
+}
+
+// Context is cancelled when the shutdown starts
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Go runs a background loop, fn must return once the context is cancelled
+func (l *Lifecycle) Go(name string, fn func(ctx context.Context)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn(l.ctx)
+		if l.ctx.Err() == nil {
+			l.logf("%s stopped before the shutdown\n", name)
+		}
+	}()
+}
+
+// OnShutdown registers a function called during the shutdown, after the
+// background loops stopped. Hooks are called in the reverse order of their
+// registration, so what was started last is stopped first.
+func (l *Lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hooks = append(l.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// Stop starts the shutdown, a non-nil err is reported by Wait
+func (l *Lifecycle) Stop(err error) {
+	l.mu.Lock()
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+	l.mu.Unlock()
+
+	l.cancel()
+}
+
+// StopOnSignal starts the shutdown when the process receives one of the signals
+func (l *Lifecycle) StopOnSignal(signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			l.logf("got %s, shutting down\n", sig)
+			l.Stop(nil)
+		case <-l.ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+}
+
+// Wait blocks until the shutdown starts, then stops everything within the
+// timeout and returns the error which caused the shutdown, if any
+func (l *Lifecycle) Wait(timeout time.Duration) error {
+	<-l.ctx.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	loopsDone := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-ctx.Done():
+		l.logf("background loops did not stop in time\n")
+	}
+
+	l.mu.Lock()
+	hooks := append([]shutdownHook{}, l.hooks...)
+	l.mu.Unlock()
+
+	for idx := len(hooks) - 1; idx >= 0; idx-- {
+		if err := hooks[idx].fn(ctx); err != nil {
+			l.logf("failed to stop %s: %v\n", hooks[idx].name, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return fmt.Errorf("shut down because of: %v", l.err)
+	}
+	return nil
+}
+
+// Go runs a handler in the background, Wait waits for it to finish
+func (b *Bot) Go(fn func()) {
+	b.handlers.Add(1)
+	go func() {
+		defer b.handlers.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until the handlers started with Go have finished, or the context is done
+func (b *Bot) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("handlers still running: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,463 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Roles known by the bot itself, more can be added in the configuration
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleCLCurator = "cl-curator"
+)
+
+const (
+	userGroupCacheTTL   = 5 * time.Minute
+	auditLogDefaultSize = 20
+	auditLogMaxSize     = 100
+)
+
+type (
+	// RoleConfig lists who holds a role through the configuration file
+	RoleConfig struct {
+		// Users are user handles, or user IDs
+		Users []string `json:"users"`
+		// Channels are names of configured channels, everyone writing in them holds the role
+		Channels []string `json:"channels"`
+		// UserGroups are handles of user groups, all their members hold the role
+		UserGroups []string `json:"user_groups"`
+	}
+
+	// roleHolders is a RoleConfig resolved to Slack IDs
+	roleHolders struct {
+		users      map[string]bool
+		channels   map[string]bool
+		userGroups []string
+	}
+
+	cachedUserGroup struct {
+		members   map[string]bool
+		fetchedAt time.Time
+	}
+
+	// roleSet are the roles held by someone, in some channel
+	roleSet map[string]bool
+)
+
+var (
+	builtinRoles = []string{RoleAdmin, RoleModerator, RoleCLCurator}
+
+	roleNameRE         = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	userMentionRE      = regexp.MustCompile(`^<@([UW][0-9A-Z]+)(\|[^>]*)?>$`)
+	channelMentionRE   = regexp.MustCompile(`^<#([CG][0-9A-Z]+)(\|[^>]*)?>$`)
+	userGroupMentionRE = regexp.MustCompile(`^<!subteam\^[0-9A-Z]+\|@?([^>]+)>$`)
+)
+
+// allow says if the role is enough to use something which needs the given role
+func (roles roleSet) allow(role string) bool {
+	return role == "" || roles[RoleAdmin] || roles[role]
+}
+
+// resolveRoles turns the configured role holders into Slack IDs
+func (b *Bot) resolveRoles(ctx context.Context, cfg *Config, channels map[string]slackChan, directory *userDirectory, span Span) (map[string]roleHolders, error) {
+	childSpan := span.NewChild("b.resolveRoles")
+	defer childSpan.Finish()
+
+	roles := map[string]roleHolders{}
+	for role, config := range cfg.Roles {
+		holders := roleHolders{
+			users:    map[string]bool{},
+			channels: map[string]bool{},
+		}
+
+		for _, handle := range config.Users {
+			id, err := directory.resolve(ctx, handle)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve the %s %q: %v", role, handle, err)
+			}
+			holders.users[id] = true
+		}
+
+		for _, name := range config.Channels {
+			id := strings.TrimPrefix(channels[strings.ToLower(name)].slackID, "#")
+			if id != "" {
+				holders.channels[id] = true
+			}
+		}
+
+		// user groups are checked when needed, so membership changes apply without a reload
+		for _, group := range config.UserGroups {
+			holders.userGroups = append(holders.userGroups, strings.TrimPrefix(group, "@"))
+		}
+
+		roles[role] = holders
+	}
+
+	return roles, nil
+}
+
+// inUserGroup says if the user is a member of the user group with the given handle
+func (b *Bot) inUserGroup(ctx context.Context, handle, userID string) bool {
+	b.userGroupsMu.Lock()
+	defer b.userGroupsMu.Unlock()
+
+	group, ok := b.userGroups[handle]
+	if !ok || time.Since(group.fetchedAt) > userGroupCacheTTL {
+		members, err := b.chat.UserGroupMembers(ctx, handle)
+		if err != nil {
+			b.logf("could not get the members of the user group %q: %v\n", handle, err)
+			return false
+		}
+
+		group = cachedUserGroup{members: map[string]bool{}, fetchedAt: time.Now()}
+		for _, id := range members {
+			group.members[id] = true
+		}
+		if b.userGroups == nil {
+			b.userGroups = map[string]cachedUserGroup{}
+		}
+		b.userGroups[handle] = group
+	}
+
+	return group.members[userID]
+}
+
+// rolesOf returns the roles held by the user when writing in the channel
+func (b *Bot) rolesOf(ctx context.Context, userID, channelID string) roleSet {
+	span := SpanFromContext(ctx).NewChild("b.rolesOf")
+	defer span.Finish()
+
+	roles := roleSet{}
+	if b.isAdmin(userID) {
+		roles[RoleAdmin] = true
+	}
+
+	b.mu.RLock()
+	configured := b.roles
+	b.mu.RUnlock()
+
+	for role, holders := range configured {
+		if holders.users[userID] || holders.channels[channelID] {
+			roles[role] = true
+			continue
+		}
+		for _, group := range holders.userGroups {
+			if b.inUserGroup(ctx, group, userID) {
+				roles[role] = true
+				break
+			}
+		}
+	}
+
+	grants, err := b.store.Grants(ctx)
+	if err != nil {
+		b.logf("could not load the role grants: %v\n", err)
+		return roles
+	}
+
+	for _, grant := range grants {
+		if roles[grant.Role] {
+			continue
+		}
+
+		switch grant.Kind {
+		case GrantUser:
+			roles[grant.Role] = grant.Subject == userID
+		case GrantChannel:
+			roles[grant.Role] = grant.Subject == channelID
+		case GrantUserGroup:
+			roles[grant.Role] = b.inUserGroup(ctx, grant.Subject, userID)
+		}
+	}
+
+	return roles
+}
+
+// runCommand calls the command handler if the event author is allowed to use it
+func (b *Bot) runCommand(ctx context.Context, cmd *Command, event *slack.MessageEvent) {
+	if !b.authorize(ctx, cmd.Role, cmd.Name, event.User, event.Channel) {
+		params := slack.PostMessageParameters{AsUser: true}
+		_, _, err := b.chat.DirectMessage(ctx, event.User, fmt.Sprintf(`You are not authorized to use %q`, cmd.Name), params)
+		if err != nil {
+			b.logf("%s\n", err)
+		}
+		return
+	}
+
+	cmd.Handler(ctx, b, event)
+}
+
+// authorize checks the role needed by an action and records denied attempts in the audit trail
+func (b *Bot) authorize(ctx context.Context, role, action, userID, channelID string) bool {
+	if role == "" || b.rolesOf(ctx, userID, channelID).allow(role) {
+		return true
+	}
+
+	b.logf("%s tried to use %q in %s without the %s role\n", userID, action, channelID, role)
+	b.audit(ctx, AuditEntry{
+		User:    userID,
+		Channel: channelID,
+		Action:  action,
+		Details: "missing the " + role + " role",
+	})
+
+	return false
+}
+
+func (b *Bot) audit(ctx context.Context, entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if err := b.store.AddAuditEntry(ctx, entry); err != nil {
+		b.logf("could not write the audit entry %#v: %v\n", entry, err)
+	}
+}
+
+// knownRoles returns the built-in roles, the configured ones and those needed by commands
+func (b *Bot) knownRoles() []string {
+	known := map[string]bool{}
+	for _, role := range builtinRoles {
+		known[role] = true
+	}
+
+	b.mu.RLock()
+	for role := range b.roles {
+		known[role] = true
+	}
+	b.mu.RUnlock()
+
+	for _, cmd := range b.commands.Commands() {
+		if cmd.Role != "" {
+			known[cmd.Role] = true
+		}
+	}
+
+	roles := make([]string, 0, len(known))
+	for role := range known {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func (b *Bot) isKnownRole(role string) bool {
+	for _, known := range b.knownRoles() {
+		if known == role {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGrantSubject reads the user, channel or user group mentioned in a message
+func parseGrantSubject(mention string) (kind, subject string, ok bool) {
+	if match := userMentionRE.FindStringSubmatch(mention); match != nil {
+		return GrantUser, match[1], true
+	}
+	if match := channelMentionRE.FindStringSubmatch(mention); match != nil {
+		return GrantChannel, match[1], true
+	}
+	if match := userGroupMentionRE.FindStringSubmatch(mention); match != nil {
+		return GrantUserGroup, match[1], true
+	}
+	return "", "", false
+}
+
+func (grant Grant) subjectText() string {
+	switch grant.Kind {
+	case GrantUser:
+		return "<@" + grant.Subject + ">"
+	case GrantChannel:
+		return "<#" + grant.Subject + ">"
+	}
+	return "@" + grant.Subject
+}
+
+func channelText(channelID string) string {
+	// Direct message channels always starts with 'D'
+	if strings.HasPrefix(channelID, "D") {
+		return "a direct message"
+	}
+	return "<#" + channelID + ">"
+}
+
+func (b *Bot) directReply(ctx context.Context, event *slack.MessageEvent, response string) {
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err := b.chat.DirectMessage(ctx, event.User, response, params)
+	if err != nil {
+		b.logf("%s\n", err)
+	}
+}
+
+// parseGrantCommand reads "<role> <separator> <mention>"
+func (b *Bot) parseGrantCommand(event *slack.MessageEvent, name, separator string) (Grant, string) {
+	usage := fmt.Sprintf(`Usage: "%s <role> %s <@user | #channel | @user-group>"`, name, separator)
+
+	fields := strings.Fields(b.arguments(event, name))
+	if len(fields) != 3 || strings.ToLower(fields[1]) != separator {
+		return Grant{}, usage
+	}
+
+	role := strings.ToLower(fields[0])
+	if !b.isKnownRole(role) {
+		return Grant{}, fmt.Sprintf(`Unknown role %q, the known roles are: %s`, role, strings.Join(b.knownRoles(), ", "))
+	}
+
+	kind, subject, ok := parseGrantSubject(fields[2])
+	if !ok {
+		return Grant{}, usage
+	}
+
+	return Grant{Role: role, Kind: kind, Subject: subject}, ""
+}
+
+func grantRole(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	grant, problem := b.parseGrantCommand(event, "grant", "to")
+	if problem != "" {
+		b.directReply(ctx, event, problem)
+		return
+	}
+
+	grant.GrantedBy = event.User
+	grant.GrantedAt = time.Now()
+	if err := b.store.SaveGrant(ctx, grant); err != nil {
+		b.logf("could not save the grant %#v: %v\n", grant, err)
+		b.directReply(ctx, event, `Could not grant the role, please try again`)
+		return
+	}
+
+	b.audit(ctx, AuditEntry{
+		Time:    grant.GrantedAt,
+		User:    event.User,
+		Channel: event.Channel,
+		Action:  "grant",
+		Allowed: true,
+		Details: grant.Role + " to " + grant.subjectText(),
+	})
+	b.directReply(ctx, event, fmt.Sprintf(`Granted the %s role to %s`, grant.Role, grant.subjectText()))
+}
+
+func revokeRole(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	grant, problem := b.parseGrantCommand(event, "revoke", "from")
+	if problem != "" {
+		b.directReply(ctx, event, problem)
+		return
+	}
+
+	err := b.store.DeleteGrant(ctx, grant)
+	if err == ErrGrantNotFound {
+		b.directReply(ctx, event, fmt.Sprintf(`The %s role was not granted to %s, roles given in the configuration file can only be removed there`, grant.Role, grant.subjectText()))
+		return
+	}
+	if err != nil {
+		b.logf("could not delete the grant %#v: %v\n", grant, err)
+		b.directReply(ctx, event, `Could not revoke the role, please try again`)
+		return
+	}
+
+	b.audit(ctx, AuditEntry{
+		User:    event.User,
+		Channel: event.Channel,
+		Action:  "revoke",
+		Allowed: true,
+		Details: grant.Role + " from " + grant.subjectText(),
+	})
+	b.directReply(ctx, event, fmt.Sprintf(`Revoked the %s role from %s`, grant.Role, grant.subjectText()))
+}
+
+func listRoles(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	holders := map[string][]string{}
+
+	for _, id := range b.adminIDs() {
+		holders[RoleAdmin] = append(holders[RoleAdmin], "<@"+id+"> (configuration)")
+	}
+
+	b.mu.RLock()
+	for role, configured := range b.roles {
+		for id := range configured.users {
+			holders[role] = append(holders[role], "<@"+id+"> (configuration)")
+		}
+		for id := range configured.channels {
+			holders[role] = append(holders[role], "<#"+id+"> (configuration)")
+		}
+		for _, group := range configured.userGroups {
+			holders[role] = append(holders[role], "@"+group+" (configuration)")
+		}
+	}
+	b.mu.RUnlock()
+
+	grants, err := b.store.Grants(ctx)
+	if err != nil {
+		b.logf("could not load the role grants: %v\n", err)
+		b.directReply(ctx, event, `Could not load the granted roles, please try again`)
+		return
+	}
+	for _, grant := range grants {
+		holders[grant.Role] = append(holders[grant.Role], fmt.Sprintf("%s (granted by <@%s> on %s)", grant.subjectText(), grant.GrantedBy, grant.GrantedAt.Format("2006-01-02")))
+	}
+
+	buff := &bytes.Buffer{}
+	buff.WriteString("Here's who holds which role")
+	for _, role := range b.knownRoles() {
+		sort.Strings(holders[role])
+
+		buff.WriteString("\n\n*" + role + "*")
+		if len(holders[role]) == 0 {
+			buff.WriteString("\nnobody")
+		}
+		for _, holder := range holders[role] {
+			buff.WriteString("\n- " + holder)
+		}
+	}
+
+	b.directReply(ctx, event, buff.String())
+}
+
+func auditLog(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	limit := auditLogDefaultSize
+	if arg := b.arguments(event, "audit log"); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			b.directReply(ctx, event, `Usage: "audit log [<number of entries>]"`)
+			return
+		}
+		limit = n
+	}
+	if limit > auditLogMaxSize {
+		limit = auditLogMaxSize
+	}
+
+	entries, err := b.store.AuditEntries(ctx, limit)
+	if err != nil {
+		b.logf("could not load the audit trail: %v\n", err)
+		b.directReply(ctx, event, `Could not load the audit log, please try again`)
+		return
+	}
+	if len(entries) == 0 {
+		b.directReply(ctx, event, `The audit log is empty`)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	buff.WriteString("Here are the most recent audit entries")
+	for _, entry := range entries {
+		outcome := "denied"
+		if entry.Allowed {
+			outcome = "allowed"
+		}
+		buff.WriteString(fmt.Sprintf("\n- %s <@%s> %q in %s, %s: %s",
+			entry.Time.UTC().Format("2006-01-02 15:04 MST"), entry.User, entry.Action, channelText(entry.Channel), outcome, entry.Details))
+	}
+
+	b.directReply(ctx, event, buff.String())
+}
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// newRolesBot returns a bot where alice, everyone writing in gotimefm and
+// the members of the curators user group are CL curators
+func newRolesBot(t *testing.T) (*Bot, *FakeMessenger) {
+	b, chat, _ := newTestBot(t, NewMemoryStore(), "")
+	chat.UserList = append(chat.UserList, ChatUser{ID: "UALICE", Name: "alice"})
+	chat.UserGroups["curators"] = []string{"UCAROL"}
+	chat.UserGroups["mods"] = []string{"UERIN"}
+
+	b.mu.RLock()
+	cfg := *b.config
+	b.mu.RUnlock()
+	cfg.Roles = map[string]RoleConfig{
+		RoleCLCurator: {Users: []string{"alice"}, Channels: []string{"gotimefm"}, UserGroups: []string{"@curators"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ApplyConfig(context.Background(), &cfg, NewNoopTracer().NewSpan("test")); err != nil {
+		t.Fatal(err)
+	}
+	return b, chat
+}
+
+// sendCommand sends the command to the bot in the channel C1
+func sendCommand(b *Bot, user, text string) {
+	b.HandleMessage(&slack.MessageEvent{Msg: slack.Msg{User: user, Channel: "C1", Text: "gopher " + text}})
+}
+
+// lastReply returns the last direct message sent to the user
+func lastReply(t *testing.T, chat *FakeMessenger, user string) string {
+	t.Helper()
+	messages := chat.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Direct && messages[i].Channel == user {
+			return messages[i].Text
+		}
+	}
+	t.Fatalf("nothing was sent to %s", user)
+	return ""
+}
+
+func TestRolesOf(t *testing.T) {
+	b, _ := newRolesBot(t)
+	ctx := context.Background()
+
+	grants := []Grant{
+		{Role: RoleModerator, Kind: GrantUser, Subject: "UDAVE"},
+		{Role: RoleModerator, Kind: GrantChannel, Subject: "CMODS"},
+		{Role: RoleModerator, Kind: GrantUserGroup, Subject: "mods"},
+		{Role: RoleCLCurator, Kind: GrantUser, Subject: "UDAVE"},
+	}
+	for _, grant := range grants {
+		if err := b.store.SaveGrant(ctx, grant); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		channel string
+		roles   []string
+	}{
+		{name: "admin", user: "UADMIN", channel: "C1", roles: []string{RoleAdmin, RoleModerator, RoleCLCurator}},
+		{name: "configured user", user: "UALICE", channel: "C1", roles: []string{RoleCLCurator}},
+		{name: "configured channel", user: "UBOB", channel: "gotimefm", roles: []string{RoleCLCurator}},
+		{name: "configured user group", user: "UCAROL", channel: "C1", roles: []string{RoleCLCurator}},
+		{name: "granted to the user", user: "UDAVE", channel: "C1", roles: []string{RoleModerator, RoleCLCurator}},
+		{name: "granted to the channel", user: "UBOB", channel: "CMODS", roles: []string{RoleModerator}},
+		{name: "granted to the user group", user: "UERIN", channel: "C1", roles: []string{RoleModerator}},
+		{name: "nobody", user: "UBOB", channel: "C1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roles := b.rolesOf(ctx, test.user, test.channel)
+			held := map[string]bool{}
+			for _, role := range test.roles {
+				held[role] = true
+			}
+			for _, role := range builtinRoles {
+				if roles.allow(role) != held[role] {
+					t.Errorf("allow(%q) is %v, want %v", role, roles.allow(role), held[role])
+				}
+			}
+			if !roles.allow("") {
+				t.Error("a command without a role is not allowed")
+			}
+		})
+	}
+}
+
+func TestGrantAndRevokeRole(t *testing.T) {
+	b, chat := newRolesBot(t)
+	ctx := context.Background()
+
+	sendCommand(b, "UADMIN", "grant moderator to <@UDAVE>")
+	if reply := lastReply(t, chat, "UADMIN"); reply != "Granted the moderator role to <@UDAVE>" {
+		t.Errorf("got the reply %q", reply)
+	}
+	if !b.rolesOf(ctx, "UDAVE", "C1").allow(RoleModerator) {
+		t.Error("the role was not granted")
+	}
+
+	sendCommand(b, "UADMIN", "revoke moderator from <@UDAVE>")
+	if reply := lastReply(t, chat, "UADMIN"); reply != "Revoked the moderator role from <@UDAVE>" {
+		t.Errorf("got the reply %q", reply)
+	}
+	if b.rolesOf(ctx, "UDAVE", "C1").allow(RoleModerator) {
+		t.Error("the role was not revoked")
+	}
+
+	entries, err := b.store.AuditEntries(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuditEntry{
+		{User: "UADMIN", Channel: "C1", Action: "revoke", Allowed: true, Details: "moderator from <@UDAVE>"},
+		{User: "UADMIN", Channel: "C1", Action: "grant", Allowed: true, Details: "moderator to <@UDAVE>"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got the audit entries %+v", entries)
+	}
+	for i, entry := range entries {
+		if entry.Time.IsZero() {
+			t.Errorf("the audit entry %+v has no time", entry)
+		}
+		entry.Time = want[i].Time
+		if entry != want[i] {
+			t.Errorf("got the audit entry %+v, want %+v", entry, want[i])
+		}
+	}
+
+	tests := []struct {
+		command string
+		reply   string
+	}{
+		{command: "revoke moderator from <@UDAVE>", reply: "The moderator role was not granted to <@UDAVE>"},
+		{command: "revoke cl-curator from <@UALICE>", reply: "roles given in the configuration file can only be removed there"},
+		{command: "grant owner to <@UDAVE>", reply: `Unknown role "owner"`},
+		{command: "grant moderator <@UDAVE>", reply: "Usage:"},
+		{command: "grant moderator to dave", reply: "Usage:"},
+	}
+	for _, test := range tests {
+		sendCommand(b, "UADMIN", test.command)
+		if reply := lastReply(t, chat, "UADMIN"); !strings.Contains(reply, test.reply) {
+			t.Errorf("%q got the reply %q, want %q", test.command, reply, test.reply)
+		}
+	}
+	if entries, _ := b.store.AuditEntries(ctx, 10); len(entries) != len(want) {
+		t.Errorf("the failed commands were audited: %+v", entries)
+	}
+}
+
+func TestGrantRoleSubjects(t *testing.T) {
+	b, _ := newRolesBot(t)
+	ctx := context.Background()
+
+	sendCommand(b, "UADMIN", "grant moderator to <#CMODS|mods>")
+	sendCommand(b, "UADMIN", "grant moderator to <!subteam^S1|@mods>")
+
+	grants, err := b.store.Grants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects := map[string]string{}
+	for _, grant := range grants {
+		if grant.GrantedBy != "UADMIN" || grant.GrantedAt.IsZero() {
+			t.Errorf("the grant %+v does not say who made it, and when", grant)
+		}
+		subjects[grant.Kind] = grant.Subject
+	}
+	if len(grants) != 2 || subjects[GrantChannel] != "CMODS" || subjects[GrantUserGroup] != "mods" {
+		t.Errorf("got the grants %+v", grants)
+	}
+
+	if !b.rolesOf(ctx, "UBOB", "CMODS").allow(RoleModerator) || !b.rolesOf(ctx, "UERIN", "C1").allow(RoleModerator) {
+		t.Error("the granted roles are not held")
+	}
+}
+
+func TestAuthorizeDenies(t *testing.T) {
+	b, chat := newRolesBot(t)
+	ctx := context.Background()
+
+	sendCommand(b, "UALICE", "grant cl-curator to <@UDAVE>")
+	if reply := lastReply(t, chat, "UALICE"); reply != `You are not authorized to use "grant"` {
+		t.Errorf("got the reply %q", reply)
+	}
+	if grants, _ := b.store.Grants(ctx); len(grants) != 0 {
+		t.Errorf("the role was granted: %+v", grants)
+	}
+
+	entries, err := b.store.AuditEntries(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AuditEntry{User: "UALICE", Channel: "C1", Action: "grant", Details: "missing the admin role"}
+	if len(entries) != 1 {
+		t.Fatalf("got the audit entries %+v", entries)
+	}
+	if entry := entries[0]; entry.Time.IsZero() {
+		t.Errorf("the audit entry %+v has no time", entry)
+	} else if entry.Time = want.Time; entry != want {
+		t.Errorf("got the audit entry %+v, want %+v", entry, want)
+	}
+
+	if !b.authorize(ctx, RoleCLCurator, "share cl", "UALICE", "C1") {
+		t.Error("alice can't use what needs her role")
+	}
+	if !b.authorize(ctx, "", "help", "UBOB", "C1") {
+		t.Error("bob can't use what needs no role")
+	}
+	if entries, _ := b.store.AuditEntries(ctx, 10); len(entries) != 1 {
+		t.Errorf("the allowed actions were audited: %+v", entries)
+	}
+}
+
+func TestGrantAdminRole(t *testing.T) {
+	b, chat := newRolesBot(t)
+	ctx := context.Background()
+
+	// admins can make more admins from the chat, who then hold every role
+	sendCommand(b, "UADMIN", "grant admin to <@UDAVE>")
+	roles := b.rolesOf(ctx, "UDAVE", "C1")
+	for _, role := range builtinRoles {
+		if !roles.allow(role) {
+			t.Errorf("the granted admin can't use what needs the %s role", role)
+		}
+	}
+
+	sendCommand(b, "UDAVE", "grant moderator to <@UERIN>")
+	if reply := lastReply(t, chat, "UDAVE"); reply != "Granted the moderator role to <@UERIN>" {
+		t.Errorf("got the reply %q", reply)
+	}
+
+	sendCommand(b, "UADMIN", "revoke admin from <@UDAVE>")
+	sendCommand(b, "UDAVE", "revoke moderator from <@UERIN>")
+	if reply := lastReply(t, chat, "UDAVE"); reply != `You are not authorized to use "revoke"` {
+		t.Errorf("got the reply %q", reply)
+	}
+	if !b.rolesOf(ctx, "UERIN", "C1").allow(RoleModerator) {
+		t.Error("the revoked admin could still revoke a role")
+	}
+}
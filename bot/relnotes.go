@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// ReleaseCycle is the development cycle of a Go release
+	ReleaseCycle struct {
+		Version string `json:"version"`
+		// Start and End are dates such as 2018-02-01, the current cycle has no End
+		Start string `json:"start"`
+		End   string `json:"end"`
+	}
+
+	// RelNotesConfig lists the development cycles the release notes are collected for
+	RelNotesConfig struct {
+		Cycles []ReleaseCycle `json:"cycles"`
+	}
+
+	relNotes struct {
+		cycle ReleaseCycle
+		cls   []StoredCL
+	}
+)
+
+// maxRelNotesInChat is how many CLs are listed in the chat, beyond that only
+// the packages are, and the Markdown export has everything
+const maxRelNotesInChat = 50
+
+const relNotesDateLayout = "2006-01-02"
+
+// validate checks the dates of the cycles
+func (cfg RelNotesConfig) validate() []string {
+	problems := []string{}
+	versions := map[string]bool{}
+	current := 0
+
+	for idx, cycle := range cfg.Cycles {
+		name := cycle.Version
+		if name == "" {
+			name = fmt.Sprintf("#%d", idx+1)
+			problems = append(problems, fmt.Sprintf("release cycle %s has no version", name))
+		}
+		if versions[strings.ToLower(cycle.Version)] {
+			problems = append(problems, fmt.Sprintf("release cycle %s is listed twice", name))
+		}
+		versions[strings.ToLower(cycle.Version)] = true
+
+		start, err := time.Parse(relNotesDateLayout, cycle.Start)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("release cycle %s has the invalid start %q, use YYYY-MM-DD", name, cycle.Start))
+		}
+		if cycle.End == "" {
+			current++
+			continue
+		}
+		end, err := time.Parse(relNotesDateLayout, cycle.End)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("release cycle %s has the invalid end %q, use YYYY-MM-DD", name, cycle.End))
+		} else if !end.After(start) {
+			problems = append(problems, fmt.Sprintf("release cycle %s ends before it starts", name))
+		}
+	}
+
+	if current > 1 {
+		problems = append(problems, "only one release cycle can have no end")
+	}
+	return problems
+}
+
+// period returns when the cycle starts and ends, now for the current cycle
+func (cycle ReleaseCycle) period(now time.Time) (time.Time, time.Time, error) {
+	start, err := time.Parse(relNotesDateLayout, cycle.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if cycle.End == "" {
+		return start, now, nil
+	}
+	end, err := time.Parse(relNotesDateLayout, cycle.End)
+	return start, end, err
+}
+
+// cycle returns the cycle of the version, or the current one when the version is empty
+func (cfg RelNotesConfig) cycle(version string) (ReleaseCycle, bool) {
+	var found ReleaseCycle
+	ok := false
+	for _, cycle := range cfg.Cycles {
+		if version != "" && strings.EqualFold(cycle.Version, version) {
+			return cycle, true
+		}
+		// without an open cycle, the one which started last is the current one
+		if version == "" && (!ok || (found.End != "" && (cycle.End == "" || cycle.Start > found.Start))) {
+			found, ok = cycle, true
+		}
+	}
+	return found, ok
+}
+
+func (cfg RelNotesConfig) versions() []string {
+	versions := []string{}
+	for _, cycle := range cfg.Cycles {
+		versions = append(versions, cycle.Version)
+	}
+	return versions
+}
+
+// collectRelNotes returns the CLs of the master branch marked RELNOTE during the cycle
+func (b *Bot) collectRelNotes(ctx context.Context, cycle ReleaseCycle) (*relNotes, error) {
+	since, until, err := cycle.period(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := b.store.RelNoteCLs(ctx, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	notes := &relNotes{cycle: cycle}
+	for _, cl := range stored {
+		// the cherry-picks are in the notes of their point release
+		if cl.Branch == "" || cl.Branch == "master" {
+			notes.cls = append(notes.cls, cl)
+		}
+	}
+	return notes, nil
+}
+
+func (notes *relNotes) periodText() string {
+	end := "now"
+	if notes.cycle.End != "" {
+		end = notes.cycle.End
+	}
+	return fmt.Sprintf("from %s to %s", notes.cycle.Start, end)
+}
+
+// relNoteText returns the text of the note, nothing when it only says yes
+func relNoteText(cl StoredCL) string {
+	switch strings.ToLower(cl.RelNote) {
+	case "yes", "y", "true":
+		return ""
+	}
+	return cl.RelNote
+}
+
+// text lists the CLs by project and package for the chat
+func (notes *relNotes) text() string {
+	if len(notes.cls) == 0 {
+		return fmt.Sprintf("No CL is marked RELNOTE for %s %s", notes.cycle.Version, notes.periodText())
+	}
+
+	buff := &bytes.Buffer{}
+	buff.WriteString(fmt.Sprintf("*Release notes for %s*, %s marked RELNOTE %s\n",
+		notes.cycle.Version, plural(len(notes.cls), "CL"), notes.periodText()))
+
+	digest := &clDigest{cls: notes.cls}
+	for _, project := range digest.groups() {
+		buff.WriteString(fmt.Sprintf("\n*%s*\n", project.name))
+		for _, pkg := range project.packages() {
+			if len(notes.cls) > maxRelNotesInChat {
+				buff.WriteString(fmt.Sprintf("• `%s` %d\n", pkg.name, len(pkg.cls)))
+				continue
+			}
+			buff.WriteString(fmt.Sprintf("• `%s`\n", pkg.name))
+			for _, cl := range pkg.cls {
+				buff.WriteString(fmt.Sprintf("    <%s|CL %d> %s", cl.URL, cl.Number, escapeMrkdwn(digestSubject(cl))))
+				if note := relNoteText(cl); note != "" {
+					buff.WriteString(": _" + escapeMrkdwn(strings.Replace(note, "\n", " ", -1)) + "_")
+				}
+				buff.WriteString("\n")
+			}
+		}
+	}
+
+	if len(notes.cls) > maxRelNotesInChat {
+		buff.WriteString(fmt.Sprintf("\nUse `relnotes %s markdown` to get every CL", notes.cycle.Version))
+	}
+	return strings.TrimSpace(buff.String())
+}
+
+// markdown renders the notes as a draft for the release notes
+func (notes *relNotes) markdown() string {
+	buff := &bytes.Buffer{}
+	buff.WriteString(fmt.Sprintf("# Release notes for %s\n\n", notes.cycle.Version))
+	buff.WriteString(fmt.Sprintf("%s marked RELNOTE %s.\n", plural(len(notes.cls), "CL"), notes.periodText()))
+
+	digest := &clDigest{cls: notes.cls}
+	for _, project := range digest.groups() {
+		buff.WriteString(fmt.Sprintf("\n## %s\n", project.name))
+		for _, pkg := range project.packages() {
+			buff.WriteString(fmt.Sprintf("\n### %s\n\n", pkg.name))
+			for _, cl := range pkg.cls {
+				buff.WriteString(fmt.Sprintf("- [CL %d](%s): %s\n", cl.Number, cl.URL, digestSubject(cl)))
+				if note := relNoteText(cl); note != "" {
+					buff.WriteString("  " + strings.Replace(note, "\n", "\n  ", -1) + "\n")
+				}
+			}
+		}
+	}
+
+	return buff.String()
+}
+
+func relNotesCommand(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	usage := `Usage: "relnotes [<version>] [markdown]"`
+
+	version, export := "", false
+	for _, arg := range strings.Fields(b.arguments(event, "relnotes")) {
+		switch {
+		case strings.EqualFold(arg, "markdown") || strings.EqualFold(arg, "md"):
+			export = true
+		case version == "":
+			version = arg
+		default:
+			b.directReply(ctx, event, usage)
+			return
+		}
+	}
+
+	b.mu.RLock()
+	cfg := b.config.RelNotes
+	b.mu.RUnlock()
+
+	cycle, ok := cfg.cycle(version)
+	if !ok {
+		if len(cfg.Cycles) == 0 {
+			b.directReply(ctx, event, `No release cycle is configured`)
+			return
+		}
+		b.directReply(ctx, event, fmt.Sprintf("Unknown version %q, use one of %s\n%s", version, strings.Join(cfg.versions(), ", "), usage))
+		return
+	}
+
+	notes, err := b.collectRelNotes(ctx, cycle)
+	if err != nil {
+		b.logf("could not collect the release notes of %s: %v\n", cycle.Version, err)
+		b.directReply(ctx, event, `Could not collect the release notes, please try again`)
+		return
+	}
+
+	if !export {
+		b.directReply(ctx, event, notes.text())
+		return
+	}
+
+	err = b.chat.UploadFile(ctx, event.Channel, ChatFile{
+		Name:    fmt.Sprintf("relnotes-%s.md", cycle.Version),
+		Title:   fmt.Sprintf("Release notes for %s", cycle.Version),
+		Type:    "markdown",
+		Content: notes.markdown(),
+	})
+	if err != nil {
+		b.logf("could not upload the release notes of %s: %v\n", cycle.Version, err)
+		b.directReply(ctx, event, `Could not upload the release notes, please try again`)
+	}
+}
@@ -0,0 +1,501 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type (
+	// ReviewsConfig follows the open CLs through their review
+	ReviewsConfig struct {
+		// Enabled starts the watcher, watching CLs from the chat needs it
+		Enabled bool `json:"enabled"`
+		// Channel gets the new CLs, they are not announced when empty
+		Channel string `json:"channel"`
+		// Projects limits the announced CLs to some Gerrit projects, or patterns
+		Projects []string `json:"projects"`
+		// Events are the updates posted in the threads of the CLs, all of them when empty
+		Events []string `json:"events"`
+	}
+
+	reviewEvent struct {
+		kind string
+		text string
+	}
+)
+
+// Updates of a CL under review
+const (
+	ReviewPatchSet   = "patch_set"
+	ReviewCodeReview = "code_review"
+	ReviewTryBot     = "trybot"
+	ReviewMerged     = "merged"
+	ReviewAbandoned  = "abandoned"
+)
+
+const (
+	reviewsInterval = time.Minute
+
+	codeReviewLabel = "Code-Review"
+	tryBotLabel     = "TryBot-Result"
+)
+
+var (
+	reviewEvents = []string{ReviewPatchSet, ReviewCodeReview, ReviewTryBot, ReviewMerged, ReviewAbandoned}
+
+	// reviewOptions ask Gerrit for the current patch set, the votes and the names of the accounts
+	reviewOptions = []string{"CURRENT_REVISION", "LABELS", "DETAILED_ACCOUNTS"}
+
+	errNotWatched = errors.New("cl not watched")
+)
+
+// validate checks the watcher against the configured channels
+func (cfg ReviewsConfig) validate(channels map[string]bool) []string {
+	problems := []string{}
+
+	if cfg.Channel != "" && !channels[strings.ToLower(cfg.Channel)] {
+		problems = append(problems, fmt.Sprintf("new CLs are announced in the unknown channel %q", cfg.Channel))
+	}
+	for _, pattern := range cfg.Projects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("the reviews have the invalid project pattern %q", pattern))
+		}
+	}
+	for _, event := range cfg.Events {
+		known := false
+		for _, kind := range reviewEvents {
+			known = known || event == kind
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown review event %q, use one of %s", event, strings.Join(reviewEvents, ", ")))
+		}
+	}
+
+	return problems
+}
+
+func (cfg ReviewsConfig) posts(kind string) bool {
+	if len(cfg.Events) == 0 {
+		return true
+	}
+	for _, event := range cfg.Events {
+		if event == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) reviewsConfig() ReviewsConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.config.Reviews
+}
+
+func (a gerritAccount) String() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if a.Email != "" {
+		return a.Email
+	}
+	return "someone"
+}
+
+func (cl *gerritCL) patchSet() int {
+	return cl.Revisions[cl.CurrentRevision].Number
+}
+
+// tryBotResult is "passed" or "failed" once the TryBots voted on the current patch set
+func (cl *gerritCL) tryBotResult() string {
+	label := cl.Labels[tryBotLabel]
+	switch {
+	case label.Rejected != nil:
+		return "failed"
+	case label.Approved != nil:
+		return "passed"
+	}
+	return ""
+}
+
+// record remembers the state of the review, so only the changes are posted
+func (w *WatchedCL) record(cl gerritCL) {
+	w.Subject = cl.Subject
+	w.Status = cl.Status
+	w.PatchSet = cl.patchSet()
+	w.Approved = cl.Labels[codeReviewLabel].Approved != nil
+	if result := cl.tryBotResult(); result != "" {
+		w.TryBotResult, w.TryBotPatchSet = result, w.PatchSet
+	}
+	if updated, err := parseGerritTime(cl.Updated); err == nil {
+		w.UpdatedAt = updated
+	}
+}
+
+// events returns what happened to the CL since its state was recorded
+func (w *WatchedCL) events(cl gerritCL) []reviewEvent {
+	events := []reviewEvent{}
+
+	revision := cl.Revisions[cl.CurrentRevision]
+	if revision.Number > w.PatchSet {
+		events = append(events, reviewEvent{ReviewPatchSet, fmt.Sprintf("Patch set %d uploaded by %s", revision.Number, revision.Uploader)})
+	}
+	if approved := cl.Labels[codeReviewLabel].Approved; approved != nil && !w.Approved {
+		events = append(events, reviewEvent{ReviewCodeReview, fmt.Sprintf("Code-Review +2 by %s", approved)})
+	}
+	if result := cl.tryBotResult(); result != "" && (result != w.TryBotResult || revision.Number != w.TryBotPatchSet) {
+		events = append(events, reviewEvent{ReviewTryBot, fmt.Sprintf("TryBots %s on patch set %d", result, revision.Number)})
+	}
+
+	switch cl.Status {
+	case gerritMerged:
+		events = append(events, reviewEvent{ReviewMerged, "Merged, I stop following it"})
+	case gerritAbandoned:
+		events = append(events, reviewEvent{ReviewAbandoned, "Abandoned, I stop following it"})
+	}
+
+	return events
+}
+
+// fetchCL fetches a CL with the options, or returns errGerritNotFound
+func (b *Bot) fetchCL(ctx context.Context, number int, options []string) (gerritCL, error) {
+	cl := gerritCL{}
+	link, err := b.gerritURL("/changes/"+strconv.Itoa(number), url.Values{"o": options})
+	if err != nil {
+		return cl, err
+	}
+	err = b.gerritGet(ctx, link, &cl)
+	return cl, err
+}
+
+// fetchUpdatedCLs fetches the CLs updated since then, most recently updated
+// first. Only the first page is fetched when since is zero.
+func (b *Bot) fetchUpdatedCLs(ctx context.Context, since time.Time) ([]gerritCL, error) {
+	query := url.Values{
+		"q": {"status:open OR status:closed"},
+		"o": reviewOptions,
+		"n": {"100"},
+	}
+
+	cls, more, err := b.gerritPages(ctx, query, func(cl gerritCL) bool {
+		updated, err := parseGerritTime(cl.Updated)
+		return err != nil || updated.Before(since) || since.IsZero()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, cl := range cls {
+		// the CLs updated at the checkpoint are fetched again, as some
+		// may not have been seen, and their state drops the events
+		// which were already posted
+		updated, err := parseGerritTime(cl.Updated)
+		if err != nil {
+			return nil, fmt.Errorf("invalid update time of CL %d: %v", cl.Number, err)
+		}
+		if updated.Before(since) {
+			return cls[:idx], nil
+		}
+	}
+
+	if more {
+		b.logf("gap in the reviews: more than %d CLs were updated since %s, the older updates are skipped\n", len(cls), since.Format(time.RFC3339))
+	}
+	return cls, nil
+}
+
+// pollReviews announces the new CLs and posts the updates of the watched ones
+func (b *Bot) pollReviews(ctx context.Context) error {
+	cfg := b.reviewsConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	checkpoint, err := b.store.ReviewsCheckpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load the reviews checkpoint: %v", err)
+	}
+
+	cls, err := b.fetchUpdatedCLs(ctx, checkpoint)
+	if err != nil {
+		return err
+	}
+	if len(cls) == 0 {
+		return nil
+	}
+
+	// the first run only finds out where Gerrit is
+	if checkpoint.IsZero() {
+		updated, _ := parseGerritTime(cls[0].Updated)
+		return b.store.SaveReviewsCheckpoint(ctx, updated)
+	}
+
+	for idx := len(cls) - 1; idx >= 0; idx-- {
+		cl := cls[idx]
+		if err := b.reviewCL(ctx, cfg, cl, checkpoint); err != nil {
+			return fmt.Errorf("could not follow CL %d: %v", cl.Number, err)
+		}
+
+		updated, _ := parseGerritTime(cl.Updated)
+		if err := b.store.SaveReviewsCheckpoint(ctx, updated); err != nil {
+			return fmt.Errorf("could not save the reviews checkpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// reviewCL posts what happened to a watched CL, or announces a new one
+func (b *Bot) reviewCL(ctx context.Context, cfg ReviewsConfig, cl gerritCL, checkpoint time.Time) error {
+	watched, err := b.store.GetWatchedCL(ctx, cl.Number)
+	if err == ErrWatchedCLNotFound {
+		return b.announceCL(ctx, cfg, cl, checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+
+	events := watched.events(cl)
+	if len(events) == 0 {
+		return nil
+	}
+
+	threads := []CLThread{}
+	_, err = b.store.UpdateWatchedCL(ctx, cl.Number, func(watched *WatchedCL) error {
+		if len(watched.Threads) == 0 {
+			return errNotWatched
+		}
+		threads = append([]CLThread{}, watched.Threads...)
+
+		watched.record(cl)
+		if cl.Status != gerritNew {
+			watched.Threads = nil
+		}
+		return nil
+	})
+	if err == errNotWatched {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if !cfg.posts(event.kind) {
+			continue
+		}
+		for _, thread := range threads {
+			// threads saved without a timestamp would get the updates in the channel
+			if thread.Timestamp == "" {
+				continue
+			}
+			params := slack.PostMessageParameters{AsUser: true, ThreadTimestamp: thread.Timestamp}
+			_, _, err := b.chat.PostMessage(ctx, thread.Channel, event.text, params)
+			if err != nil {
+				b.logf("could not post the update of CL %d in %s: %v\n", cl.Number, thread.Channel, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// announceCL posts a CL created since the checkpoint and follows its review in the thread
+func (b *Bot) announceCL(ctx context.Context, cfg ReviewsConfig, cl gerritCL, checkpoint time.Time) error {
+	if cfg.Channel == "" || cl.Status != gerritNew {
+		return nil
+	}
+	if len(cfg.Projects) > 0 && !matchesName(cfg.Projects, cl.Project) {
+		return nil
+	}
+	if created, err := parseGerritTime(cl.Created); err != nil || !created.After(checkpoint) {
+		return nil
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	channel := strings.TrimPrefix(b.channel(strings.ToLower(cfg.Channel)).slackID, "#")
+	channelID, timestamp, err := b.chat.PostMessage(ctx, channel, fmt.Sprintf("[%d] %s: %s by %s", cl.Number, cl.message(), cl.link(), cl.Owner), params)
+	if err != nil {
+		b.logf("could not announce CL %d: %v\n", cl.Number, err)
+		return nil
+	}
+
+	_, err = b.store.UpdateWatchedCL(ctx, cl.Number, func(watched *WatchedCL) error {
+		watched.record(cl)
+		watched.Threads = append(watched.Threads, CLThread{Channel: channelID, Timestamp: timestamp})
+		return nil
+	})
+	return err
+}
+
+// parseCLNumber reads a CL number, with or without a leading #
+func parseCLNumber(text string) (int, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(text), "#"))
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid CL number %q", text)
+	}
+	return number, nil
+}
+
+func watchCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	if !b.reviewsConfig().Enabled {
+		b.directReply(ctx, event, `Following the CLs under review is not enabled`)
+		return
+	}
+
+	number, err := parseCLNumber(b.arguments(event, "watch cl"))
+	if err != nil {
+		b.directReply(ctx, event, `Usage: "watch cl <number>"`)
+		return
+	}
+
+	cl, err := b.fetchCL(ctx, number, reviewOptions)
+	if err == errGerritNotFound {
+		b.directReply(ctx, event, fmt.Sprintf("There is no CL %d", number))
+		return
+	}
+	if err != nil {
+		b.logf("could not fetch CL %d: %v\n", number, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not watch CL %d, please try again", number))
+		return
+	}
+	if cl.Status != gerritNew {
+		b.directReply(ctx, event, fmt.Sprintf("CL %d is %s, there is nothing to follow", number, strings.ToLower(cl.Status)))
+		return
+	}
+
+	watched, err := b.store.GetWatchedCL(ctx, number)
+	if err != nil && err != ErrWatchedCLNotFound {
+		b.logf("could not load the watched CL %d: %v\n", number, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not watch CL %d, please try again", number))
+		return
+	}
+	// the answers to a slash command have no timestamp to start a thread from
+	threadCtx := withoutSlashResponse(ctx)
+	if err == nil {
+		for _, thread := range watched.Threads {
+			if thread.Channel == event.Channel && thread.Timestamp != "" {
+				params := slack.PostMessageParameters{AsUser: true, ThreadTimestamp: thread.Timestamp}
+				if _, _, err := b.chat.PostMessage(threadCtx, event.Channel, "The updates of the review are posted in this thread", params); err != nil {
+					b.logf("%s\n", err)
+				}
+				return
+			}
+		}
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	text := fmt.Sprintf("Watching [%d] %s: %s at patch set %d, the updates of its review follow in this thread", cl.Number, cl.message(), cl.link(), cl.patchSet())
+	channelID, timestamp, err := b.chat.PostMessage(threadCtx, event.Channel, text, params)
+	if err != nil || timestamp == "" {
+		b.logf("could not start the thread of CL %d in %s: %v\n", number, event.Channel, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not watch CL %d here, please invite me to the channel and try again", number))
+		return
+	}
+
+	_, err = b.store.UpdateWatchedCL(ctx, number, func(watched *WatchedCL) error {
+		if len(watched.Threads) == 0 {
+			watched.record(cl)
+		}
+		watched.Threads = append(watched.Threads, CLThread{Channel: channelID, Timestamp: timestamp, User: event.User})
+		return nil
+	})
+	if err != nil {
+		b.logf("could not watch CL %d: %v\n", number, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not watch CL %d, please try again", number))
+	}
+}
+
+func unwatchCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	number, err := parseCLNumber(b.arguments(event, "unwatch cl"))
+	if err != nil {
+		b.directReply(ctx, event, `Usage: "unwatch cl <number>"`)
+		return
+	}
+
+	watched, err := b.store.GetWatchedCL(ctx, number)
+	if err != nil && err != ErrWatchedCLNotFound {
+		b.logf("could not load the watched CL %d: %v\n", number, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not unwatch CL %d, please try again", number))
+		return
+	}
+
+	var thread *CLThread
+	if err == nil {
+		for idx := range watched.Threads {
+			if watched.Threads[idx].Channel == event.Channel && watched.Threads[idx].User != "" {
+				thread = &watched.Threads[idx]
+				break
+			}
+		}
+	}
+	if thread == nil {
+		b.directReply(ctx, event, fmt.Sprintf("CL %d is not watched here", number))
+		return
+	}
+	if thread.User != event.User && !b.authorize(ctx, RoleModerator, "unwatch cl", event.User, event.Channel) {
+		b.directReply(ctx, event, fmt.Sprintf("Only <@%s> or a moderator can stop watching CL %d here", thread.User, number))
+		return
+	}
+
+	_, err = b.store.UpdateWatchedCL(ctx, number, func(watched *WatchedCL) error {
+		threads := []CLThread{}
+		for _, other := range watched.Threads {
+			if other.Channel != thread.Channel || other.Timestamp != thread.Timestamp {
+				threads = append(threads, other)
+			}
+		}
+		watched.Threads = threads
+		return nil
+	})
+	if err != nil {
+		b.logf("could not unwatch CL %d: %v\n", number, err)
+		b.directReply(ctx, event, fmt.Sprintf("Could not unwatch CL %d, please try again", number))
+		return
+	}
+
+	params := slack.PostMessageParameters{AsUser: true, ThreadTimestamp: thread.Timestamp}
+	if _, _, err := b.chat.PostMessage(ctx, thread.Channel, "Stopped following the review", params); err != nil {
+		b.logf("%s\n", err)
+	}
+}
+
+func watchedCLs(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	cls, err := b.store.WatchedCLs(ctx)
+	if err != nil {
+		b.logf("could not load the watched CLs: %v\n", err)
+		b.directReply(ctx, event, `Could not load the CLs you watch, please try again`)
+		return
+	}
+
+	sort.Slice(cls, func(i, j int) bool {
+		return cls[i].Number < cls[j].Number
+	})
+
+	buff := &bytes.Buffer{}
+	for _, cl := range cls {
+		for _, thread := range cl.Threads {
+			if thread.User == event.User {
+				buff.WriteString(fmt.Sprintf("\n- [%d] %s: https://golang.org/cl/%d/ at patch set %d", cl.Number, cl.Subject, cl.Number, cl.PatchSet))
+				break
+			}
+		}
+	}
+	if buff.Len() == 0 {
+		b.directReply(ctx, event, "You don't watch any CL, use `watch cl <number>` to follow the review of one")
+		return
+	}
+
+	b.directReply(ctx, event, "You watch"+buff.String())
+}
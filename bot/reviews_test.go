@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// setUpdated changes when the CL was last updated
+func setUpdated(gerrit *fakeGerrit, number int, updated string) {
+	gerrit.mu.Lock()
+	defer gerrit.mu.Unlock()
+
+	for idx := range gerrit.changes {
+		if gerrit.changes[idx].Number == number {
+			gerrit.changes[idx].Updated = updated
+		}
+	}
+}
+
+func TestPollReviewsPostsUpdatesAtTheCheckpoint(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	gerrit.Open("go", "master", "net/http: fix the timeouts", "")
+	b, chat, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	b.mu.Lock()
+	b.config.Reviews = ReviewsConfig{Enabled: true, Channel: "golang-cls"}
+	b.mu.Unlock()
+
+	ctx := context.Background()
+	poll := func() {
+		t.Helper()
+		if err := b.pollReviews(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first poll finds the checkpoint, the second announces the new CL
+	poll()
+	gerrit.Open("go", "master", "os: add ReadDir", "")
+	poll()
+
+	checkpoint, err := b.store.ReviewsCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new patch set has the time of the checkpoint, and nothing posted yet
+	gerrit.UploadPatchSet(1001, "Gopher")
+	setUpdated(gerrit, 1001, checkpoint.UTC().Format(gerritTimeLayout))
+	poll()
+	poll()
+
+	announced, patchSets := 0, 0
+	for _, msg := range chat.Messages() {
+		switch {
+		case strings.HasPrefix(msg.Text, "[1001]"):
+			announced++
+		case msg.Params.ThreadTimestamp != "" && strings.HasPrefix(msg.Text, "Patch set 2"):
+			patchSets++
+		}
+	}
+	if announced != 1 || patchSets != 1 {
+		t.Errorf("CL 1001 was announced %d times and its patch set posted %d times, want once", announced, patchSets)
+	}
+}
+
+func TestWatchCLFromASlashCommand(t *testing.T) {
+	gerrit := newFakeGerrit(1000)
+	gerrit.Open("go", "master", "net/http: fix the timeouts", "")
+	b, chat, _ := newTestBot(t, NewMemoryStore(), newGerritServer(t, gerrit, nil))
+
+	b.mu.Lock()
+	b.config.Reviews = ReviewsConfig{Enabled: true}
+	b.mu.Unlock()
+
+	responses := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer responses.Close()
+
+	ctx := context.WithValue(context.Background(), slashResponseKey{}, &slashResponse{
+		url:          responses.URL,
+		responseType: ResponseEphemeral,
+		user:         "UADMIN",
+		channel:      "golang-cls",
+	})
+	event := &slack.MessageEvent{Msg: slack.Msg{User: "UADMIN", Channel: "golang-cls", Text: "watch cl 1000"}}
+	watchCL(ctx, b, event)
+	watchCL(ctx, b, event)
+
+	watched, err := b.store.GetWatchedCL(ctx, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched.Threads) != 1 || watched.Threads[0].Timestamp == "" {
+		t.Fatalf("got the threads %+v, want one with a timestamp", watched.Threads)
+	}
+
+	anchors, replies := 0, 0
+	for _, msg := range chat.Messages() {
+		switch {
+		case msg.Channel == "golang-cls" && msg.Timestamp == watched.Threads[0].Timestamp:
+			anchors++
+		case msg.Params.ThreadTimestamp == watched.Threads[0].Timestamp:
+			replies++
+		}
+	}
+	if anchors != 1 || replies != 1 {
+		t.Errorf("got %d messages starting the thread and %d in it, want one each", anchors, replies)
+	}
+}
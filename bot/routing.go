@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// CLRoute sends the CLs which match it to some channels. Every criterion
+	// which is set must match, and one of its values is enough.
+	CLRoute struct {
+		Name string `json:"name"`
+		// Projects are Gerrit project names, such as "tools"
+		Projects []string `json:"projects"`
+		// Branches are branch names or patterns, such as "release-branch.*"
+		Branches []string `json:"branches"`
+		// SubjectPrefixes match the start of the subject, such as "cmd/compile:"
+		SubjectPrefixes []string `json:"subject_prefixes"`
+		// Paths are directories, or patterns, of the files touched by the CL
+		Paths []string `json:"paths"`
+		// Channels are names of configured channels
+		Channels []string `json:"channels"`
+	}
+
+	// CLRoutingConfig decides where the merged CLs are announced
+	CLRoutingConfig struct {
+		Routes []CLRoute `json:"routes"`
+		// DefaultChannels get the CLs which match no route, golang-cls when empty
+		DefaultChannels []string `json:"default_channels"`
+	}
+)
+
+// defaultCLChannel gets the CLs when no routing is configured
+const defaultCLChannel = "golang-cls"
+
+// validate checks the routes against the configured channels
+func (routing CLRoutingConfig) validate(channels map[string]bool) []string {
+	problems := []string{}
+
+	for _, name := range routing.DefaultChannels {
+		if !channels[strings.ToLower(name)] {
+			problems = append(problems, fmt.Sprintf("CLs are routed by default to the unknown channel %q", name))
+		}
+	}
+
+	for idx, route := range routing.Routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", idx+1)
+		}
+
+		if len(route.Projects)+len(route.Branches)+len(route.SubjectPrefixes)+len(route.Paths) == 0 {
+			problems = append(problems, fmt.Sprintf("CL route %s matches nothing, set projects, branches, subject_prefixes or paths", name))
+		}
+		if len(route.Channels) == 0 {
+			problems = append(problems, fmt.Sprintf("CL route %s has no channels", name))
+		}
+		for _, channel := range route.Channels {
+			if !channels[strings.ToLower(channel)] {
+				problems = append(problems, fmt.Sprintf("CL route %s goes to the unknown channel %q", name, channel))
+			}
+		}
+		for _, pattern := range append(append([]string{}, route.Branches...), route.Paths...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("CL route %s has the invalid pattern %q", name, pattern))
+			}
+		}
+	}
+
+	return problems
+}
+
+// matchesName says if the value is one of the names or matches one of the patterns
+func matchesName(names []string, value string) bool {
+	for _, name := range names {
+		if name == value {
+			return true
+		}
+		if ok, _ := path.Match(name, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath says if the file is in one of the directories or matches one of the patterns
+func matchesPath(paths []string, file string) bool {
+	for _, dir := range paths {
+		if file == dir || strings.HasPrefix(file, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+		if ok, _ := path.Match(dir, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (route CLRoute) matches(cl gerritCL) bool {
+	if len(route.Projects) > 0 && !matchesName(route.Projects, cl.Project) {
+		return false
+	}
+	if len(route.Branches) > 0 && !matchesName(route.Branches, cl.Branch) {
+		return false
+	}
+
+	if len(route.SubjectPrefixes) > 0 {
+		matched := false
+		for _, prefix := range route.SubjectPrefixes {
+			if strings.HasPrefix(cl.Subject, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(route.Paths) > 0 {
+		matched := false
+		for _, file := range cl.files() {
+			if matchesPath(route.Paths, file) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeCL returns the names of the channels in which the CL is announced
+func (b *Bot) routeCL(cl gerritCL) []string {
+	b.mu.RLock()
+	routing := b.config.CLRouting
+	b.mu.RUnlock()
+
+	seen := map[string]bool{}
+	channels := []string{}
+	add := func(names []string) {
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if !seen[name] {
+				seen[name] = true
+				channels = append(channels, name)
+			}
+		}
+	}
+
+	for _, route := range routing.Routes {
+		if route.matches(cl) {
+			add(route.Channels)
+		}
+	}
+
+	if len(channels) == 0 {
+		add(routing.DefaultChannels)
+	}
+	if len(channels) == 0 {
+		add([]string{defaultCLChannel})
+	}
+
+	sort.Strings(channels)
+	return channels
+}
@@ -0,0 +1,413 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Kinds of scheduled tasks known by the bot itself
+const (
+	TaskAnnouncement = "announcement"
+	TaskReminder     = "reminder"
+)
+
+const (
+	// scheduledTasksInterval is how often the store is checked for due tasks
+	scheduledTasksInterval = 30 * time.Second
+	maxTaskJitter          = time.Hour
+)
+
+type (
+	// TaskHandler runs a scheduled task of some kind
+	TaskHandler func(ctx context.Context, b *Bot, task ScheduledTask) error
+)
+
+// RegisterTaskKind makes the bot able to run the scheduled tasks of a new kind
+func (b *Bot) RegisterTaskKind(kind string, handler TaskHandler) {
+	b.taskHandlersMu.Lock()
+	defer b.taskHandlersMu.Unlock()
+
+	b.taskHandlers[kind] = handler
+}
+
+func (b *Bot) taskHandler(kind string) (TaskHandler, bool) {
+	b.taskHandlersMu.RLock()
+	defer b.taskHandlersMu.RUnlock()
+
+	handler, ok := b.taskHandlers[kind]
+	return handler, ok
+}
+
+func builtinTaskKinds() map[string]TaskHandler {
+	return map[string]TaskHandler{
+		TaskAnnouncement: announce,
+		TaskReminder:     remind,
+		TaskDigest:       sendDigest,
+	}
+}
+
+func announce(ctx context.Context, b *Bot, task ScheduledTask) error {
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err := b.chat.PostMessage(ctx, task.Target, task.Text, params)
+	return err
+}
+
+func remind(ctx context.Context, b *Bot, task ScheduledTask) error {
+	params := slack.PostMessageParameters{AsUser: true}
+	_, _, err := b.chat.DirectMessage(ctx, task.Target, "Reminder: "+task.Text, params)
+	return err
+}
+
+func newTaskID() string {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(id)
+}
+
+// next returns the run after now, or the zero time for tasks which run once
+func (task ScheduledTask) next(now time.Time) (time.Time, error) {
+	if task.Schedule == "" {
+		return time.Time{}, nil
+	}
+
+	loc, err := time.LoadLocation(task.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	schedule, err := ParseSchedule(task.Schedule, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := withJitter(schedule.Next(now), time.Duration(task.JitterSeconds)*time.Second)
+	return next.Truncate(time.Second), nil
+}
+
+func (task ScheduledTask) scheduleText() string {
+	if task.Schedule == "" {
+		return "once"
+	}
+
+	text := fmt.Sprintf("%q", task.Schedule)
+	if task.TimeZone != "" {
+		text += " " + task.TimeZone
+	}
+	if task.JitterSeconds > 0 {
+		text += fmt.Sprintf(" with up to %s of jitter", time.Duration(task.JitterSeconds)*time.Second)
+	}
+	return text
+}
+
+func (task ScheduledTask) targetText() string {
+	if task.Kind == TaskReminder || task.Kind == TaskDigest {
+		return "<@" + task.Target + ">"
+	}
+	return "<#" + task.Target + ">"
+}
+
+// runScheduledTasks runs the tasks which are due, it is run by the scheduler job
+func (b *Bot) runScheduledTasks(ctx context.Context) error {
+	tasks, err := b.store.ScheduledTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load the scheduled tasks: %v", err)
+	}
+
+	now := time.Now()
+	problems := []string{}
+	for _, task := range tasks {
+		if task.NextRun.After(now) {
+			continue
+		}
+
+		// runs missed while the bot was down are done once
+		next, err := task.next(now)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("task %s has an invalid schedule: %v", task.ID, err))
+			continue
+		}
+
+		claimed, err := b.store.ClaimScheduledTask(ctx, task.ID, task.NextRun, next)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("could not claim task %s: %v", task.ID, err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := b.runTask(ctx, task); err != nil {
+			problems = append(problems, fmt.Sprintf("task %s failed: %v", task.ID, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (b *Bot) runTask(ctx context.Context, task ScheduledTask) error {
+	span := SpanFromContext(ctx).NewChild("task." + task.Kind)
+	span.SetLabel("id", task.ID)
+	defer span.Finish()
+
+	handler, ok := b.taskHandler(task.Kind)
+	if !ok {
+		return fmt.Errorf("unknown kind %q", task.Kind)
+	}
+	return handler(NewSpanContext(ctx, span), b, task)
+}
+
+// parseTaskCommand reads `"<when>" [in <time zone>] [jitter <duration>]`
+// from the arguments, fills the schedule of the task and returns the rest
+// of the arguments
+func parseTaskCommand(args string, now time.Time, task *ScheduledTask) (string, error) {
+	args = strings.NewReplacer("“", `"`, "”", `"`).Replace(strings.TrimSpace(args))
+	if !strings.HasPrefix(args, `"`) {
+		return "", fmt.Errorf("the schedule must be quoted")
+	}
+	end := strings.Index(args[1:], `"`)
+	if end < 0 {
+		return "", fmt.Errorf("the schedule must be quoted")
+	}
+	when := strings.TrimSpace(args[1 : end+1])
+	rest := strings.TrimSpace(args[end+2:])
+
+	loc := time.UTC
+	for {
+		fields := strings.SplitN(rest, " ", 3)
+		if len(fields) < 2 {
+			break
+		}
+
+		option, value := strings.ToLower(fields[0]), fields[1]
+		if option == "in" {
+			// "in" followed by something else than a time zone starts the text
+			zone, err := time.LoadLocation(value)
+			if err != nil {
+				break
+			}
+			loc, task.TimeZone = zone, zone.String()
+		} else if option == "jitter" {
+			jitter, err := time.ParseDuration(value)
+			if err != nil || jitter < 0 || jitter > maxTaskJitter {
+				return "", fmt.Errorf("invalid jitter %q, it must be a duration up to %s", value, maxTaskJitter)
+			}
+			task.JitterSeconds = int(jitter / time.Second)
+		} else {
+			break
+		}
+
+		rest = ""
+		if len(fields) == 3 {
+			rest = strings.TrimSpace(fields[2])
+		}
+	}
+
+	lower := strings.ToLower(when)
+	switch {
+	case strings.HasPrefix(lower, "in "):
+		delay, err := time.ParseDuration(strings.TrimSpace(lower[len("in "):]))
+		if err != nil || delay <= 0 {
+			return "", fmt.Errorf("invalid delay %q", when)
+		}
+		task.NextRun = now.Add(delay)
+
+	case len(when) == len("2006-01-02 15:04") && strings.Count(when, "-") == 2:
+		at, err := time.ParseInLocation("2006-01-02 15:04", when, loc)
+		if err != nil {
+			return "", fmt.Errorf("invalid date %q, use YYYY-MM-DD HH:MM", when)
+		}
+		if !at.After(now) {
+			return "", fmt.Errorf("%s is in the past", when)
+		}
+		task.NextRun = at
+
+	default:
+		task.Schedule = when
+		next, err := task.next(now)
+		if err != nil {
+			return "", err
+		}
+		if next.IsZero() {
+			return "", fmt.Errorf("the schedule %q never runs", when)
+		}
+		task.NextRun = next
+	}
+
+	if task.Schedule == "" {
+		task.NextRun = withJitter(task.NextRun, time.Duration(task.JitterSeconds)*time.Second)
+	}
+	task.NextRun = task.NextRun.Truncate(time.Second)
+	return rest, nil
+}
+
+func scheduleTask(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	usage := `Usage: "schedule "<cron expression | YYYY-MM-DD HH:MM | in <duration>>" [in <time zone>] [jitter <duration>] <#channel | @user> <text>"`
+
+	task := ScheduledTask{
+		ID:        newTaskID(),
+		CreatedBy: event.User,
+		CreatedAt: time.Now(),
+	}
+	rest, err := parseTaskCommand(b.arguments(event, "schedule"), task.CreatedAt, &task)
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\n%s", err, usage))
+		return
+	}
+
+	fields := strings.SplitN(rest, " ", 2)
+	if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+		b.directReply(ctx, event, usage)
+		return
+	}
+	task.Text = strings.TrimSpace(fields[1])
+
+	if match := channelMentionRE.FindStringSubmatch(fields[0]); match != nil {
+		task.Kind, task.Target = TaskAnnouncement, match[1]
+	} else if match := userMentionRE.FindStringSubmatch(fields[0]); match != nil {
+		task.Kind, task.Target = TaskReminder, match[1]
+	} else {
+		b.directReply(ctx, event, usage)
+		return
+	}
+
+	b.saveTask(ctx, event, task)
+}
+
+func remindMe(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	usage := `Usage: "remind me "<cron expression | YYYY-MM-DD HH:MM | in <duration>>" [in <time zone>] <text>"`
+
+	task := ScheduledTask{
+		ID:        newTaskID(),
+		Kind:      TaskReminder,
+		Target:    event.User,
+		CreatedBy: event.User,
+		CreatedAt: time.Now(),
+	}
+	rest, err := parseTaskCommand(b.arguments(event, "remind me"), task.CreatedAt, &task)
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\n%s", err, usage))
+		return
+	}
+	if rest == "" {
+		b.directReply(ctx, event, usage)
+		return
+	}
+	task.Text = rest
+
+	b.saveTask(ctx, event, task)
+}
+
+func (b *Bot) saveTask(ctx context.Context, event *slack.MessageEvent, task ScheduledTask) {
+	if err := b.store.SaveScheduledTask(ctx, task); err != nil {
+		b.logf("could not save the scheduled task %#v: %v\n", task, err)
+		b.directReply(ctx, event, `Could not schedule it, please try again`)
+		return
+	}
+
+	if task.Kind == TaskAnnouncement {
+		b.audit(ctx, AuditEntry{
+			Time:    task.CreatedAt,
+			User:    event.User,
+			Channel: event.Channel,
+			Action:  "schedule",
+			Allowed: true,
+			Details: fmt.Sprintf("%s %s in %s", task.ID, task.scheduleText(), task.targetText()),
+		})
+	}
+
+	b.directReply(ctx, event, fmt.Sprintf("Scheduled `%s` %s for %s, first run at %s",
+		task.ID, task.scheduleText(), task.targetText(), task.NextRun.UTC().Format("2006-01-02 15:04 MST")))
+}
+
+func listScheduledTasks(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	tasks, err := b.store.ScheduledTasks(ctx)
+	if err != nil {
+		b.logf("could not load the scheduled tasks: %v\n", err)
+		b.directReply(ctx, event, `Could not load the scheduled tasks, please try again`)
+		return
+	}
+
+	// moderators see everything, the others only what they scheduled
+	all := b.rolesOf(ctx, event.User, event.Channel).allow(RoleModerator)
+
+	buff := &bytes.Buffer{}
+	for _, task := range tasks {
+		if !all && task.CreatedBy != event.User {
+			continue
+		}
+
+		text := task.Text
+		if runes := []rune(text); len(runes) > 80 {
+			text = string(runes[:80]) + "…"
+		}
+		buff.WriteString(fmt.Sprintf("\n- `%s` %s for %s %s, next run %s, by <@%s>: %s",
+			task.ID, task.Kind, task.targetText(), task.scheduleText(), task.NextRun.UTC().Format("2006-01-02 15:04 MST"), task.CreatedBy, text))
+	}
+
+	if buff.Len() == 0 {
+		b.directReply(ctx, event, `Nothing is scheduled`)
+		return
+	}
+	b.directReply(ctx, event, "Here is what is scheduled"+buff.String())
+}
+
+func unscheduleTask(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	id := strings.Trim(b.arguments(event, "unschedule"), " `")
+	if id == "" {
+		b.directReply(ctx, event, `Usage: "unschedule <id>"`)
+		return
+	}
+
+	tasks, err := b.store.ScheduledTasks(ctx)
+	if err != nil {
+		b.logf("could not load the scheduled tasks: %v\n", err)
+		b.directReply(ctx, event, `Could not load the scheduled tasks, please try again`)
+		return
+	}
+
+	var task *ScheduledTask
+	for idx := range tasks {
+		if tasks[idx].ID == id {
+			task = &tasks[idx]
+			break
+		}
+	}
+	if task == nil {
+		b.directReply(ctx, event, fmt.Sprintf("There is no scheduled task `%s`", id))
+		return
+	}
+
+	if task.CreatedBy != event.User && !b.authorize(ctx, RoleModerator, "unschedule", event.User, event.Channel) {
+		b.directReply(ctx, event, `You can only unschedule what you scheduled`)
+		return
+	}
+
+	err = b.store.DeleteScheduledTask(ctx, id)
+	if err != nil && err != ErrTaskNotFound {
+		b.logf("could not delete the scheduled task %s: %v\n", id, err)
+		b.directReply(ctx, event, `Could not unschedule it, please try again`)
+		return
+	}
+
+	if task.Kind == TaskAnnouncement {
+		b.audit(ctx, AuditEntry{
+			User:    event.User,
+			Channel: event.Channel,
+			Action:  "unschedule",
+			Allowed: true,
+			Details: fmt.Sprintf("%s %s in %s", task.ID, task.scheduleText(), task.targetText()),
+		})
+	}
+	b.directReply(ctx, event, fmt.Sprintf("Unscheduled `%s`", id))
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTaskCommand(t *testing.T) {
+	mustLoadLocation(t, "Europe/Paris")
+	mustLoadLocation(t, "America/New_York")
+	now := time.Date(2018, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		args     string
+		rest     string
+		schedule string
+		timeZone string
+		jitter   int
+		next     time.Time
+		// spread is the jitter which may be added to next
+		spread time.Duration
+	}{
+		{
+			args: `"in 2h" #general Hello`,
+			rest: "#general Hello",
+			next: time.Date(2018, time.January, 15, 12, 7, 30, 0, time.UTC),
+		},
+		{
+			args:     `"2018-03-25 09:00" in Europe/Paris <@U1> Summer time`,
+			rest:     "<@U1> Summer time",
+			timeZone: "Europe/Paris",
+			next:     time.Date(2018, time.March, 25, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			args:     `"30 9 * * mon-fri" in America/New_York jitter 5m #general Standup`,
+			rest:     "#general Standup",
+			schedule: "30 9 * * mon-fri",
+			timeZone: "America/New_York",
+			jitter:   300,
+			next:     time.Date(2018, time.January, 15, 14, 30, 0, 0, time.UTC),
+			spread:   5 * time.Minute,
+		},
+		{
+			args:     `“*/15 * * * *” #general Curly quotes`,
+			rest:     "#general Curly quotes",
+			schedule: "*/15 * * * *",
+			next:     time.Date(2018, time.January, 15, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			args:   `"in 1h" jitter 10m <@U1> Later`,
+			rest:   "<@U1> Later",
+			jitter: 600,
+			next:   time.Date(2018, time.January, 15, 11, 7, 30, 0, time.UTC),
+			spread: 10 * time.Minute,
+		},
+		{
+			args: `"in 1h" in the morning`,
+			rest: "in the morning",
+			next: time.Date(2018, time.January, 15, 11, 7, 30, 0, time.UTC),
+		},
+		{
+			args:     `"@daily"`,
+			schedule: "@daily",
+			next:     time.Date(2018, time.January, 16, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			var task ScheduledTask
+			rest, err := parseTaskCommand(test.args, now, &task)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rest != test.rest {
+				t.Errorf("the rest is %q, want %q", rest, test.rest)
+			}
+			if task.Schedule != test.schedule || task.TimeZone != test.timeZone || task.JitterSeconds != test.jitter {
+				t.Errorf("got the schedule %q in %q with %ds of jitter, want %q in %q with %ds", task.Schedule, task.TimeZone, task.JitterSeconds, test.schedule, test.timeZone, test.jitter)
+			}
+			if task.NextRun.Before(test.next) || !task.NextRun.Before(test.next.Add(test.spread+time.Second)) {
+				t.Errorf("the next run is %s, want %s up to %s later", task.NextRun, test.next, test.spread)
+			}
+			if task.NextRun.Nanosecond() != 0 {
+				t.Errorf("the next run %s is not truncated to the second", task.NextRun)
+			}
+		})
+	}
+}
+
+func TestParseTaskCommandErrors(t *testing.T) {
+	now := time.Date(2018, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{args: `in 1h #general Hello`, err: "must be quoted"},
+		{args: `"in 1h #general Hello`, err: "must be quoted"},
+		{args: `"in soon" #general Hello`, err: "invalid delay"},
+		{args: `"in -1h" #general Hello`, err: "invalid delay"},
+		{args: `"2018-01-15 10:00" #general Hello`, err: "is in the past"},
+		{args: `"2018-13-01 10:00" #general Hello`, err: "invalid date"},
+		{args: `"in 1h" jitter 2h #general Hello`, err: "invalid jitter"},
+		{args: `"in 1h" jitter soon #general Hello`, err: "invalid jitter"},
+		{args: `"0 0 30 2 *" #general Hello`, err: "never runs"},
+		{args: `"0 25 * * *" #general Hello`, err: "invalid hour"},
+	}
+
+	for _, test := range tests {
+		var task ScheduledTask
+		if _, err := parseTaskCommand(test.args, now, &task); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parsing %q returned the error %v, want %q", test.args, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Slack response types for slash commands
+const (
+	ResponseEphemeral = "ephemeral"
+	ResponseInChannel = "in_channel"
+)
+
+type (
+	slashResponseKey struct{}
+
+	// slashResponse sends what a command answers to the response_url of a
+	// slash command, or of an interactive message, instead of posting
+	// regular messages
+	slashResponse struct {
+		url          string
+		responseType string
+		user         string
+		channel      string
+	}
+
+	slashResponseMessage struct {
+		ResponseType    string             `json:"response_type"`
+		ReplaceOriginal bool               `json:"replace_original"`
+		Text            string             `json:"text"`
+		Attachments     []slack.Attachment `json:"attachments,omitempty"`
+	}
+
+	// slashMessenger sends the answers to a slash command to its response_url,
+	// everything else goes to the wrapped Messenger
+	slashMessenger struct {
+		Messenger
+		client Client
+	}
+)
+
+func slashResponseFrom(ctx context.Context) *slashResponse {
+	response, _ := ctx.Value(slashResponseKey{}).(*slashResponse)
+	return response
+}
+
+// withoutSlashResponse sends the messages as regular ones, for those which
+// must have a timestamp, such as the first message of a thread
+func withoutSlashResponse(ctx context.Context) context.Context {
+	return context.WithValue(ctx, slashResponseKey{}, (*slashResponse)(nil))
+}
+
+func (s *slashMessenger) respond(ctx context.Context, response *slashResponse, responseType, text string, params slack.PostMessageParameters) error {
+	body, err := json.Marshal(slashResponseMessage{
+		ResponseType: responseType,
+		Text:         text,
+		Attachments:  params.Attachments,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", response.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(ctx)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got non-200 code: %d from the slash command response url", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *slashMessenger) PostMessage(ctx context.Context, channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	response := slashResponseFrom(ctx)
+	if response == nil || channel != response.channel {
+		return s.Messenger.PostMessage(ctx, channel, text, params)
+	}
+	return channel, "", s.respond(ctx, response, response.responseType, text, params)
+}
+
+func (s *slashMessenger) DirectMessage(ctx context.Context, user, text string, params slack.PostMessageParameters) (string, string, error) {
+	response := slashResponseFrom(ctx)
+	if response == nil || user != response.user {
+		return s.Messenger.DirectMessage(ctx, user, text, params)
+	}
+	return response.channel, "", s.respond(ctx, response, ResponseEphemeral, text, params)
+}
+
+func (s *slashMessenger) AddReaction(ctx context.Context, reaction string, item slack.ItemRef) error {
+	response := slashResponseFrom(ctx)
+	if response == nil || item.Channel != response.channel {
+		return s.Messenger.AddReaction(ctx, reaction, item)
+	}
+	// slash commands leave no message behind to react to
+	return s.respond(ctx, response, response.responseType, ":"+reaction+":", slack.PostMessageParameters{})
+}
+
+func ephemeralReply(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slashResponseMessage{ResponseType: ResponseEphemeral, Text: text})
+}
+
+// SlashCommandHandler runs the /gopher slash command through the command registry.
+// Slack is answered right away and the command sends its answer to the
+// response_url afterwards, as commands often need more than the 3 seconds
+// Slack waits for.
+func (b *Bot) SlashCommandHandler(signingSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestSpan := b.tracer.SpanFromRequest(r)
+		defer requestSpan.Finish()
+
+		body, err := VerifySlackRequest(r, signingSecret, time.Now())
+		if err != nil {
+			b.logf("rejected slash command request: %v\n", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		text := strings.TrimSpace(form.Get("text"))
+		if text == "" {
+			text = "help"
+		}
+		eventText := strings.ToLower(text)
+
+		cmd := b.commands.Match(eventText, false)
+		if cmd == nil {
+			cmd = b.commands.Match(eventText, true)
+		}
+		if cmd == nil {
+			ephemeralReply(w, fmt.Sprintf(`I don't know what %q means, try "%s help"`, text, form.Get("command")))
+			return
+		}
+		requestSpan.SetLabel("command", cmd.Name)
+
+		response := &slashResponse{
+			url:          form.Get("response_url"),
+			responseType: ResponseEphemeral,
+			user:         form.Get("user_id"),
+			channel:      form.Get("channel_id"),
+		}
+		if cmd.InChannel {
+			response.responseType = ResponseInChannel
+		}
+
+		event := &slack.MessageEvent{Msg: slack.Msg{
+			Type:    "message",
+			User:    response.user,
+			Channel: response.channel,
+			Text:    text,
+		}}
+
+		b.Go(func() {
+			span := b.tracer.NewSpan("b.SlashCommand")
+			span.SetLabel("command", cmd.Name)
+			defer span.Finish()
+
+			ctx := NewSpanContext(context.Background(), span)
+			ctx = context.WithValue(ctx, slashResponseKey{}, response)
+			b.runCommand(ctx, cmd, event)
+		})
+
+		// Acknowledging with the in_channel response type shows the command
+		// to the channel too, an empty answer keeps it private
+		if response.responseType == ResponseInChannel {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"response_type": ResponseInChannel})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
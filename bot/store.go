@@ -0,0 +1,277 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// StoredCL is what the bot remembers about a CL it has seen
+	StoredCL struct {
+		Number    int       `datastore:"-" json:"number"`
+		Tweeted   bool      `datastore:"Tweeted,noindex" json:"tweeted"`
+		URL       string    `datastore:"URL,noindex" json:"url"`
+		Message   string    `datastore:"Message,noindex" json:"message"`
+		CrawledAt time.Time `datastore:"CrawledAt" json:"crawled_at"`
+		// TweetURL links to the tweet once the CL was shared
+		TweetURL string `datastore:"TweetURL,noindex" json:"tweet_url,omitempty"`
+		// Skipped CLs were dismissed by a curator
+		Skipped bool `datastore:"Skipped,noindex" json:"skipped,omitempty"`
+		// PostChannel and PostTimestamp locate the post in the curation channel
+		PostChannel   string `datastore:"PostChannel,noindex" json:"post_channel,omitempty"`
+		PostTimestamp string `datastore:"PostTimestamp,noindex" json:"post_timestamp,omitempty"`
+		// Project, Branch, Subject and the author are empty for the CLs stored
+		// before they were recorded
+		Project     string `datastore:"Project,noindex" json:"project,omitempty"`
+		Branch      string `datastore:"Branch,noindex" json:"branch,omitempty"`
+		Subject     string `datastore:"Subject,noindex" json:"subject,omitempty"`
+		AuthorName  string `datastore:"AuthorName,noindex" json:"author_name,omitempty"`
+		AuthorEmail string `datastore:"AuthorEmail" json:"author_email,omitempty"`
+		// RelNote is what the RELNOTE lines of the commit message say, such as "yes"
+		RelNote string `datastore:"RelNote" json:"rel_note,omitempty"`
+		// SchemaVersion is the version of the record, the records older than
+		// clSchemaVersion, 0 for those stored before it was recorded, are upgraded
+		SchemaVersion int       `datastore:"SchemaVersion" json:"schema_version"`
+		ChangeID      string    `datastore:"ChangeID,noindex" json:"change_id,omitempty"`
+		OwnerName     string    `datastore:"OwnerName,noindex" json:"owner_name,omitempty"`
+		OwnerEmail    string    `datastore:"OwnerEmail,noindex" json:"owner_email,omitempty"`
+		MergedAt      time.Time `datastore:"MergedAt,noindex" json:"merged_at,omitempty"`
+		// Posts are the announcements of the CL in the channels it was routed to
+		Posts []CLPost `datastore:"Posts,noindex" json:"posts,omitempty"`
+		// TweetID identifies the tweet once the CL was shared
+		TweetID string `datastore:"TweetID,noindex" json:"tweet_id,omitempty"`
+	}
+
+	// CLPost is a message which announced a CL
+	CLPost struct {
+		Channel   string `json:"channel"`
+		Timestamp string `json:"timestamp"`
+	}
+
+	// CLMigration is the progress of the upgrade of the stored CLs to a schema version
+	CLMigration struct {
+		Version int `datastore:",noindex" json:"version"`
+		// LastNumber is the last CL checked, they are checked in order
+		LastNumber int       `datastore:",noindex" json:"last_number"`
+		Upgraded   int       `datastore:",noindex" json:"upgraded"`
+		Done       bool      `datastore:",noindex" json:"done"`
+		UpdatedAt  time.Time `datastore:",noindex" json:"updated_at"`
+	}
+
+	// CLStore keeps the history of the CLs the bot has processed
+	CLStore interface {
+		// LastSeenCL returns the number of the most recently crawled CL, or -1 if there is none
+		LastSeenCL(ctx context.Context) (int, error)
+		// GetCL returns the stored CL or ErrCLNotFound
+		GetCL(ctx context.Context, number int) (*StoredCL, error)
+		// SaveCL creates or replaces the stored CL
+		SaveCL(ctx context.Context, cl *StoredCL) error
+		// UpdateCL changes the stored CL atomically with update, which can be
+		// called more than once. An error returned by update is returned as is
+		// and nothing is saved. It returns ErrCLNotFound when there is no such CL.
+		UpdateCL(ctx context.Context, number int, update func(cl *StoredCL) error) (*StoredCL, error)
+		// CLsAfter returns up to limit CLs numbered after the given number, in order
+		CLsAfter(ctx context.Context, number, limit int) ([]StoredCL, error)
+		// CLsSince returns the CLs crawled since the given time, oldest first
+		CLsSince(ctx context.Context, since time.Time) ([]StoredCL, error)
+		// AuthorCLs returns the numbers of the stored CLs written by the author
+		AuthorCLs(ctx context.Context, email string) ([]int, error)
+		// RelNoteCLs returns the CLs with a release note crawled during the period, oldest first
+		RelNoteCLs(ctx context.Context, since, until time.Time) ([]StoredCL, error)
+		// CLMigration returns the progress of the last migration, zero if there is none
+		CLMigration(ctx context.Context) (CLMigration, error)
+		// SaveCLMigration replaces the progress of the migration
+		SaveCLMigration(ctx context.Context, migration CLMigration) error
+	}
+
+	// Grant gives a role to a user, to everyone in a channel or to a user group
+	Grant struct {
+		Role      string    `json:"role"`
+		Kind      string    `json:"kind"`
+		Subject   string    `json:"subject"`
+		GrantedBy string    `datastore:",noindex" json:"granted_by"`
+		GrantedAt time.Time `datastore:",noindex" json:"granted_at"`
+	}
+
+	// AuditEntry records a privileged action, or an attempt at one
+	AuditEntry struct {
+		Time    time.Time `json:"time"`
+		User    string    `datastore:",noindex" json:"user"`
+		Channel string    `datastore:",noindex" json:"channel"`
+		Action  string    `datastore:",noindex" json:"action"`
+		Allowed bool      `datastore:",noindex" json:"allowed"`
+		Details string    `datastore:",noindex" json:"details"`
+	}
+
+	// RoleStore keeps the granted roles and the audit trail
+	RoleStore interface {
+		// Grants returns all the roles granted so far
+		Grants(ctx context.Context) ([]Grant, error)
+		// SaveGrant creates or replaces a grant
+		SaveGrant(ctx context.Context, grant Grant) error
+		// DeleteGrant removes the grant with the same role, kind and subject, or returns ErrGrantNotFound
+		DeleteGrant(ctx context.Context, grant Grant) error
+		// AddAuditEntry appends an entry to the audit trail
+		AddAuditEntry(ctx context.Context, entry AuditEntry) error
+		// AuditEntries returns the most recent entries of the audit trail, newest first
+		AuditEntries(ctx context.Context, limit int) ([]AuditEntry, error)
+	}
+
+	// ScheduledTask is something the bot does at a later time, or
+	// periodically, which was scheduled from the chat
+	ScheduledTask struct {
+		ID   string `datastore:"-" json:"id"`
+		Kind string `datastore:",noindex" json:"kind"`
+		// Schedule is a cron expression or descriptor, empty for tasks which run once
+		Schedule string `datastore:",noindex" json:"schedule,omitempty"`
+		// TimeZone is the location in which Schedule is evaluated
+		TimeZone string `datastore:",noindex" json:"time_zone,omitempty"`
+		// JitterSeconds delays every run by a random duration up to this one
+		JitterSeconds int `datastore:",noindex" json:"jitter_seconds,omitempty"`
+		// Target is the channel, or the user, the task is about
+		Target    string    `datastore:",noindex" json:"target"`
+		Text      string    `datastore:",noindex" json:"text"`
+		CreatedBy string    `datastore:",noindex" json:"created_by"`
+		CreatedAt time.Time `datastore:",noindex" json:"created_at"`
+		NextRun   time.Time `json:"next_run"`
+		LastRun   time.Time `datastore:",noindex" json:"last_run,omitempty"`
+	}
+
+	// ScheduleStore keeps the scheduled tasks
+	ScheduleStore interface {
+		// ScheduledTasks returns all the scheduled tasks
+		ScheduledTasks(ctx context.Context) ([]ScheduledTask, error)
+		// SaveScheduledTask creates or replaces a task
+		SaveScheduledTask(ctx context.Context, task ScheduledTask) error
+		// DeleteScheduledTask removes a task, or returns ErrTaskNotFound
+		DeleteScheduledTask(ctx context.Context, id string) error
+		// ClaimScheduledTask moves the next run of the task from due to next,
+		// and says if it did. Only one of the concurrent callers with the same
+		// due time gets true, so a run is done once even with several replicas.
+		// A task without a next run is deleted.
+		ClaimScheduledTask(ctx context.Context, id string, due, next time.Time) (bool, error)
+	}
+
+	// Subscription sends a user the merged CLs which match a pattern
+	Subscription struct {
+		ID        string    `datastore:"-" json:"id"`
+		User      string    `json:"user"`
+		Pattern   string    `datastore:",noindex" json:"pattern"`
+		CreatedAt time.Time `datastore:",noindex" json:"created_at"`
+	}
+
+	// SubscriptionStore keeps the CL subscriptions of the users
+	SubscriptionStore interface {
+		// Subscriptions returns the subscriptions of every user
+		Subscriptions(ctx context.Context) ([]Subscription, error)
+		// SaveSubscription creates or replaces a subscription
+		SaveSubscription(ctx context.Context, subscription Subscription) error
+		// DeleteSubscription removes a subscription, or returns ErrSubscriptionNotFound
+		DeleteSubscription(ctx context.Context, id string) error
+	}
+
+	// CLThread is a message whose thread gets the updates of a CL under review
+	CLThread struct {
+		Channel   string `json:"channel"`
+		Timestamp string `json:"timestamp"`
+		// User asked to watch the CL, empty for the announcement of a new CL
+		User string `json:"user,omitempty"`
+	}
+
+	// WatchedCL is an open CL whose review is followed in some threads
+	WatchedCL struct {
+		Number   int    `datastore:"-" json:"number"`
+		Subject  string `datastore:",noindex" json:"subject"`
+		Status   string `datastore:",noindex" json:"status"`
+		PatchSet int    `datastore:",noindex" json:"patch_set"`
+		// Approved is set once the CL got a Code-Review +2
+		Approved bool `datastore:",noindex" json:"approved"`
+		// TryBotResult is the result of the TryBots on TryBotPatchSet
+		TryBotResult   string     `datastore:",noindex" json:"trybot_result,omitempty"`
+		TryBotPatchSet int        `datastore:",noindex" json:"trybot_patch_set,omitempty"`
+		Threads        []CLThread `datastore:",noindex" json:"threads"`
+		UpdatedAt      time.Time  `datastore:",noindex" json:"updated_at"`
+	}
+
+	// ReviewStore keeps the CLs under review which are followed
+	ReviewStore interface {
+		// WatchedCLs returns all the watched CLs
+		WatchedCLs(ctx context.Context) ([]WatchedCL, error)
+		// GetWatchedCL returns the watched CL or ErrWatchedCLNotFound
+		GetWatchedCL(ctx context.Context, number int) (*WatchedCL, error)
+		// UpdateWatchedCL changes the watched CL atomically with update, which
+		// gets a CL with only its number set when it is not watched yet, and
+		// can be called more than once. An error returned by update is
+		// returned as is and nothing is saved. A CL left without threads is
+		// deleted.
+		UpdateWatchedCL(ctx context.Context, number int, update func(cl *WatchedCL) error) (*WatchedCL, error)
+		// ReviewsCheckpoint returns the time of the last Gerrit update processed, zero if there is none
+		ReviewsCheckpoint(ctx context.Context) (time.Time, error)
+		// SaveReviewsCheckpoint replaces the time of the last Gerrit update processed
+		SaveReviewsCheckpoint(ctx context.Context, checkpoint time.Time) error
+	}
+
+	// Lease is held by one replica at a time, until it expires
+	Lease struct {
+		Name       string    `datastore:"-" json:"name"`
+		Holder     string    `datastore:",noindex" json:"holder"`
+		AcquiredAt time.Time `datastore:",noindex" json:"acquired_at"`
+		Expires    time.Time `datastore:",noindex" json:"expires"`
+	}
+
+	// LeaseStore keeps the leases used to elect a leader among the replicas
+	LeaseStore interface {
+		// AcquireLease gives the lease to the holder for ttl, if it is free,
+		// expired or already held by the holder, and says if it did
+		AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+		// ReleaseLease frees the lease if it is held by the holder
+		ReleaseLease(ctx context.Context, name, holder string) error
+	}
+
+	// CheckpointStore keeps named times, such as when something was last announced
+	CheckpointStore interface {
+		// Checkpoint returns the time saved under the name, zero if there is none
+		Checkpoint(ctx context.Context, name string) (time.Time, error)
+		// SaveCheckpoint replaces the time saved under the name
+		SaveCheckpoint(ctx context.Context, name string, t time.Time) error
+	}
+
+	// Store is everything the bot persists
+	Store interface {
+		CLStore
+		RoleStore
+		ScheduleStore
+		SubscriptionStore
+		ReviewStore
+		LeaseStore
+		CheckpointStore
+
+		// Close releases the resources held by the store
+		Close() error
+	}
+)
+
+// Subjects a role can be granted to
+const (
+	GrantUser      = "user"
+	GrantChannel   = "channel"
+	GrantUserGroup = "usergroup"
+)
+
+// Errors returned by the stores
+var (
+	ErrCLNotFound    = errors.New("cl not found")
+	ErrGrantNotFound = errors.New("grant not found")
+	ErrTaskNotFound  = errors.New("scheduled task not found")
+
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrWatchedCLNotFound    = errors.New("watched cl not found")
+)
+
+// Supported store backends
+const (
+	StoreDatastore = "datastore"
+	StoreMemory    = "memory"
+	StoreFile      = "file"
+)
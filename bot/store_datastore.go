@@ -0,0 +1,439 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	clKind    = "GoCL"
+	grantKind = "GopherGrant"
+	auditKind = "GopherAudit"
+	taskKind  = "GopherTask"
+	leaseKind = "GopherLease"
+
+	subscriptionKind = "GopherSubscription"
+	watchedCLKind    = "GopherWatchedCL"
+	checkpointKind   = "GopherCheckpoint"
+	clMigrationKind  = "GopherCLMigration"
+)
+
+// reviewsCheckpoint names the checkpoint of the review watcher
+const reviewsCheckpoint = "reviews"
+
+type checkpoint struct {
+	Time time.Time `datastore:",noindex"`
+}
+
+type datastoreStore struct {
+	client *datastore.Client
+}
+
+// NewDatastoreStore creates a Store backed by Google Cloud Datastore
+func NewDatastoreStore(client *datastore.Client) Store {
+	return &datastoreStore{client: client}
+}
+
+func (s *datastoreStore) LastSeenCL(ctx context.Context) (int, error) {
+	latestCLQuery := datastore.NewQuery(clKind).
+		Order("-CrawledAt").
+		Limit(1).
+		KeysOnly()
+
+	key, err := s.client.Run(ctx, latestCLQuery).Next(nil)
+	if err == iterator.Done {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(key.ID), nil
+}
+
+func (s *datastoreStore) GetCL(ctx context.Context, number int) (*StoredCL, error) {
+	cl := &StoredCL{}
+	err := s.client.Get(ctx, datastore.IDKey(clKind, int64(number), nil), cl)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrCLNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	cl.Number = number
+	return cl, nil
+}
+
+func (s *datastoreStore) SaveCL(ctx context.Context, cl *StoredCL) error {
+	_, err := s.client.Put(ctx, datastore.IDKey(clKind, int64(cl.Number), nil), cl)
+	return err
+}
+
+func (s *datastoreStore) UpdateCL(ctx context.Context, number int, update func(cl *StoredCL) error) (*StoredCL, error) {
+	key := datastore.IDKey(clKind, int64(number), nil)
+	var cl *StoredCL
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		cl = &StoredCL{}
+		err := tx.Get(key, cl)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrCLNotFound
+		}
+		if err != nil {
+			return err
+		}
+		cl.Number = number
+
+		if err := update(cl); err != nil {
+			return err
+		}
+		_, err = tx.Put(key, cl)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (s *datastoreStore) CLsAfter(ctx context.Context, number, limit int) ([]StoredCL, error) {
+	// the records of the older schemas may lack any other property, the keys are always indexed
+	query := datastore.NewQuery(clKind).
+		Order("__key__").
+		Limit(limit)
+	if number > 0 {
+		query = query.Filter("__key__ >", datastore.IDKey(clKind, int64(number), nil))
+	}
+
+	cls := []StoredCL{}
+	keys, err := s.client.GetAll(ctx, query, &cls)
+	for idx := range keys {
+		cls[idx].Number = int(keys[idx].ID)
+	}
+	return cls, err
+}
+
+func (s *datastoreStore) CLsSince(ctx context.Context, since time.Time) ([]StoredCL, error) {
+	query := datastore.NewQuery(clKind).
+		Filter("CrawledAt >=", since).
+		Order("CrawledAt")
+
+	cls := []StoredCL{}
+	keys, err := s.client.GetAll(ctx, query, &cls)
+	for idx := range keys {
+		cls[idx].Number = int(keys[idx].ID)
+	}
+	return cls, err
+}
+
+func (s *datastoreStore) AuthorCLs(ctx context.Context, email string) ([]int, error) {
+	query := datastore.NewQuery(clKind).
+		Filter("AuthorEmail =", email).
+		KeysOnly()
+
+	keys, err := s.client.GetAll(ctx, query, nil)
+	numbers := []int{}
+	for _, key := range keys {
+		numbers = append(numbers, int(key.ID))
+	}
+	sort.Ints(numbers)
+	return numbers, err
+}
+
+func (s *datastoreStore) RelNoteCLs(ctx context.Context, since, until time.Time) ([]StoredCL, error) {
+	// there are few release notes, filtering them by time here spares a composite index
+	query := datastore.NewQuery(clKind).Filter("RelNote >", "")
+
+	all := []StoredCL{}
+	keys, err := s.client.GetAll(ctx, query, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	cls := []StoredCL{}
+	for idx, cl := range all {
+		if !cl.CrawledAt.Before(since) && cl.CrawledAt.Before(until) {
+			cl.Number = int(keys[idx].ID)
+			cls = append(cls, cl)
+		}
+	}
+	sort.Slice(cls, func(i, j int) bool {
+		return cls[i].CrawledAt.Before(cls[j].CrawledAt)
+	})
+	return cls, nil
+}
+
+func (s *datastoreStore) CLMigration(ctx context.Context) (CLMigration, error) {
+	migration := CLMigration{}
+	err := s.client.Get(ctx, datastore.NameKey(clMigrationKind, clKind, nil), &migration)
+	if err == datastore.ErrNoSuchEntity {
+		return CLMigration{}, nil
+	}
+	return migration, err
+}
+
+func (s *datastoreStore) SaveCLMigration(ctx context.Context, migration CLMigration) error {
+	_, err := s.client.Put(ctx, datastore.NameKey(clMigrationKind, clKind, nil), &migration)
+	return err
+}
+
+func (s *datastoreStore) Close() error {
+	return s.client.Close()
+}
+
+func grantKey(grant Grant) *datastore.Key {
+	return datastore.NameKey(grantKind, grant.Role+"|"+grant.Kind+"|"+grant.Subject, nil)
+}
+
+func (s *datastoreStore) Grants(ctx context.Context) ([]Grant, error) {
+	grants := []Grant{}
+	_, err := s.client.GetAll(ctx, datastore.NewQuery(grantKind), &grants)
+	return grants, err
+}
+
+func (s *datastoreStore) SaveGrant(ctx context.Context, grant Grant) error {
+	_, err := s.client.Put(ctx, grantKey(grant), &grant)
+	return err
+}
+
+func (s *datastoreStore) DeleteGrant(ctx context.Context, grant Grant) error {
+	key := grantKey(grant)
+	err := s.client.Get(ctx, key, &Grant{})
+	if err == datastore.ErrNoSuchEntity {
+		return ErrGrantNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, key)
+}
+
+func (s *datastoreStore) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	_, err := s.client.Put(ctx, datastore.IncompleteKey(auditKind, nil), &entry)
+	return err
+}
+
+func (s *datastoreStore) AuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	query := datastore.NewQuery(auditKind).
+		Order("-Time").
+		Limit(limit)
+
+	entries := []AuditEntry{}
+	_, err := s.client.GetAll(ctx, query, &entries)
+	return entries, err
+}
+
+func (s *datastoreStore) ScheduledTasks(ctx context.Context) ([]ScheduledTask, error) {
+	tasks := []ScheduledTask{}
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery(taskKind), &tasks)
+	for idx := range keys {
+		tasks[idx].ID = keys[idx].Name
+	}
+	return tasks, err
+}
+
+func (s *datastoreStore) SaveScheduledTask(ctx context.Context, task ScheduledTask) error {
+	_, err := s.client.Put(ctx, datastore.NameKey(taskKind, task.ID, nil), &task)
+	return err
+}
+
+func (s *datastoreStore) DeleteScheduledTask(ctx context.Context, id string) error {
+	key := datastore.NameKey(taskKind, id, nil)
+	err := s.client.Get(ctx, key, &ScheduledTask{})
+	if err == datastore.ErrNoSuchEntity {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, key)
+}
+
+func (s *datastoreStore) ClaimScheduledTask(ctx context.Context, id string, due, next time.Time) (bool, error) {
+	key := datastore.NameKey(taskKind, id, nil)
+	claimed := false
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		claimed = false
+
+		task := &ScheduledTask{}
+		err := tx.Get(key, task)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !task.NextRun.Equal(due) {
+			return nil
+		}
+
+		claimed = true
+		if next.IsZero() {
+			return tx.Delete(key)
+		}
+		task.LastRun, task.NextRun = due, next
+		_, err = tx.Put(key, task)
+		return err
+	})
+	if err == datastore.ErrConcurrentTransaction {
+		// another replica claimed the task
+		return false, nil
+	}
+	return claimed && err == nil, err
+}
+
+func (s *datastoreStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	key := datastore.NameKey(leaseKind, name, nil)
+	acquired := false
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		acquired = false
+
+		now := time.Now()
+		lease := &Lease{}
+		err := tx.Get(key, lease)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err == nil && lease.Holder != holder && now.Before(lease.Expires) {
+			return nil
+		}
+
+		if err == datastore.ErrNoSuchEntity || lease.Holder != holder {
+			lease = &Lease{Holder: holder, AcquiredAt: now}
+		}
+		lease.Expires = now.Add(ttl)
+		if _, err := tx.Put(key, lease); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	if err == datastore.ErrConcurrentTransaction {
+		// another replica got the lease
+		return false, nil
+	}
+	return acquired && err == nil, err
+}
+
+func (s *datastoreStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	key := datastore.NameKey(leaseKind, name, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		lease := &Lease{}
+		err := tx.Get(key, lease)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if lease.Holder != holder {
+			return nil
+		}
+		return tx.Delete(key)
+	})
+	return err
+}
+
+func (s *datastoreStore) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery(subscriptionKind), &subscriptions)
+	for idx := range keys {
+		subscriptions[idx].ID = keys[idx].Name
+	}
+	return subscriptions, err
+}
+
+func (s *datastoreStore) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	_, err := s.client.Put(ctx, datastore.NameKey(subscriptionKind, subscription.ID, nil), &subscription)
+	return err
+}
+
+func (s *datastoreStore) DeleteSubscription(ctx context.Context, id string) error {
+	key := datastore.NameKey(subscriptionKind, id, nil)
+	err := s.client.Get(ctx, key, &Subscription{})
+	if err == datastore.ErrNoSuchEntity {
+		return ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, key)
+}
+
+func (s *datastoreStore) WatchedCLs(ctx context.Context) ([]WatchedCL, error) {
+	cls := []WatchedCL{}
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery(watchedCLKind), &cls)
+	for idx := range keys {
+		cls[idx].Number = int(keys[idx].ID)
+	}
+	return cls, err
+}
+
+func (s *datastoreStore) GetWatchedCL(ctx context.Context, number int) (*WatchedCL, error) {
+	cl := &WatchedCL{}
+	err := s.client.Get(ctx, datastore.IDKey(watchedCLKind, int64(number), nil), cl)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrWatchedCLNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	cl.Number = number
+	return cl, nil
+}
+
+func (s *datastoreStore) UpdateWatchedCL(ctx context.Context, number int, update func(cl *WatchedCL) error) (*WatchedCL, error) {
+	key := datastore.IDKey(watchedCLKind, int64(number), nil)
+	var cl *WatchedCL
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		cl = &WatchedCL{}
+		err := tx.Get(key, cl)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		cl.Number = number
+
+		if err := update(cl); err != nil {
+			return err
+		}
+
+		if len(cl.Threads) == 0 {
+			return tx.Delete(key)
+		}
+		_, err = tx.Put(key, cl)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (s *datastoreStore) ReviewsCheckpoint(ctx context.Context) (time.Time, error) {
+	return s.Checkpoint(ctx, reviewsCheckpoint)
+}
+
+func (s *datastoreStore) SaveReviewsCheckpoint(ctx context.Context, t time.Time) error {
+	return s.SaveCheckpoint(ctx, reviewsCheckpoint, t)
+}
+
+func (s *datastoreStore) Checkpoint(ctx context.Context, name string) (time.Time, error) {
+	saved := &checkpoint{}
+	err := s.client.Get(ctx, datastore.NameKey(checkpointKind, name, nil), saved)
+	if err == datastore.ErrNoSuchEntity {
+		return time.Time{}, nil
+	}
+	return saved.Time, err
+}
+
+func (s *datastoreStore) SaveCheckpoint(ctx context.Context, name string, t time.Time) error {
+	_, err := s.client.Put(ctx, datastore.NameKey(checkpointKind, name, nil), &checkpoint{Time: t})
+	return err
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NewFileStore creates a Store which keeps everything in memory and
+// writes it to a JSON file on every change, for self-hosted deployments
+func NewFileStore(path string) (Store, error) {
+	s := &memoryStore{data: newStoreData()}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &s.data); err != nil {
+			return nil, err
+		}
+		// files written by older versions may not have all the sections
+		if s.data.CLs == nil {
+			s.data.CLs = map[int]StoredCL{}
+		}
+		if s.data.Leases == nil {
+			s.data.Leases = map[string]Lease{}
+		}
+		if s.data.WatchedCLs == nil {
+			s.data.WatchedCLs = map[int]WatchedCL{}
+		}
+		if s.data.Checkpoints == nil {
+			s.data.Checkpoints = map[string]time.Time{}
+		}
+	}
+
+	s.persist = func(data *storeData) error {
+		return writeFileAtomic(path, data)
+	}
+
+	return s, nil
+}
+
+// writeFileAtomic makes sure the file is either fully written or not changed at all
+func writeFileAtomic(path string, data interface{}) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
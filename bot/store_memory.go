@@ -0,0 +1,458 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	storeData struct {
+		CLs    map[int]StoredCL `json:"cls"`
+		Grants []Grant          `json:"grants"`
+		Audit  []AuditEntry     `json:"audit"`
+		Tasks  []ScheduledTask  `json:"tasks"`
+		Leases map[string]Lease `json:"leases"`
+
+		Subscriptions []Subscription `json:"subscriptions"`
+
+		WatchedCLs        map[int]WatchedCL `json:"watched_cls"`
+		ReviewsCheckpoint time.Time         `json:"reviews_checkpoint"`
+
+		CLMigration CLMigration          `json:"cl_migration"`
+		Checkpoints map[string]time.Time `json:"checkpoints"`
+	}
+
+	memoryStore struct {
+		mu   sync.RWMutex
+		data storeData
+
+		// persist is called with the lock held after every change
+		persist func(data *storeData) error
+	}
+)
+
+func newStoreData() storeData {
+	return storeData{
+		CLs:    map[int]StoredCL{},
+		Leases: map[string]Lease{},
+
+		WatchedCLs:  map[int]WatchedCL{},
+		Checkpoints: map[string]time.Time{},
+	}
+}
+
+// NewMemoryStore creates a Store which keeps everything in memory
+func NewMemoryStore() Store {
+	return &memoryStore{data: newStoreData()}
+}
+
+func (s *memoryStore) save() error {
+	if s.persist == nil {
+		return nil
+	}
+	return s.persist(&s.data)
+}
+
+func (s *memoryStore) LastSeenCL(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lastID := -1
+	var last StoredCL
+	for number, cl := range s.data.CLs {
+		if lastID == -1 || cl.CrawledAt.After(last.CrawledAt) ||
+			(cl.CrawledAt.Equal(last.CrawledAt) && number > lastID) {
+			lastID, last = number, cl
+		}
+	}
+	return lastID, nil
+}
+
+func (s *memoryStore) GetCL(ctx context.Context, number int) (*StoredCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cl, ok := s.data.CLs[number]
+	if !ok {
+		return nil, ErrCLNotFound
+	}
+	return &cl, nil
+}
+
+func (s *memoryStore) SaveCL(ctx context.Context, cl *StoredCL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.CLs[cl.Number] = *cl
+	return s.save()
+}
+
+func (s *memoryStore) UpdateCL(ctx context.Context, number int, update func(cl *StoredCL) error) (*StoredCL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl, ok := s.data.CLs[number]
+	if !ok {
+		return nil, ErrCLNotFound
+	}
+	cl.Number = number
+	if err := update(&cl); err != nil {
+		return nil, err
+	}
+
+	s.data.CLs[number] = cl
+	return &cl, s.save()
+}
+
+func (s *memoryStore) CLsAfter(ctx context.Context, number, limit int) ([]StoredCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cls := []StoredCL{}
+	for n, cl := range s.data.CLs {
+		if n > number {
+			cl.Number = n
+			cls = append(cls, cl)
+		}
+	}
+	sort.Slice(cls, func(i, j int) bool {
+		return cls[i].Number < cls[j].Number
+	})
+	if len(cls) > limit {
+		cls = cls[:limit]
+	}
+	return cls, nil
+}
+
+func (s *memoryStore) CLsSince(ctx context.Context, since time.Time) ([]StoredCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cls := []StoredCL{}
+	for number, cl := range s.data.CLs {
+		if !cl.CrawledAt.Before(since) {
+			cl.Number = number
+			cls = append(cls, cl)
+		}
+	}
+	sort.Slice(cls, func(i, j int) bool {
+		return cls[i].CrawledAt.Before(cls[j].CrawledAt)
+	})
+	return cls, nil
+}
+
+func (s *memoryStore) AuthorCLs(ctx context.Context, email string) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	numbers := []int{}
+	for number, cl := range s.data.CLs {
+		if cl.AuthorEmail == email {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *memoryStore) RelNoteCLs(ctx context.Context, since, until time.Time) ([]StoredCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cls := []StoredCL{}
+	for number, cl := range s.data.CLs {
+		if cl.RelNote != "" && !cl.CrawledAt.Before(since) && cl.CrawledAt.Before(until) {
+			cl.Number = number
+			cls = append(cls, cl)
+		}
+	}
+	sort.Slice(cls, func(i, j int) bool {
+		return cls[i].CrawledAt.Before(cls[j].CrawledAt)
+	})
+	return cls, nil
+}
+
+func (s *memoryStore) CLMigration(ctx context.Context) (CLMigration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.CLMigration, nil
+}
+
+func (s *memoryStore) SaveCLMigration(ctx context.Context, migration CLMigration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.CLMigration = migration
+	return s.save()
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) Grants(ctx context.Context) ([]Grant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Grant{}, s.data.Grants...), nil
+}
+
+func sameGrant(a, b Grant) bool {
+	return a.Role == b.Role && a.Kind == b.Kind && a.Subject == b.Subject
+}
+
+func (s *memoryStore) SaveGrant(ctx context.Context, grant Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Grants {
+		if sameGrant(s.data.Grants[idx], grant) {
+			s.data.Grants[idx] = grant
+			return s.save()
+		}
+	}
+
+	s.data.Grants = append(s.data.Grants, grant)
+	return s.save()
+}
+
+func (s *memoryStore) DeleteGrant(ctx context.Context, grant Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Grants {
+		if sameGrant(s.data.Grants[idx], grant) {
+			s.data.Grants = append(s.data.Grants[:idx], s.data.Grants[idx+1:]...)
+			return s.save()
+		}
+	}
+
+	return ErrGrantNotFound
+}
+
+// maxMemoryAuditEntries keeps the audit trail of the memory and file stores from growing forever
+const maxMemoryAuditEntries = 1000
+
+func (s *memoryStore) AddAuditEntry(ctx context.Context, entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Audit = append(s.data.Audit, entry)
+	if len(s.data.Audit) > maxMemoryAuditEntries {
+		s.data.Audit = s.data.Audit[len(s.data.Audit)-maxMemoryAuditEntries:]
+	}
+	return s.save()
+}
+
+func (s *memoryStore) AuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []AuditEntry{}
+	for idx := len(s.data.Audit) - 1; idx >= 0 && len(entries) < limit; idx-- {
+		entries = append(entries, s.data.Audit[idx])
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) ScheduledTasks(ctx context.Context) ([]ScheduledTask, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]ScheduledTask{}, s.data.Tasks...), nil
+}
+
+func (s *memoryStore) SaveScheduledTask(ctx context.Context, task ScheduledTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Tasks {
+		if s.data.Tasks[idx].ID == task.ID {
+			s.data.Tasks[idx] = task
+			return s.save()
+		}
+	}
+
+	s.data.Tasks = append(s.data.Tasks, task)
+	return s.save()
+}
+
+func (s *memoryStore) DeleteScheduledTask(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Tasks {
+		if s.data.Tasks[idx].ID == id {
+			s.data.Tasks = append(s.data.Tasks[:idx], s.data.Tasks[idx+1:]...)
+			return s.save()
+		}
+	}
+
+	return ErrTaskNotFound
+}
+
+func (s *memoryStore) ClaimScheduledTask(ctx context.Context, id string, due, next time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Tasks {
+		task := &s.data.Tasks[idx]
+		if task.ID != id {
+			continue
+		}
+		if !task.NextRun.Equal(due) {
+			return false, nil
+		}
+
+		if next.IsZero() {
+			s.data.Tasks = append(s.data.Tasks[:idx], s.data.Tasks[idx+1:]...)
+		} else {
+			task.LastRun, task.NextRun = due, next
+		}
+		return true, s.save()
+	}
+
+	return false, nil
+}
+
+func (s *memoryStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	lease, ok := s.data.Leases[name]
+	if ok && lease.Holder != holder && now.Before(lease.Expires) {
+		return false, nil
+	}
+
+	if !ok || lease.Holder != holder {
+		lease = Lease{Name: name, Holder: holder, AcquiredAt: now}
+	}
+	lease.Expires = now.Add(ttl)
+	s.data.Leases[name] = lease
+	return true, s.save()
+}
+
+func (s *memoryStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, ok := s.data.Leases[name]; ok && lease.Holder == holder {
+		delete(s.data.Leases, name)
+		return s.save()
+	}
+	return nil
+}
+
+func (s *memoryStore) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Subscription{}, s.data.Subscriptions...), nil
+}
+
+func (s *memoryStore) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Subscriptions {
+		if s.data.Subscriptions[idx].ID == subscription.ID {
+			s.data.Subscriptions[idx] = subscription
+			return s.save()
+		}
+	}
+
+	s.data.Subscriptions = append(s.data.Subscriptions, subscription)
+	return s.save()
+}
+
+func (s *memoryStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx := range s.data.Subscriptions {
+		if s.data.Subscriptions[idx].ID == id {
+			s.data.Subscriptions = append(s.data.Subscriptions[:idx], s.data.Subscriptions[idx+1:]...)
+			return s.save()
+		}
+	}
+
+	return ErrSubscriptionNotFound
+}
+
+func (s *memoryStore) WatchedCLs(ctx context.Context) ([]WatchedCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cls := []WatchedCL{}
+	for _, cl := range s.data.WatchedCLs {
+		cls = append(cls, cl)
+	}
+	return cls, nil
+}
+
+func (s *memoryStore) GetWatchedCL(ctx context.Context, number int) (*WatchedCL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cl, ok := s.data.WatchedCLs[number]
+	if !ok {
+		return nil, ErrWatchedCLNotFound
+	}
+	return &cl, nil
+}
+
+func (s *memoryStore) UpdateWatchedCL(ctx context.Context, number int, update func(cl *WatchedCL) error) (*WatchedCL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl, ok := s.data.WatchedCLs[number]
+	if !ok {
+		cl = WatchedCL{Number: number}
+	}
+	// the threads are copied so that a failed update leaves the stored ones alone
+	cl.Threads = append([]CLThread{}, cl.Threads...)
+	if err := update(&cl); err != nil {
+		return nil, err
+	}
+
+	if len(cl.Threads) == 0 {
+		delete(s.data.WatchedCLs, number)
+	} else {
+		s.data.WatchedCLs[number] = cl
+	}
+	return &cl, s.save()
+}
+
+func (s *memoryStore) ReviewsCheckpoint(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.ReviewsCheckpoint, nil
+}
+
+func (s *memoryStore) SaveReviewsCheckpoint(ctx context.Context, checkpoint time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.ReviewsCheckpoint = checkpoint
+	return s.save()
+}
+
+func (s *memoryStore) Checkpoint(ctx context.Context, name string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.Checkpoints[name], nil
+}
+
+func (s *memoryStore) SaveCheckpoint(ctx context.Context, name string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Checkpoints[name] = t
+	return s.save()
+}
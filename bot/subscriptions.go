@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Kinds of CL patterns, a pattern without a kind matches the start of the subject
+const (
+	patternProject = "project"
+	patternBranch  = "branch"
+	patternSubject = "subject"
+	patternPath    = "path"
+	patternAuthor  = "author"
+)
+
+const maxSubscriptionsPerUser = 50
+
+type (
+	// clPattern selects the CLs a user is subscribed to
+	clPattern struct {
+		kind  string
+		value string
+	}
+)
+
+var (
+	patternKinds = []string{patternProject, patternBranch, patternSubject, patternPath, patternAuthor}
+
+	// Slack turns e-mail addresses and URLs into links
+	slackLinkTextRE = regexp.MustCompile(`<(?:mailto:|https?://)[^|>]*\|([^>]*)>`)
+	slackBareLinkRE = regexp.MustCompile(`<((?:https?://)[^|>]*)>`)
+)
+
+// unlinkSlack replaces the links added by Slack by their text
+func unlinkSlack(text string) string {
+	text = slackLinkTextRE.ReplaceAllString(text, "$1")
+	return slackBareLinkRE.ReplaceAllString(text, "$1")
+}
+
+// parseCLPattern reads "<kind>:<value>", or a subject prefix
+func parseCLPattern(text string) (clPattern, error) {
+	text = strings.TrimSpace(unlinkSlack(text))
+	if text == "" {
+		return clPattern{}, fmt.Errorf("the pattern is empty")
+	}
+
+	pattern := clPattern{kind: patternSubject, value: text}
+	if idx := strings.Index(text, ":"); idx > 0 {
+		kind := strings.ToLower(text[:idx])
+		for _, known := range patternKinds {
+			if kind == known {
+				pattern = clPattern{kind: kind, value: strings.TrimSpace(text[idx+1:])}
+				break
+			}
+		}
+	}
+
+	if pattern.value == "" {
+		return clPattern{}, fmt.Errorf("the %s pattern is empty", pattern.kind)
+	}
+	return pattern, nil
+}
+
+func (p clPattern) String() string {
+	return p.kind + ":" + p.value
+}
+
+func (p clPattern) matches(cl gerritCL) bool {
+	switch p.kind {
+	case patternProject:
+		return matchesName([]string{strings.ToLower(p.value)}, strings.ToLower(cl.Project))
+	case patternBranch:
+		return matchesName([]string{p.value}, cl.Branch)
+	case patternSubject:
+		return strings.HasPrefix(strings.ToLower(cl.Subject), strings.ToLower(p.value))
+	case patternPath:
+		for _, file := range cl.files() {
+			if matchesPath([]string{p.value}, file) {
+				return true
+			}
+		}
+	case patternAuthor:
+		author := cl.Revisions[cl.CurrentRevision].Commit.Author
+		value := strings.ToLower(p.value)
+		email := strings.ToLower(author.Email)
+		return value == strings.ToLower(author.Name) || value == email ||
+			(email != "" && strings.HasPrefix(email, value+"@"))
+	}
+	return false
+}
+
+func subscriptionID(user string, pattern clPattern) string {
+	return user + "|" + pattern.String()
+}
+
+// notifySubscribers sends the CL to the users subscribed to it, once per user
+func (b *Bot) notifySubscribers(ctx context.Context, cl gerritCL, subscriptions []Subscription) {
+	matched := map[string]string{}
+	for _, subscription := range subscriptions {
+		if _, ok := matched[subscription.User]; ok {
+			continue
+		}
+
+		pattern, err := parseCLPattern(subscription.Pattern)
+		if err != nil {
+			b.logf("invalid subscription %#v: %v\n", subscription, err)
+			continue
+		}
+		if pattern.matches(cl) {
+			matched[subscription.User] = subscription.Pattern
+		}
+	}
+
+	params := slack.PostMessageParameters{AsUser: true}
+	for user, pattern := range matched {
+		text := fmt.Sprintf("[%d] %s: %s\nYou get this because you subscribed to `%s`", cl.Number, cl.message(), cl.link(), pattern)
+		_, _, err := b.chat.DirectMessage(ctx, user, text, params)
+		if err != nil {
+			b.logf("could not notify %s about CL %d: %v\n", user, cl.Number, err)
+		}
+	}
+}
+
+// userSubscriptions returns the subscriptions of a user, sorted by pattern
+func (b *Bot) userSubscriptions(ctx context.Context, user string) ([]Subscription, error) {
+	all, err := b.store.Subscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := []Subscription{}
+	for _, subscription := range all {
+		if subscription.User == user {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Pattern < subscriptions[j].Pattern
+	})
+	return subscriptions, nil
+}
+
+func subscribeCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	pattern, err := parseCLPattern(b.arguments(event, "subscribe cl"))
+	if err != nil {
+		b.directReply(ctx, event, fmt.Sprintf("%s\nUsage: \"subscribe cl <pattern>\", the pattern is a subject prefix such as `net/http:`, or one of %s followed by a colon and a value, such as `path:src/net/http` or `author:gopher@golang.org`", err, strings.Join(patternKinds, ", ")))
+		return
+	}
+
+	subscriptions, err := b.userSubscriptions(ctx, event.User)
+	if err != nil {
+		b.logf("could not load the subscriptions: %v\n", err)
+		b.directReply(ctx, event, `Could not subscribe, please try again`)
+		return
+	}
+	for _, subscription := range subscriptions {
+		if subscription.Pattern == pattern.String() {
+			b.directReply(ctx, event, fmt.Sprintf("You are already subscribed to `%s`", pattern))
+			return
+		}
+	}
+	if len(subscriptions) >= maxSubscriptionsPerUser {
+		b.directReply(ctx, event, fmt.Sprintf("You can't have more than %d subscriptions", maxSubscriptionsPerUser))
+		return
+	}
+
+	subscription := Subscription{
+		ID:        subscriptionID(event.User, pattern),
+		User:      event.User,
+		Pattern:   pattern.String(),
+		CreatedAt: time.Now(),
+	}
+	if err := b.store.SaveSubscription(ctx, subscription); err != nil {
+		b.logf("could not save the subscription %#v: %v\n", subscription, err)
+		b.directReply(ctx, event, `Could not subscribe, please try again`)
+		return
+	}
+
+	b.directReply(ctx, event, fmt.Sprintf("Subscribed to `%s`, I'll send you the merged CLs which match it", pattern))
+}
+
+func unsubscribeCL(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	args := b.arguments(event, "unsubscribe")
+	if strings.HasPrefix(strings.ToLower(args), "cl ") {
+		args = args[len("cl "):]
+	}
+	usage := `Usage: "unsubscribe cl <pattern | all>"`
+
+	subscriptions, err := b.userSubscriptions(ctx, event.User)
+	if err != nil {
+		b.logf("could not load the subscriptions: %v\n", err)
+		b.directReply(ctx, event, `Could not unsubscribe, please try again`)
+		return
+	}
+
+	remove := []Subscription{}
+	if strings.EqualFold(strings.TrimSpace(args), "all") {
+		remove = subscriptions
+	} else {
+		pattern, err := parseCLPattern(args)
+		if err != nil {
+			b.directReply(ctx, event, usage)
+			return
+		}
+		for _, subscription := range subscriptions {
+			if subscription.Pattern == pattern.String() {
+				remove = append(remove, subscription)
+			}
+		}
+		if len(remove) == 0 {
+			b.directReply(ctx, event, fmt.Sprintf("You are not subscribed to `%s`", pattern))
+			return
+		}
+	}
+
+	for _, subscription := range remove {
+		err := b.store.DeleteSubscription(ctx, subscription.ID)
+		if err != nil && err != ErrSubscriptionNotFound {
+			b.logf("could not delete the subscription %s: %v\n", subscription.ID, err)
+			b.directReply(ctx, event, `Could not unsubscribe, please try again`)
+			return
+		}
+	}
+
+	if len(remove) == 1 {
+		b.directReply(ctx, event, fmt.Sprintf("Unsubscribed from `%s`", remove[0].Pattern))
+		return
+	}
+	b.directReply(ctx, event, fmt.Sprintf("Removed %d subscriptions", len(remove)))
+}
+
+func mySubscriptions(ctx context.Context, b *Bot, event *slack.MessageEvent) {
+	subscriptions, err := b.userSubscriptions(ctx, event.User)
+	if err != nil {
+		b.logf("could not load the subscriptions: %v\n", err)
+		b.directReply(ctx, event, `Could not load your subscriptions, please try again`)
+		return
+	}
+	if len(subscriptions) == 0 {
+		b.directReply(ctx, event, "You have no subscriptions, use `subscribe cl <pattern>` to get the CLs you care about")
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	buff.WriteString("You are subscribed to")
+	for _, subscription := range subscriptions {
+		buff.WriteString(fmt.Sprintf("\n- `%s` since %s", subscription.Pattern, subscription.CreatedAt.UTC().Format("2006-01-02")))
+	}
+
+	b.directReply(ctx, event, buff.String())
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+)
+
+type (
+	// Span is a timed operation which is part of a trace
+	Span interface {
+		// NewChild starts a new span as a child of this one
+		NewChild(name string) Span
+		// SetLabel attaches a key / value pair to the span
+		SetLabel(key, value string)
+		// Finish ends the span
+		Finish()
+	}
+
+	// Tracer creates spans and exports them to its backend
+	Tracer interface {
+		// NewSpan starts a new root span
+		NewSpan(name string) Span
+		// SpanFromRequest starts a span for an incoming HTTP request
+		SpanFromRequest(r *http.Request) Span
+		// HTTPClient wraps the client so that outgoing requests are traced
+		HTTPClient(orig *http.Client) Client
+		// Close flushes the pending spans and releases the resources of the tracer
+		Close() error
+	}
+
+	noopTracer struct{}
+
+	noopSpan struct{}
+
+	spanContextKey struct{}
+)
+
+// NewNoopTracer creates a Tracer which records nothing
+func NewNoopTracer() Tracer {
+	return noopTracer{}
+}
+
+func (noopTracer) NewSpan(name string) Span             { return noopSpan{} }
+func (noopTracer) SpanFromRequest(r *http.Request) Span { return noopSpan{} }
+func (noopTracer) HTTPClient(orig *http.Client) Client  { return orig }
+func (noopTracer) Close() error                         { return nil }
+func (noopSpan) NewChild(name string) Span              { return noopSpan{} }
+func (noopSpan) SetLabel(key, value string)             {}
+func (noopSpan) Finish()                                {}
+
+// NewSpanContext returns a context which carries the span
+func NewSpanContext(ctx context.Context, span Span) context.Context {
+	if cs, ok := span.(*cloudSpan); ok {
+		ctx = cloudSpanContext(ctx, cs)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by the context, or one which records nothing
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanContextKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// cloudTraceFlushDelay is enough for the trace client to upload the pending spans
+const cloudTraceFlushDelay = 3 * time.Second
+
+type (
+	cloudTracer struct {
+		client *trace.Client
+	}
+
+	cloudSpan struct {
+		span *trace.Span
+	}
+)
+
+// NewCloudTracer creates a Tracer which sends the spans to Google Cloud Trace
+func NewCloudTracer(client *trace.Client) Tracer {
+	return &cloudTracer{client: client}
+}
+
+func (t *cloudTracer) NewSpan(name string) Span {
+	return &cloudSpan{span: t.client.NewSpan(name)}
+}
+
+func (t *cloudTracer) SpanFromRequest(r *http.Request) Span {
+	return &cloudSpan{span: t.client.SpanFromRequest(r)}
+}
+
+func (t *cloudTracer) HTTPClient(orig *http.Client) Client {
+	return t.client.NewHTTPClient(orig)
+}
+
+func (t *cloudTracer) Close() error {
+	// the client uploads the finished spans in bundles, at least every two
+	// seconds, and has no way to flush them on demand
+	time.Sleep(cloudTraceFlushDelay)
+	return nil
+}
+
+func (s *cloudSpan) NewChild(name string) Span {
+	return &cloudSpan{span: s.span.NewChild(name)}
+}
+
+func (s *cloudSpan) SetLabel(key, value string) {
+	s.span.SetLabel(key, value)
+}
+
+func (s *cloudSpan) Finish() {
+	s.span.Finish()
+}
+
+// cloudSpanContext lets the traced HTTP client find the parent span
+func cloudSpanContext(ctx context.Context, s *cloudSpan) context.Context {
+	return trace.NewContext(ctx, s.span)
+}
@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// fileSpanRecord is how a finished span is written to the file, one per line
+	fileSpanRecord struct {
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Name       string            `json:"name"`
+		Start      time.Time         `json:"start"`
+		End        time.Time         `json:"end"`
+		DurationMS float64           `json:"duration_ms"`
+		Labels     map[string]string `json:"labels,omitempty"`
+	}
+
+	fileTracer struct {
+		mu   sync.Mutex
+		file *os.File
+		enc  *json.Encoder
+		logf Logger
+	}
+
+	fileSpan struct {
+		tracer *fileTracer
+
+		mu     sync.Mutex
+		record fileSpanRecord
+		done   bool
+	}
+
+	tracedClient struct {
+		orig Client
+	}
+)
+
+// NewFileTracer creates a Tracer which appends the finished spans, as JSON
+// lines, to the given file
+func NewFileTracer(path string, log Logger) (Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileTracer{
+		file: file,
+		enc:  json.NewEncoder(file),
+		logf: log,
+	}, nil
+}
+
+func randomID(size int) string {
+	buff := make([]byte, size)
+	if _, err := rand.Read(buff); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buff)
+}
+
+func (t *fileTracer) newSpan(traceID, parentID, name string) *fileSpan {
+	return &fileSpan{
+		tracer: t,
+		record: fileSpanRecord{
+			TraceID:  traceID,
+			SpanID:   randomID(8),
+			ParentID: parentID,
+			Name:     name,
+			Start:    time.Now(),
+		},
+	}
+}
+
+func (t *fileTracer) NewSpan(name string) Span {
+	return t.newSpan(randomID(16), "", name)
+}
+
+func (t *fileTracer) SpanFromRequest(r *http.Request) Span {
+	span := t.newSpan(randomID(16), "", r.URL.Path)
+	span.SetLabel("http.method", r.Method)
+	span.SetLabel("http.url", r.URL.String())
+	return span
+}
+
+func (t *fileTracer) HTTPClient(orig *http.Client) Client {
+	return &tracedClient{orig: orig}
+}
+
+func (t *fileTracer) write(record fileSpanRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return
+	}
+	if err := t.enc.Encode(record); err != nil {
+		t.logf("failed to write span %s: %v\n", record.Name, err)
+	}
+}
+
+func (t *fileTracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return nil
+	}
+
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+func (s *fileSpan) NewChild(name string) Span {
+	return s.tracer.newSpan(s.record.TraceID, s.record.SpanID, name)
+}
+
+func (s *fileSpan) SetLabel(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	if s.record.Labels == nil {
+		s.record.Labels = map[string]string{}
+	}
+	s.record.Labels[key] = value
+}
+
+func (s *fileSpan) Finish() {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.record.End = time.Now()
+	s.record.DurationMS = float64(s.record.End.Sub(s.record.Start)) / float64(time.Millisecond)
+	record := s.record
+	s.mu.Unlock()
+
+	s.tracer.write(record)
+}
+
+// Do records the outgoing request as a child of the span in the request context
+func (c *tracedClient) Do(req *http.Request) (*http.Response, error) {
+	span := SpanFromContext(req.Context()).NewChild(req.Method + " " + req.URL.Host)
+	span.SetLabel("http.url", req.URL.String())
+	defer span.Finish()
+
+	resp, err := c.orig.Do(req)
+	if err != nil {
+		span.SetLabel("error", err.Error())
+		return resp, err
+	}
+	span.SetLabel("http.status_code", strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
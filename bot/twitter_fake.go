@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// FakeTweeter is a Tweeter which records the tweets instead of posting them
+type FakeTweeter struct {
+	mu     sync.Mutex
+	tweets []string
+
+	// ScreenName is the account the tweets appear to come from
+	ScreenName string
+	// Err, when set, is returned by every call
+	Err error
+}
+
+// PostTweet records the tweet
+func (f *FakeTweeter) PostTweet(status string, v url.Values) (anaconda.Tweet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return anaconda.Tweet{}, f.Err
+	}
+
+	f.tweets = append(f.tweets, status)
+	tweet := anaconda.Tweet{Text: status, IdStr: fmt.Sprintf("%d", 1000+len(f.tweets))}
+	tweet.User.ScreenName = f.ScreenName
+	return tweet, nil
+}
+
+// Tweets returns the tweets posted so far
+func (f *FakeTweeter) Tweets() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.tweets...)
+}
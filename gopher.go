@@ -23,13 +23,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gopheracademy/gopher/bot"
@@ -39,11 +42,16 @@ import (
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/gorilla/mux"
 	"github.com/nlopes/slack"
-	"golang.org/x/net/context"
 	"google.golang.org/api/option"
 )
 
-const gerritLink = "https://go-review.googlesource.com/changes/?q=status:merged&O=12&n=100"
+const (
+	// the options ask for the current revision, its commit and touched files, and the names of the owners
+	gerritLink = "https://go-review.googlesource.com/changes/?q=status:merged&o=CURRENT_REVISION&o=CURRENT_COMMIT&o=CURRENT_FILES&o=DETAILED_ACCOUNTS&n=100"
+
+	// shutdownTimeout fits in the 30 seconds Kubernetes waits before killing the pod
+	shutdownTimeout = 25 * time.Second
+)
 
 var (
 	botVersion = "HEAD"
@@ -60,6 +68,14 @@ func main() {
 	twitterAccessToken := os.Getenv("GOPHER_SLACK_BOT_TWITTER_ACCESS_TOKEN")
 	twitterAccessTokenSecret := os.Getenv("GOPHER_SLACK_BOT_TWITTER_ACCESS_TOKEN_SECRET")
 	devMode := os.Getenv("GOPHERS_SLACK_BOT_DEV_MODE") == "true"
+	storeKind := os.Getenv("GOPHERS_SLACK_BOT_STORE")
+	storePath := os.Getenv("GOPHERS_SLACK_BOT_STORE_PATH")
+	configPath := os.Getenv("GOPHERS_SLACK_BOT_CONFIG")
+	traceKind := os.Getenv("GOPHERS_SLACK_BOT_TRACE")
+	tracePath := os.Getenv("GOPHERS_SLACK_BOT_TRACE_PATH")
+	eventsKind := os.Getenv("GOPHERS_SLACK_BOT_EVENTS")
+	signingSecret := os.Getenv("GOPHERS_SLACK_BOT_SIGNING_SECRET")
+	adminToken := os.Getenv("GOPHERS_SLACK_BOT_ADMIN_TOKEN")
 
 	if slackBotToken == "" {
 		log.Fatalln("slack bot token must be set in GOPHERS_SLACK_BOT_TOKEN")
@@ -72,6 +88,39 @@ func main() {
 		botName = "tempbot"
 	}
 
+	if storeKind == "" {
+		storeKind = bot.StoreDatastore
+	}
+
+	if storeKind == bot.StoreFile && storePath == "" {
+		log.Fatalln("the store file must be set in GOPHERS_SLACK_BOT_STORE_PATH")
+	}
+
+	if eventsKind == "" {
+		eventsKind = "rtm"
+	}
+
+	if eventsKind != "rtm" && eventsKind != "http" {
+		log.Fatalf("unknown events source %q, use one of: rtm, http", eventsKind)
+	}
+
+	if eventsKind == "http" && signingSecret == "" {
+		log.Fatalln("the Slack signing secret must be set in GOPHERS_SLACK_BOT_SIGNING_SECRET")
+	}
+
+	if configPath == "" {
+		configPath = "config.json"
+	}
+
+	config, err := bot.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("failed to load the configuration: %v", err)
+	}
+
+	if traceKind == "file" && tracePath == "" {
+		log.Fatalln("the trace file must be set in GOPHERS_SLACK_BOT_TRACE_PATH")
+	}
+
 	if twitterConsumerKey == "" {
 		log.Fatalln("missing GOPHER_SLACK_BOT_TWITTER_CONSUMER_KEY")
 	}
@@ -100,15 +149,37 @@ func main() {
 		},
 	}
 
-	ctx := context.Background()
+	lifecycle := bot.NewLifecycle(context.Background(), log.Printf)
+	ctx := lifecycle.Context()
 	projectID := "gophers-slack-bot"
 
-	traceClient, err := trace.NewClient(ctx, projectID, option.WithServiceAccountFile("/tmp/trace/trace.json"))
+	var tracer bot.Tracer
+	switch traceKind {
+	case "", "none":
+		tracer = bot.NewNoopTracer()
+	case "cloud":
+		traceClient, err := trace.NewClient(ctx, projectID, option.WithServiceAccountFile("/tmp/trace/trace.json"))
+		if err != nil {
+			log.Fatalf("Failed to create the trace client: %v", err)
+		}
+		tracer = bot.NewCloudTracer(traceClient)
+	case "file":
+		var err error
+		tracer, err = bot.NewFileTracer(tracePath, log.Printf)
+		if err != nil {
+			log.Fatalf("Failed to open the trace file %s: %v", tracePath, err)
+		}
+	default:
+		log.Fatalf("unknown tracer %q, use one of: none, cloud, file", traceKind)
+	}
+	lifecycle.OnShutdown("tracer", func(ctx context.Context) error {
+		return tracer.Close()
+	})
 
-	startupSpan := traceClient.NewSpan("b.main")
-	ctx = trace.NewContext(ctx, startupSpan)
+	startupSpan := tracer.NewSpan("b.main")
+	ctx = bot.NewSpanContext(ctx, startupSpan)
 
-	traceHttpClient := traceClient.NewHTTPClient(httpClient)
+	traceHttpClient := tracer.HTTPClient(httpClient)
 
 	slack.SetHTTPClient(traceHttpClient)
 	slackBotAPI := slack.New(slackBotToken)
@@ -119,21 +190,42 @@ func main() {
 	anaconda.SetConsumerSecret(twitterConsumerSecret)
 	twitterAPI := anaconda.NewTwitterApi(twitterAccessToken, twitterAccessTokenSecret)
 
-	rtmOptions := &slack.RTMOptions{}
-	slackBotRTM := slackBotAPI.NewRTMWithOptions(rtmOptions)
-	go slackBotRTM.ManageConnection()
-	runtime.Gosched()
+	var slackBotRTM *slack.RTM
+	if eventsKind == "rtm" {
+		rtmOptions := &slack.RTMOptions{}
+		slackBotRTM = slackBotAPI.NewRTMWithOptions(rtmOptions)
+		go slackBotRTM.ManageConnection()
+		runtime.Gosched()
+	}
 
-	dsClient, err := datastore.NewClient(ctx, projectID, option.WithServiceAccountFile("/tmp/datastore/datastore.json"))
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+	var store bot.Store
+	switch storeKind {
+	case bot.StoreDatastore:
+		dsClient, err := datastore.NewClient(ctx, projectID, option.WithServiceAccountFile("/tmp/datastore/datastore.json"))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		store = bot.NewDatastoreStore(dsClient)
+	case bot.StoreMemory:
+		store = bot.NewMemoryStore()
+	case bot.StoreFile:
+		var err error
+		store, err = bot.NewFileStore(storePath)
+		if err != nil {
+			log.Fatalf("Failed to open the store file %s: %v", storePath, err)
+		}
+	default:
+		log.Fatalf("unknown store %q, use one of: %s, %s, %s", storeKind, bot.StoreDatastore, bot.StoreMemory, bot.StoreFile)
 	}
-	defer dsClient.Close()
+	lifecycle.OnShutdown("store", func(ctx context.Context) error {
+		return store.Close()
+	})
 
-	b := bot.NewBot(slackBotAPI, dsClient, traceClient, twitterAPI, traceHttpClient, gerritLink, botName, slackBotToken, botVersion, devMode, log.Printf)
-	if err := b.Init(ctx, slackBotRTM, startupSpan); err != nil {
+	b := bot.NewBot(bot.NewSlackMessenger(slackBotAPI), store, tracer, twitterAPI, traceHttpClient, config, gerritLink, botName, slackBotToken, botVersion, devMode, log.Printf)
+	if err := b.Init(ctx, startupSpan); err != nil {
 		panic(err)
 	}
+	lifecycle.OnShutdown("handlers", b.Wait)
 
 	_, err = b.GetLastSeenCL(ctx)
 	if err != nil {
@@ -141,35 +233,37 @@ func main() {
 		panic(err)
 	}
 
-	go func() {
-		<-time.After(1 * time.Second)
-		for i := 0; i < 7; i++ {
-			b.MonitorGerrit(30 * time.Minute)
-			log.Printf("monitoring Gerrit failed %d times\n", i+1)
-			if i == 6 {
-				break
-			}
-			time.Sleep(time.Duration(i*10) * time.Second)
-		}
-		panic("monitoring Gerrit was terminated")
-	}()
+	lifecycle.Go("jobs", b.Jobs().Run)
+
+	if slackBotRTM != nil {
+		lifecycle.Go("message loop", func(ctx context.Context) {
+			defer slackBotRTM.Disconnect()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
 
-	go func() {
-		for msg := range slackBotRTM.IncomingEvents {
-			switch message := msg.Data.(type) {
-			case *slack.MessageEvent:
-				go b.HandleMessage(message)
+				case msg := <-slackBotRTM.IncomingEvents:
+					switch message := msg.Data.(type) {
+					case *slack.MessageEvent:
+						b.Go(func() { b.HandleMessage(message) })
 
-			case *slack.TeamJoinEvent:
-				go b.TeamJoined(message)
+					case *slack.TeamJoinEvent:
+						b.Go(func() { b.TeamJoined(message) })
+
+					case *slack.ReactionAddedEvent:
+						b.Go(func() { b.ReactionAdded(message) })
+					}
+				}
 			}
-		}
-	}()
+		})
+	}
 
-	go func(traceClient *trace.Client) {
-		healthz := func(traceClient *trace.Client) http.HandlerFunc {
+	lifecycle.Go("http server", func(ctx context.Context) {
+		healthz := func(tracer bot.Tracer) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
-				span := traceClient.SpanFromRequest(r)
+				span := tracer.SpanFromRequest(r)
 				defer span.Finish()
 
 				w.Header().Add("Content-Type", "application/json")
@@ -180,10 +274,30 @@ func main() {
 
 		r := mux.NewRouter()
 
-		r.HandleFunc("/healthz", healthz(traceClient)).
+		r.HandleFunc("/healthz", healthz(tracer)).
 			Name("info").
 			Methods("GET")
 
+		if signingSecret != "" {
+			r.HandleFunc("/slack/events", b.EventsHandler(signingSecret)).
+				Name("events").
+				Methods("POST")
+
+			r.HandleFunc("/slack/commands", b.SlashCommandHandler(signingSecret)).
+				Name("commands").
+				Methods("POST")
+
+			r.HandleFunc("/slack/actions", b.InteractionHandler(signingSecret)).
+				Name("actions").
+				Methods("POST")
+		}
+
+		if adminToken != "" {
+			r.HandleFunc("/admin/backfill", b.BackfillHandler(ctx, adminToken)).
+				Name("backfill").
+				Methods("POST")
+		}
+
 		s := http.Server{
 			Addr:         ":8081",
 			Handler:      r,
@@ -191,19 +305,50 @@ func main() {
 			WriteTimeout: 10 * time.Second,
 		}
 
-		log.Fatal(s.ListenAndServe())
-	}(traceClient)
-
-	go func(){
-		gotimefm := time.NewTicker(1 * time.Minute)
-		defer gotimefm.Stop()
+		errs := make(chan error, 1)
+		go func() {
+			errs <- s.ListenAndServe()
+		}()
+
+		select {
+		case err := <-errs:
+			lifecycle.Stop(err)
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := s.Shutdown(shutdownCtx); err != nil {
+				log.Printf("failed to stop the http server: %v\n", err)
+			}
+		}
+	})
+
+	lifecycle.Go("config reload", func(ctx context.Context) {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		defer signal.Stop(reload)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+			}
 
-		for range gotimefm.C {
-			b.GoTimeFM()
+			if err := b.ReloadConfig(ctx); err != nil {
+				log.Printf("failed to reload the configuration: %v\n", err)
+				continue
+			}
+			log.Println("configuration reloaded")
 		}
-	}()
+	})
+
+	lifecycle.StopOnSignal(syscall.SIGTERM, os.Interrupt)
 
 	log.Println("Gopher is now running")
 	startupSpan.Finish()
-	select {}
+
+	if err := lifecycle.Wait(shutdownTimeout); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Gopher stopped")
 }